@@ -1,15 +1,28 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/1karp/ads_api/internal/app/config"
 	"github.com/1karp/ads_api/internal/app/database"
+	"github.com/1karp/ads_api/internal/app/expiry"
 	"github.com/1karp/ads_api/internal/app/handlers"
 	"github.com/1karp/ads_api/internal/app/logging"
+	"github.com/1karp/ads_api/internal/app/outbox"
+	"github.com/1karp/ads_api/internal/app/publisher"
+	"github.com/1karp/ads_api/internal/app/render"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/response"
 	"github.com/1karp/ads_api/internal/app/router"
+	"github.com/1karp/ads_api/internal/app/schedule"
+	"github.com/1karp/ads_api/internal/app/storage"
+	"github.com/1karp/ads_api/internal/app/telegram"
 	"github.com/joho/godotenv"
 )
 
@@ -19,6 +32,11 @@ func main() {
 		slog.Error("Error loading .env file", "error", err)
 	}
 
+	// Schema migrations can be managed without starting the server
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
@@ -30,20 +48,130 @@ func main() {
 	logger := logging.SetupLogging(cfg)
 	slog.SetDefault(logger)
 
+	// Internal error details only leave the server outside production
+	response.Debug = cfg.Environment != "production"
+
 	// Initialize database
 	db := database.InitializeDatabase()
 	defer db.Close()
 
-	// Initialize handlers
-	handlers.InitDB(db)
+	// Initialize repositories and handlers
+	adRepo := repository.NewPostgresAdRepository(db)
+	userRepo := repository.NewPostgresUserRepository(db)
+	if cfg.TelegramBotToken == "" {
+		slog.Warn("TELEGRAM_BOT_TOKEN not set; posting to Telegram will fail")
+	}
+	if cfg.TelegramChannelID == "" {
+		slog.Warn("TELEGRAM_CHANNEL_ID not set; ads are only posted to the channels configured at /channels")
+	}
+	tg := telegram.NewClient(cfg.TelegramAPIURL, cfg.TelegramBotToken, &http.Client{Timeout: cfg.TelegramTimeout})
+	jobRepo := repository.NewPostgresJobRepository(db)
+	transitionRepo := repository.NewPostgresTransitionRepository(db)
+	channelRepo := repository.NewPostgresChannelRepository(db)
+	media := &storage.Media{Backend: newStorage(cfg), BaseURL: cfg.MediaBaseURL}
+	captions, err := render.New(cfg.CaptionTemplatesDir)
+	if err != nil {
+		slog.Error("Failed to load caption templates", "error", err)
+		os.Exit(1)
+	}
+	pub := publisher.New(tg, cfg.TelegramChannelID, media, captions)
+	pub.FullTextReply = cfg.TelegramFullTextReply
+	pub.Channels = channelRepo
+
+	// Messages posted before channels were recorded all went to the default channel
+	if cfg.TelegramChannelID != "" {
+		adopted, err := adRepo.AdoptLegacyMessages(context.Background(), cfg.TelegramChannelID)
+		if err != nil {
+			slog.Error("Failed to record the channel of legacy Telegram messages", "error", err)
+			os.Exit(1)
+		}
+		if adopted > 0 {
+			slog.Info("Recorded the channel of legacy Telegram messages", "messages", adopted, "channel_id", cfg.TelegramChannelID)
+		}
+	}
+	scheduler := schedule.New(jobRepo, newPacing(cfg))
+	adHandler := handlers.NewAdHandler(adRepo, userRepo, jobRepo, transitionRepo, pub, media)
+	adHandler.AutoSync = cfg.TelegramAutoSync
+	adHandler.Scheduler = scheduler
+	adHandler.BumpCooldown = cfg.BumpCooldown
+	userHandler := handlers.NewUserHandler(userRepo, adRepo, transitionRepo, pub)
+	mediaHandler := handlers.NewMediaHandler(media.Backend)
+	channelHandler := handlers.NewChannelHandler(channelRepo)
+	queueHandler := handlers.NewQueueHandler(scheduler)
 
 	// Setup router
-	r := router.SetupRoutes()
+	r := router.SetupRoutes(adHandler, userHandler, mediaHandler, channelHandler, queueHandler, cfg.AdminToken)
+
+	// Start the outbox worker that performs queued Telegram calls
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	workerCfg := outbox.DefaultConfig()
+	workerCfg.PollInterval = cfg.OutboxPollInterval
+	workerCfg.MaxAttempts = cfg.OutboxMaxAttempts
+	worker := outbox.NewWorker(jobRepo, adRepo, pub, media, workerCfg)
+	worker.Scheduler = scheduler
+	go worker.Run(ctx)
+
+	// Expire listings that outlived their TTL
+	expirer := expiry.New(adRepo, transitionRepo, newTTL(cfg))
+	go expirer.Run(ctx, cfg.ExpiryInterval)
+
+	// Pick up edited caption templates without a restart
+	go captions.Watch(ctx, cfg.CaptionTemplatesPoll)
 
 	// Start server
+	server := &http.Server{Addr: ":" + cfg.Port, Handler: r}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
 	slog.Info("Server starting", "port", cfg.Port)
-	if err := http.ListenAndServe(":"+cfg.Port, r); err != nil {
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		slog.Error("Server failed to start", "error", err)
 		os.Exit(1)
 	}
 }
+
+// newPacing returns how often albums may be posted to each channel.
+func newPacing(cfg *config.Config) schedule.Pacing {
+	quietStart, quietEnd, err := schedule.ParseQuietHours(cfg.QueueQuietHours)
+	if err != nil {
+		slog.Error("Invalid QUEUE_QUIET_HOURS", "error", err)
+		os.Exit(1)
+	}
+	location, err := time.LoadLocation(cfg.QueueTimezone)
+	if err != nil {
+		slog.Error("Invalid QUEUE_TIMEZONE", "error", err)
+		os.Exit(1)
+	}
+	return schedule.Pacing{Interval: cfg.QueueInterval, QuietStart: quietStart, QuietEnd: quietEnd, Location: location}
+}
+
+// newTTL returns how long listings of each ad type stay up.
+func newTTL(cfg *config.Config) expiry.TTL {
+	byType, err := expiry.ParseTTLs(cfg.AdTTLByType)
+	if err != nil {
+		slog.Error("Invalid AD_TTL_BY_TYPE", "error", err)
+		os.Exit(1)
+	}
+	return expiry.TTL{Default: cfg.AdTTL, ByType: byType}
+}
+
+// newStorage returns the backend uploaded photos are kept in.
+func newStorage(cfg *config.Config) storage.Backend {
+	if cfg.StorageBackend == "s3" {
+		if cfg.S3Bucket == "" {
+			slog.Warn("S3_BUCKET not set; photo uploads will fail")
+		}
+		return storage.NewS3(storage.S3Config{
+			Endpoint:        cfg.S3Endpoint,
+			Region:          cfg.S3Region,
+			Bucket:          cfg.S3Bucket,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+		}, &http.Client{Timeout: time.Minute})
+	}
+	return storage.NewLocal(cfg.StorageDir)
+}
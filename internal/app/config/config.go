@@ -1,14 +1,66 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"time"
 )
 
 type Config struct {
 	Environment string
 	Port        string
 	LogLevel    string
-	// Add other configuration fields as needed
+	AdminToken  string
+
+	TelegramBotToken  string
+	TelegramChannelID string
+	TelegramAPIURL    string
+	TelegramTimeout   time.Duration
+	// TelegramAutoSync queues a sync of the channel post whenever a
+	// posted ad is updated; requests can override it with ?sync=.
+	TelegramAutoSync bool
+	// TelegramFullTextReply sends the whole text of an ad whose caption
+	// had to be cut short as a reply to its album.
+	TelegramFullTextReply bool
+
+	// CaptionTemplatesDir holds per-channel caption templates; empty uses
+	// the built-in one. The directory is checked for changes every
+	// CaptionTemplatesPoll.
+	CaptionTemplatesDir  string
+	CaptionTemplatesPoll time.Duration
+
+	OutboxPollInterval time.Duration
+	OutboxMaxAttempts  int
+
+	// QueueInterval is the least time between two albums posted to one
+	// channel; zero posts them as soon as they are due. No albums are
+	// posted during QueueQuietHours, e.g. "23:00-07:00" in QueueTimezone.
+	QueueInterval   time.Duration
+	QueueQuietHours string
+	QueueTimezone   string
+
+	// AdTTL is how long a published ad stays listed before it expires;
+	// zero keeps listings up. AdTTLByType overrides it per ad type, e.g.
+	// "villa=1440h,studio=480h". Listings are checked every
+	// ExpiryInterval.
+	AdTTL          time.Duration
+	AdTTLByType    string
+	ExpiryInterval time.Duration
+	// BumpCooldown is the least time between two bumps of an ad.
+	BumpCooldown time.Duration
+
+	// StorageBackend selects where uploaded photos are kept: "local"
+	// (below StorageDir) or "s3".
+	StorageBackend string
+	StorageDir     string
+	MediaBaseURL   string
+
+	S3Endpoint        string
+	S3Region          string
+	S3Bucket          string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
 }
 
 func Load() (*Config, error) {
@@ -17,10 +69,98 @@ func Load() (*Config, error) {
 		port = "8000" // Default port
 	}
 
+	telegramTimeout, err := getPositiveDuration("TELEGRAM_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	outboxPollInterval, err := getPositiveDuration("OUTBOX_POLL_INTERVAL", 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	outboxMaxAttempts, err := getInt("OUTBOX_MAX_ATTEMPTS", 8)
+	if err != nil {
+		return nil, err
+	}
+
+	queueInterval, err := getDuration("QUEUE_INTERVAL", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	adTTL, err := getDuration("AD_TTL", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	expiryInterval, err := getPositiveDuration("EXPIRY_INTERVAL", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	bumpCooldown, err := getDuration("BUMP_COOLDOWN", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	telegramAutoSync, err := getBool("TELEGRAM_AUTO_SYNC", false)
+	if err != nil {
+		return nil, err
+	}
+
+	telegramFullTextReply, err := getBool("TELEGRAM_FULL_TEXT_REPLY", false)
+	if err != nil {
+		return nil, err
+	}
+
+	captionTemplatesPoll, err := getPositiveDuration("CAPTION_TEMPLATES_POLL", 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	storageBackend := getEnv("STORAGE_BACKEND", "local")
+	if storageBackend != "local" && storageBackend != "s3" {
+		return nil, fmt.Errorf("invalid STORAGE_BACKEND: %q", storageBackend)
+	}
+
 	return &Config{
 		Environment: getEnv("ENVIRONMENT", "development"),
 		Port:        port,
 		LogLevel:    getEnv("LOG_LEVEL", "info"),
+		AdminToken:  getEnv("ADMIN_TOKEN", ""),
+
+		TelegramBotToken:      getEnv("TELEGRAM_BOT_TOKEN", ""),
+		TelegramChannelID:     getEnv("TELEGRAM_CHANNEL_ID", ""),
+		TelegramAPIURL:        getEnv("TELEGRAM_API_URL", "https://api.telegram.org"),
+		TelegramTimeout:       telegramTimeout,
+		TelegramAutoSync:      telegramAutoSync,
+		TelegramFullTextReply: telegramFullTextReply,
+
+		CaptionTemplatesDir:  getEnv("CAPTION_TEMPLATES_DIR", ""),
+		CaptionTemplatesPoll: captionTemplatesPoll,
+
+		OutboxPollInterval: outboxPollInterval,
+		OutboxMaxAttempts:  outboxMaxAttempts,
+
+		QueueInterval:   queueInterval,
+		QueueQuietHours: getEnv("QUEUE_QUIET_HOURS", ""),
+		QueueTimezone:   getEnv("QUEUE_TIMEZONE", "UTC"),
+
+		AdTTL:          adTTL,
+		AdTTLByType:    getEnv("AD_TTL_BY_TYPE", ""),
+		ExpiryInterval: expiryInterval,
+		BumpCooldown:   bumpCooldown,
+
+		StorageBackend: storageBackend,
+		StorageDir:     getEnv("STORAGE_DIR", "media"),
+		MediaBaseURL:   getEnv("MEDIA_BASE_URL", "/media"),
+
+		S3Endpoint:        getEnv("S3_ENDPOINT", "https://s3.amazonaws.com"),
+		S3Region:          getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:          getEnv("S3_BUCKET", ""),
+		S3AccessKeyID:     getEnv("S3_ACCESS_KEY_ID", ""),
+		S3SecretAccessKey: getEnv("S3_SECRET_ACCESS_KEY", ""),
 	}, nil
 }
 
@@ -30,3 +170,52 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid %s: must not be negative", key)
+	}
+	return d, nil
+}
+
+// getPositiveDuration is getDuration for intervals and timeouts, which
+// have no meaning at zero.
+func getPositiveDuration(key string, fallback time.Duration) (time.Duration, error) {
+	d, err := getDuration(key, fallback)
+	if err == nil && d == 0 {
+		return 0, fmt.Errorf("invalid %s: must be greater than 0", key)
+	}
+	return d, err
+}
+
+func getInt(key string, fallback int) (int, error) {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return n, nil
+}
+
+func getBool(key string, fallback bool) (bool, error) {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return b, nil
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -9,37 +10,9 @@ import (
 	_ "github.com/lib/pq"
 )
 
-func initTables(db *sql.DB) error {
-	sqlStmt := `
-    CREATE TABLE IF NOT EXISTS users (
-		userid INTEGER PRIMARY KEY,
-		ads TEXT,
-		username TEXT
-	);
-
-    CREATE TABLE IF NOT EXISTS ads (
-        id SERIAL PRIMARY KEY,
-        user_id INTEGER NOT NULL,
-        username TEXT,
-        photos TEXT NOT NULL,
-        rooms TEXT,
-        price INTEGER,
-        type TEXT,
-        area INTEGER,
-        building TEXT,
-        district TEXT,
-        text TEXT,
-        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-        is_posted BOOLEAN DEFAULT FALSE,
-        chat_message_id INTEGER,
-        FOREIGN KEY(user_id) REFERENCES users(userid)
-    );
-    `
-	_, err := db.Exec(sqlStmt)
-	return err
-}
-
-func InitializeDatabase() *sql.DB {
+// Connect opens and pings the Postgres database configured through the
+// POSTGRES_* environment variables without touching the schema.
+func Connect() *sql.DB {
 	dbHost := os.Getenv("POSTGRES_HOST")
 	dbUser := os.Getenv("POSTGRES_USER")
 	dbPassword := os.Getenv("POSTGRES_PASSWORD")
@@ -58,8 +31,14 @@ func InitializeDatabase() *sql.DB {
 		log.Fatalf("Failed to ping database: %v", err)
 	}
 
-	if err := initTables(db); err != nil {
-		log.Fatalf("Failed to initialize database tables: %v", err)
+	return db
+}
+
+func InitializeDatabase() *sql.DB {
+	db := Connect()
+
+	if _, err := MigrateUp(context.Background(), db); err != nil {
+		log.Fatalf("Failed to apply database migrations: %v", err)
 	}
 
 	return db
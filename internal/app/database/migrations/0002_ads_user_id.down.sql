@@ -0,0 +1,9 @@
+ALTER TABLE users ADD COLUMN ads TEXT;
+
+UPDATE users u SET ads = (
+    SELECT string_agg(a.id::text, ',' ORDER BY a.id)
+    FROM ads a
+    WHERE a.user_id = u.userid
+);
+
+DROP INDEX IF EXISTS idx_ads_user_id;
@@ -0,0 +1,31 @@
+CREATE INDEX IF NOT EXISTS idx_ads_user_id ON ads(user_id);
+
+-- Ad ownership used to be duplicated in a comma-separated users.ads column.
+-- ads.user_id is authoritative from here on, so the old list is dropped, but
+-- only once it agrees with ads.user_id: an ad listed under another user than
+-- its user_id stops the migration, to be reconciled by hand, rather than
+-- losing the only record of who it may belong to. Databases that were
+-- upgraded before migrations existed have already dropped the column.
+DO $$
+DECLARE
+    mismatched TEXT;
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'users' AND column_name = 'ads'
+    ) THEN
+        SELECT string_agg(format('ad %s (user_id %s, listed by %s)', a.id, a.user_id, u.userid), ', ' ORDER BY a.id, u.userid)
+        INTO mismatched
+        FROM users u
+        JOIN ads a ON a.id::text = ANY(string_to_array(replace(u.ads, ' ', ''), ','))
+        WHERE a.user_id <> u.userid;
+
+        IF mismatched IS NOT NULL THEN
+            RAISE EXCEPTION 'users.ads disagrees with ads.user_id: %', mismatched
+                USING HINT = 'Fix ads.user_id or users.ads so they agree, then run the migration again.';
+        END IF;
+
+        ALTER TABLE users DROP COLUMN ads;
+    END IF;
+END
+$$;
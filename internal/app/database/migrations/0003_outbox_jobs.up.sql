@@ -0,0 +1,21 @@
+CREATE TABLE outbox_jobs (
+    id SERIAL PRIMARY KEY,
+    ad_id INTEGER NOT NULL REFERENCES ads(id),
+    kind TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+-- At most one unfinished job of each kind per ad, so a double-clicked
+-- "post" cannot enqueue the same album twice.
+CREATE UNIQUE INDEX idx_outbox_jobs_active ON outbox_jobs(ad_id, kind)
+    WHERE status IN ('pending', 'running');
+
+CREATE INDEX idx_outbox_jobs_due ON outbox_jobs(next_attempt_at)
+    WHERE status IN ('pending', 'running');
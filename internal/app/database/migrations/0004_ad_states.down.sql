@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS ad_transitions;
+
+ALTER TABLE ads DROP COLUMN IF EXISTS state;
@@ -0,0 +1,17 @@
+ALTER TABLE ads ADD COLUMN state TEXT NOT NULL DEFAULT 'draft'
+    CHECK (state IN ('draft', 'pending_review', 'published', 'rented', 'archived', 'expired', 'deleted'));
+
+UPDATE ads SET state = 'published' WHERE is_posted;
+
+CREATE INDEX idx_ads_state ON ads(state);
+
+CREATE TABLE ad_transitions (
+    id SERIAL PRIMARY KEY,
+    ad_id INTEGER NOT NULL REFERENCES ads(id) ON DELETE CASCADE,
+    from_state TEXT NOT NULL,
+    to_state TEXT NOT NULL,
+    note TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_ad_transitions_ad_id ON ad_transitions(ad_id);
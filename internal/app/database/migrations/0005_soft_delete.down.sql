@@ -0,0 +1,5 @@
+ALTER TABLE outbox_jobs DROP CONSTRAINT outbox_jobs_ad_id_fkey;
+ALTER TABLE outbox_jobs ADD CONSTRAINT outbox_jobs_ad_id_fkey
+    FOREIGN KEY (ad_id) REFERENCES ads(id);
+
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+
+-- Purging an ad takes its queued Telegram work with it.
+ALTER TABLE outbox_jobs DROP CONSTRAINT outbox_jobs_ad_id_fkey;
+ALTER TABLE outbox_jobs ADD CONSTRAINT outbox_jobs_ad_id_fkey
+    FOREIGN KEY (ad_id) REFERENCES ads(id) ON DELETE CASCADE;
@@ -0,0 +1,19 @@
+CREATE TABLE telegram_messages (
+    id SERIAL PRIMARY KEY,
+    ad_id INTEGER NOT NULL REFERENCES ads(id) ON DELETE CASCADE,
+    channel_id TEXT NOT NULL,
+    message_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    media_file_id TEXT,
+    UNIQUE (ad_id, channel_id, position)
+);
+
+-- Ads posted before this table existed only recorded their first message.
+-- Telegram numbers an album consecutively, so the others are derived from
+-- the photo count. The channel was not recorded; an empty channel_id
+-- stands for the configured TELEGRAM_CHANNEL_ID.
+INSERT INTO telegram_messages (ad_id, channel_id, message_id, position)
+SELECT a.id, '', a.chat_message_id + p.position, p.position
+FROM ads a
+CROSS JOIN LATERAL generate_series(0, array_length(string_to_array(a.photos, ','), 1) - 1) AS p(position)
+WHERE a.is_posted AND a.chat_message_id IS NOT NULL;
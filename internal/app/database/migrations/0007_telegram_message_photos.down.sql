@@ -0,0 +1 @@
+ALTER TABLE telegram_messages DROP COLUMN IF EXISTS photo_url;
@@ -0,0 +1,7 @@
+-- The photo each message shows, so edits can tell which slots changed.
+ALTER TABLE telegram_messages ADD COLUMN photo_url TEXT;
+
+UPDATE telegram_messages m
+SET photo_url = (string_to_array(a.photos, ','))[m.position + 1]
+FROM ads a
+WHERE a.id = m.ad_id;
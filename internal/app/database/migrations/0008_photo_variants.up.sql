@@ -0,0 +1,10 @@
+-- Variants produced by the image pipeline, keyed by the URL of the
+-- processed original as it appears in ads.photos.
+CREATE TABLE photo_variants (
+    ad_id INTEGER NOT NULL REFERENCES ads(id) ON DELETE CASCADE,
+    original_url TEXT NOT NULL,
+    large_url TEXT NOT NULL,
+    thumbnail_url TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (ad_id, original_url)
+);
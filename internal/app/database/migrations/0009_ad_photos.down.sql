@@ -0,0 +1,23 @@
+ALTER TABLE ads ADD COLUMN photos TEXT;
+
+UPDATE ads a
+SET photos = COALESCE((SELECT string_agg(p.url, ',' ORDER BY p.position) FROM ad_photos p WHERE p.ad_id = a.id), '');
+
+ALTER TABLE ads ALTER COLUMN photos SET NOT NULL;
+
+CREATE TABLE photo_variants (
+    ad_id INTEGER NOT NULL REFERENCES ads(id) ON DELETE CASCADE,
+    original_url TEXT NOT NULL,
+    large_url TEXT NOT NULL,
+    thumbnail_url TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (ad_id, original_url)
+);
+
+INSERT INTO photo_variants (ad_id, original_url, large_url, thumbnail_url)
+SELECT ad_id, url, large_url, thumbnail_url
+FROM ad_photos
+WHERE large_url IS NOT NULL AND thumbnail_url IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+DROP TABLE ad_photos;
@@ -0,0 +1,26 @@
+-- Photos move from the comma-separated ads.photos column, which broke on
+-- URLs containing commas, to a table of their own. The variants recorded
+-- by the image pipeline are folded in.
+CREATE TABLE ad_photos (
+    id SERIAL PRIMARY KEY,
+    ad_id INTEGER NOT NULL REFERENCES ads(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    width INTEGER,
+    height INTEGER,
+    caption TEXT,
+    large_url TEXT,
+    thumbnail_url TEXT,
+    -- Deferred so a reorder can swap positions within one transaction.
+    CONSTRAINT ad_photos_ad_id_position_key UNIQUE (ad_id, position) DEFERRABLE INITIALLY DEFERRED
+);
+
+INSERT INTO ad_photos (ad_id, position, url, large_url, thumbnail_url)
+SELECT a.id, p.position - 1, p.url, v.large_url, v.thumbnail_url
+FROM ads a
+CROSS JOIN LATERAL unnest(string_to_array(a.photos, ',')) WITH ORDINALITY AS p(url, position)
+LEFT JOIN photo_variants v ON v.ad_id = a.id AND v.original_url = p.url
+WHERE p.url <> '';
+
+DROP TABLE photo_variants;
+ALTER TABLE ads DROP COLUMN photos;
@@ -0,0 +1,4 @@
+-- Every write to an ad or user increments its version, which is exposed
+-- as the ETag for optimistic concurrency control.
+ALTER TABLE ads ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1 @@
+ALTER TABLE telegram_messages DROP COLUMN caption;
@@ -0,0 +1,3 @@
+-- The caption last sent with an album, kept on its first message, so a
+-- sync can skip editing a caption that did not change.
+ALTER TABLE telegram_messages ADD COLUMN caption TEXT;
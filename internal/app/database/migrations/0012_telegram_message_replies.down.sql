@@ -0,0 +1,2 @@
+DELETE FROM telegram_messages WHERE is_reply;
+ALTER TABLE telegram_messages DROP COLUMN is_reply;
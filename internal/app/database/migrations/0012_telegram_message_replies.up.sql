@@ -0,0 +1,3 @@
+-- The full text of an ad whose caption was cut short is sent as a reply
+-- to its album and recorded after the album's messages.
+ALTER TABLE telegram_messages ADD COLUMN is_reply BOOLEAN NOT NULL DEFAULT FALSE;
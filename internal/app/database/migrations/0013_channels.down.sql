@@ -0,0 +1,6 @@
+DROP INDEX idx_outbox_jobs_active;
+DELETE FROM outbox_jobs WHERE channel_id <> '' AND status IN ('pending', 'running');
+CREATE UNIQUE INDEX idx_outbox_jobs_active ON outbox_jobs(ad_id, kind)
+    WHERE status IN ('pending', 'running');
+ALTER TABLE outbox_jobs DROP COLUMN channel_id;
+DROP TABLE IF EXISTS channels;
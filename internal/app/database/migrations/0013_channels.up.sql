@@ -0,0 +1,18 @@
+-- Channels ads are routed to by district, type and price. Without any,
+-- ads go to TELEGRAM_CHANNEL_ID.
+CREATE TABLE channels (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL DEFAULT '',
+    districts TEXT[] NOT NULL DEFAULT '{}',
+    types TEXT[] NOT NULL DEFAULT '{}',
+    min_price INTEGER,
+    max_price INTEGER,
+    disabled BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+-- A publish job may target one channel; an empty channel_id routes the ad
+-- by the rules above. Jobs for different channels may run side by side.
+ALTER TABLE outbox_jobs ADD COLUMN channel_id TEXT NOT NULL DEFAULT '';
+DROP INDEX idx_outbox_jobs_active;
+CREATE UNIQUE INDEX idx_outbox_jobs_active ON outbox_jobs(ad_id, kind, channel_id)
+    WHERE status IN ('pending', 'running');
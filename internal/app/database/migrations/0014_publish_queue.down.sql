@@ -0,0 +1,3 @@
+DROP INDEX idx_outbox_jobs_queue;
+ALTER TABLE outbox_jobs DROP COLUMN publish_at;
+UPDATE outbox_jobs SET status = 'failed', last_error = 'cancelled' WHERE status = 'cancelled';
@@ -0,0 +1,6 @@
+-- Publish jobs may be scheduled for later; publish_at is the earliest time
+-- asked for, while next_attempt_at is the slot the scheduler picked.
+ALTER TABLE outbox_jobs ADD COLUMN publish_at TIMESTAMP;
+
+CREATE INDEX idx_outbox_jobs_queue ON outbox_jobs(channel_id, next_attempt_at)
+    WHERE kind = 'publish' AND status = 'pending';
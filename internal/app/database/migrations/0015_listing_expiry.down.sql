@@ -0,0 +1,2 @@
+DROP INDEX idx_ads_listed_at;
+ALTER TABLE ads DROP COLUMN listed_at;
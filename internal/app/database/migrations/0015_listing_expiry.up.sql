@@ -0,0 +1,8 @@
+-- listed_at is when the ad was last put in front of subscribers: when its
+-- post first went out after it was published, or when it was bumped to the
+-- top of its channels. Listings expire a TTL after it and
+-- cannot be bumped again until a cooldown after it.
+ALTER TABLE ads ADD COLUMN listed_at TIMESTAMP;
+UPDATE ads SET listed_at = created_at WHERE state = 'published';
+
+CREATE INDEX idx_ads_listed_at ON ads(listed_at) WHERE state = 'published';
@@ -0,0 +1,145 @@
+// Package expiry takes stale listings off the market. A published ad
+// expires a TTL, which may depend on its type, after it was last listed:
+// when its post went out after it was published, or when it was bumped to
+// the top of its channels; ads whose post has not gone out do not expire.
+// Expiring an ad moves it to the expired state, which marks its posts as no
+// longer available.
+package expiry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/validation"
+)
+
+// TTL is how long listings stay up. The zero value never expires them.
+type TTL struct {
+	// Default applies to ad types without a TTL of their own; zero keeps
+	// their listings up.
+	Default time.Duration
+	ByType  map[string]time.Duration
+}
+
+// ParseTTLs parses per-type TTLs written as "villa=1440h,studio=480h".
+// An empty string sets none.
+func ParseTTLs(s string) (map[string]time.Duration, error) {
+	ttls := map[string]time.Duration{}
+	if s == "" {
+		return ttls, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		adType, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("ad TTL %q: want type=duration", entry)
+		}
+		if !slices.Contains(validation.AdTypes, adType) {
+			return nil, fmt.Errorf("ad TTL %q: unknown ad type %q", entry, adType)
+		}
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf("ad TTL %q: invalid duration %q", entry, value)
+		}
+		ttls[adType] = ttl
+	}
+	return ttls, nil
+}
+
+// For returns the TTL of listings of adType, or zero when they do not
+// expire.
+func (t TTL) For(adType string) time.Duration {
+	if ttl, ok := t.ByType[adType]; ok {
+		return ttl
+	}
+	return t.Default
+}
+
+// shortest returns the least TTL that expires listings, or zero when
+// none does.
+func (t TTL) shortest() time.Duration {
+	shortest := t.Default
+	for _, ttl := range t.ByType {
+		if ttl > 0 && (shortest == 0 || ttl < shortest) {
+			shortest = ttl
+		}
+	}
+	return shortest
+}
+
+// Expirer moves published ads whose listing is older than their TTL to
+// the expired state.
+type Expirer struct {
+	ads         repository.AdRepository
+	transitions repository.TransitionRepository
+	ttl         TTL
+	now         func() time.Time
+}
+
+func New(ads repository.AdRepository, transitions repository.TransitionRepository, ttl TTL) *Expirer {
+	return &Expirer{ads: ads, transitions: transitions, ttl: ttl, now: func() time.Time { return time.Now().UTC() }}
+}
+
+// Run expires due listings every interval until ctx is cancelled. It
+// returns at once when no listing ever expires.
+func (e *Expirer) Run(ctx context.Context, interval time.Duration) {
+	if e.ttl.shortest() == 0 {
+		return
+	}
+	slog.Info("Listing expiry started", "interval", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := e.ExpireDue(ctx); err != nil {
+			slog.Error("Error expiring listings", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Info("Listing expiry stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExpireDue expires every published ad listed longer ago than its TTL,
+// queueing the edit of its captions, and returns how many it expired.
+// Ads that change state meanwhile are skipped.
+func (e *Expirer) ExpireDue(ctx context.Context) (int, error) {
+	shortest := e.ttl.shortest()
+	if shortest == 0 {
+		return 0, nil
+	}
+	now := e.now()
+	cutoff := now.Add(-shortest)
+	ads, err := e.ads.List(ctx, repository.AdFilter{States: []string{string(models.AdPublished)}, ListedBefore: &cutoff}, repository.ListOptions{Sort: repository.DefaultAdSort})
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, ad := range ads {
+		ttl := e.ttl.For(ad.Type)
+		if ttl == 0 || ad.ListedAt.Add(ttl).After(now) {
+			continue
+		}
+		t := models.AdTransition{AdID: ad.ID, From: models.AdPublished, To: models.AdExpired, Note: "listing expired"}
+		_, err := e.transitions.Transition(ctx, &t, []models.Job{{AdID: ad.ID, Kind: models.JobEditCaption}}, now)
+		if errors.Is(err, repository.ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return expired, fmt.Errorf("ad %d: %w", ad.ID, err)
+		}
+		slog.Info("Ad expired", "ad_id", ad.ID, "type", ad.Type, "listed_at", *ad.ListedAt)
+		expired++
+	}
+	return expired, nil
+}
@@ -0,0 +1,94 @@
+package expiry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const day = 24 * time.Hour
+
+func TestParseTTLs(t *testing.T) {
+	ttls, err := ParseTTLs("villa=1440h, studio=0s")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{"villa": 60 * day, "studio": 0}, ttls)
+
+	ttls, err = ParseTTLs("")
+	assert.NoError(t, err)
+	assert.Empty(t, ttls)
+
+	for _, s := range []string{"villa", "castle=720h", "villa=30d", "villa=-1h"} {
+		_, err := ParseTTLs(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	ttl := TTL{Default: 30 * day, ByType: map[string]time.Duration{"villa": 60 * day, "studio": 0}}
+	assert.Equal(t, 60*day, ttl.For("villa"))
+	assert.Equal(t, 30*day, ttl.For("apartment"))
+	assert.Equal(t, time.Duration(0), ttl.For("studio"))
+	assert.Equal(t, 30*day, ttl.shortest())
+
+	assert.Equal(t, 60*day, TTL{ByType: map[string]time.Duration{"villa": 60 * day}}.shortest())
+	assert.Equal(t, time.Duration(0), TTL{}.shortest())
+}
+
+func TestExpireDue(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	ads := repository.NewMemoryAdRepository()
+	jobs := repository.NewMemoryJobRepository(ads)
+	transitions := repository.NewMemoryTransitionRepository(ads, jobs)
+	e := New(ads, transitions, TTL{Default: 30 * day, ByType: map[string]time.Duration{"villa": 60 * day, "studio": 0}})
+	e.now = func() time.Time { return now }
+
+	create := func(adType string, state models.AdState, age time.Duration) models.Ad {
+		t.Helper()
+		listedAt := now.Add(-age)
+		ad := models.Ad{UserID: 1, Type: adType, State: state, ListedAt: &listedAt}
+		require.NoError(t, ads.Create(ctx, &ad))
+		return ad
+	}
+	stale := create("apartment", models.AdPublished, 31*day)
+	fresh := create("apartment", models.AdPublished, 29*day)
+	villa := create("villa", models.AdPublished, 45*day)
+	studio := create("studio", models.AdPublished, 365*day)
+	rented := create("apartment", models.AdRented, 31*day)
+
+	expired, err := e.ExpireDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+
+	for ad, want := range map[int]models.AdState{
+		stale.ID:  models.AdExpired,
+		fresh.ID:  models.AdPublished,
+		villa.ID:  models.AdPublished,
+		studio.ID: models.AdPublished,
+		rented.ID: models.AdRented,
+	} {
+		got, err := ads.Get(ctx, ad)
+		require.NoError(t, err)
+		assert.Equal(t, want, got.State, "ad %d", ad)
+	}
+
+	queued, err := jobs.ListByAd(ctx, stale.ID)
+	require.NoError(t, err)
+	if assert.Len(t, queued, 1) {
+		assert.Equal(t, models.JobEditCaption, queued[0].Kind)
+	}
+	history, err := transitions.ListTransitions(ctx, stale.ID)
+	require.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, "listing expired", history[0].Note)
+	}
+
+	expired, err = e.ExpireDue(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, expired, "expired ads are not expired again")
+}
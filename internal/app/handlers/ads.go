@@ -2,431 +2,748 @@ package handlers
 
 import (
 	"bytes"
-	"database/sql"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
+	"mime"
 	"net/http"
-	"os"
-	"strings"
-
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/patch"
+	"github.com/1karp/ads_api/internal/app/publisher"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/response"
+	"github.com/1karp/ads_api/internal/app/schedule"
+	"github.com/1karp/ads_api/internal/app/storage"
+	"github.com/1karp/ads_api/internal/app/telegram"
+	"github.com/1karp/ads_api/internal/app/validation"
 	"github.com/gorilla/mux"
-	"github.com/lib/pq"
-	_ "github.com/lib/pq"
 )
 
-type Ad struct {
-	ID            int    `json:"id"`
-	UserID        int    `json:"user_id"`
-	Username      string `json:"username"`
-	Photos        string `json:"photos"`
-	Rooms         string `json:"rooms"`
-	Price         int    `json:"price"`
-	Type          string `json:"type"`
-	Area          int    `json:"area"`
-	Building      string `json:"building"`
-	District      string `json:"district"`
-	Text          string `json:"text"`
-	CreatedAt     string `json:"created_at"`
-	IsPosted      int    `json:"is_posted"`
-	ChatMessageId int    `json:"chat_message_id"`
+type AdHandler struct {
+	ads         repository.AdRepository
+	users       repository.UserRepository
+	jobs        repository.JobRepository
+	transitions repository.TransitionRepository
+	publisher   *publisher.Publisher
+	media       *storage.Media
+
+	// AutoSync queues a sync of the channel post when a posted ad is
+	// updated. Requests override it with ?sync=true or ?sync=false.
+	AutoSync bool
+
+	// Scheduler plans posts into their channel's queue. Without it they
+	// are posted as soon as they are due.
+	Scheduler *schedule.Scheduler
+
+	// BumpCooldown is the least time between two bumps of an ad, counted
+	// from when it was last listed.
+	BumpCooldown time.Duration
 }
 
-var db *sql.DB
+func NewAdHandler(ads repository.AdRepository, users repository.UserRepository, jobs repository.JobRepository, transitions repository.TransitionRepository, pub *publisher.Publisher, media *storage.Media) *AdHandler {
+	return &AdHandler{ads: ads, users: users, jobs: jobs, transitions: transitions, publisher: pub, media: media}
+}
 
-func InitDB(database *sql.DB) {
-	db = database
+// adID parses the {id} route variable, writing a 404 when it is not a
+// valid ad id.
+func adID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		response.Error(w, r, http.StatusNotFound, response.CodeAdNotFound, "Ad not found")
+		return 0, false
+	}
+	return id, true
 }
 
-func CreateAd(w http.ResponseWriter, r *http.Request) {
-	var ad Ad
-	if err := json.NewDecoder(r.Body).Decode(&ad); err != nil {
-		slog.Error("Error decoding request body", "error", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+func (h *AdHandler) CreateAd(w http.ResponseWriter, r *http.Request) {
+	var ad models.Ad
+	if !decodeJSON(w, r, &ad) {
 		return
 	}
 
-	err := db.QueryRow(
-		"INSERT INTO ads (user_id, username, photos, rooms, price, type, area, building, district, text, is_posted, chat_message_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id",
-		ad.UserID, ad.Username, ad.Photos, ad.Rooms, ad.Price, ad.Type, ad.Area, ad.Building, ad.District, ad.Text, ad.IsPosted, ad.ChatMessageId,
-	).Scan(&ad.ID)
-	if err != nil {
-		slog.Error("Error inserting ad into database", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	errs := validation.Ad.Check(ad)
+	// Ads enter the lifecycle unpublished; later states are reached
+	// through transitions.
+	if ad.State != "" && ad.State != models.AdDraft && ad.State != models.AdPendingReview {
+		errs = append(errs, validation.FieldError{Field: "state", Message: "must be draft or pending_review"})
+	}
+	if len(errs) > 0 {
+		response.Invalid(w, r, errs)
 		return
 	}
 
-	_, err = db.Exec("UPDATE users SET ads = COALESCE(ads, '') || CASE WHEN ads = '' THEN $1::text ELSE ',' || $1::text END WHERE userid = $2", ad.ID, ad.UserID)
-	if err != nil {
-		slog.Error("Error updating user's ads field", "error", err)
+	if err := h.ads.Create(r.Context(), &ad); err != nil {
+		response.Internal(w, r, "Error inserting ad into database", err)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(ad); err != nil {
-		slog.Error("Error encoding response", "error", err)
-	}
+	h.warnTruncated(w, ad)
+	response.JSON(w, http.StatusCreated, ad)
 	slog.Info("Ad created successfully", "ad_id", ad.ID)
 }
 
-func GetAds(w http.ResponseWriter, r *http.Request) {
-	userid := r.URL.Query().Get("userid")
-	if userid != "" {
-		GetAdsByUserId(w, r)
+func (h *AdHandler) GetAds(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAdFilter(r.URL.Query())
+	if err != nil {
+		slog.Warn("Invalid ad filter", "error", err)
+		writeParamError(w, r, err)
 		return
 	}
 
-	rows, err := db.Query("SELECT id, user_id, username, photos, rooms, price, type, area, building, district, text, created_at, is_posted, chat_message_id FROM ads")
+	listAds(w, r, h.ads, h.users, filter)
+}
+
+// listAds writes the ads matching filter, honouring the pagination
+// parameters of r. When the filter is scoped to a user, that user must
+// exist.
+func listAds(w http.ResponseWriter, r *http.Request, ads repository.AdRepository, users repository.UserRepository, filter repository.AdFilter) {
+	p, err := parsePageRequest(r.URL.Query(), repository.ValidAdSort, repository.DefaultAdSort)
 	if err != nil {
-		slog.Error("Error querying ads from database", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.Warn("Invalid pagination parameters", "error", err)
+		writeParamError(w, r, err)
 		return
 	}
-	defer rows.Close()
 
-	var ads []Ad
-	for rows.Next() {
-		var ad Ad
-		if err := rows.Scan(&ad.ID, &ad.UserID, &ad.Username, &ad.Photos, &ad.Rooms, &ad.Price, &ad.Type, &ad.Area, &ad.Building, &ad.District, &ad.Text, &ad.CreatedAt, &ad.IsPosted, &ad.ChatMessageId); err != nil {
-			slog.Error("Error scanning ad row", "error", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	if filter.UserID != nil {
+		if _, err := users.Get(r.Context(), *filter.UserID); errors.Is(err, repository.ErrNotFound) {
+			response.Error(w, r, http.StatusNotFound, response.CodeUserNotFound, "User not found")
+			return
+		} else if err != nil {
+			response.Internal(w, r, "Error checking user", err)
 			return
 		}
-		ads = append(ads, ad)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(ads); err != nil {
-		slog.Error("Error encoding response", "error", err)
+	result, err := ads.List(r.Context(), filter, p.options())
+	if err != nil {
+		response.Internal(w, r, "Error querying ads from database", err)
+		return
 	}
-	slog.Info("Ads retrieved successfully", "count", len(ads))
+
+	writeAdList(w, p, result)
+	slog.Info("Ads retrieved successfully", "count", len(result))
 }
 
-func GetAdByID(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+func (h *AdHandler) GetAdByID(w http.ResponseWriter, r *http.Request) {
+	id, ok := adID(w, r)
+	if !ok {
+		return
+	}
 
-	var ad Ad
-	err := db.QueryRow("SELECT id, user_id, username, photos, rooms, price, type, area, building, district, text, created_at, is_posted, chat_message_id FROM ads WHERE id = $1", id).Scan(
-		&ad.ID, &ad.UserID, &ad.Username, &ad.Photos, &ad.Rooms, &ad.Price, &ad.Type, &ad.Area, &ad.Building, &ad.District, &ad.Text, &ad.CreatedAt, &ad.IsPosted, &ad.ChatMessageId,
-	)
+	ad, err := h.ads.Get(r.Context(), id)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "Ad not found", http.StatusNotFound)
+		if errors.Is(err, repository.ErrNotFound) {
+			response.Error(w, r, http.StatusNotFound, response.CodeAdNotFound, "Ad not found")
 		} else {
-			slog.Error("Error querying ad by ID", "error", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			response.Internal(w, r, "Error querying ad by ID", err)
 		}
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(ad); err != nil {
-		slog.Error("Error encoding response", "error", err)
+	if notModified(w, r, ad.Version) {
+		return
 	}
+	response.JSON(w, http.StatusOK, ad)
 	slog.Info("Ad retrieved successfully", "ad_id", ad.ID)
 }
 
-func GetAdsByUserId(w http.ResponseWriter, r *http.Request) {
-	userid := r.URL.Query().Get("userid")
-	if userid == "" {
-		http.Error(w, "Userid is required", http.StatusBadRequest)
+// UpdateAd replaces the editable fields of an ad. The state and the
+// Telegram post are managed by the server and kept as they are.
+func (h *AdHandler) UpdateAd(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.loadAd(w, r)
+	if !ok {
+		return
+	}
+	version, ok := ifMatch(w, r, existing.Version)
+	if !ok {
 		return
 	}
 
-	var adIDs string
-	err := db.QueryRow("SELECT ads FROM users WHERE userid = $1", userid).Scan(&adIDs)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "User not found", http.StatusNotFound)
-		} else {
-			slog.Error("Error querying user ads", "error", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	var ad models.Ad
+	if !decodeJSON(w, r, &ad) {
 		return
 	}
+	ad.ID = existing.ID
+	ad.Version = version
+	// The state only changes through transitions, but decides whether
+	// photos are required.
+	ad.State = existing.State
+	ad.ListedAt = existing.ListedAt
 
-	adIDSlice := strings.Split(adIDs, ",")
+	h.saveAd(w, r, existing, ad)
+}
 
-	query := fmt.Sprintf("SELECT id, user_id, username, photos, rooms, price, type, area, building, district, text, created_at, is_posted, chat_message_id FROM ads WHERE id = ANY($1::int[])")
+// PatchAd applies an RFC 7386 merge patch (application/merge-patch+json
+// or application/json) or an RFC 6902 JSON Patch
+// (application/json-patch+json) to an ad. The patched ad is validated
+// like a PUT body; fields managed by the server may not be changed.
+// The patch only applies to the version it was computed from.
+func (h *AdHandler) PatchAd(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.loadAd(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := ifMatch(w, r, existing.Version); !ok {
+		return
+	}
 
-	rows, err := db.Query(query, pq.Array(adIDSlice))
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		response.Error(w, r, http.StatusBadRequest, response.CodeMalformedBody, "Error reading request body")
+		return
+	}
+	doc, err := json.Marshal(existing)
 	if err != nil {
-		slog.Error("Error querying ads by IDs", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		response.Internal(w, r, "Error encoding ad", err, "ad_id", existing.ID)
 		return
 	}
-	defer rows.Close()
 
-	var ads []Ad
-	for rows.Next() {
-		var ad Ad
-		if err := rows.Scan(&ad.ID, &ad.UserID, &ad.Username, &ad.Photos, &ad.Rooms, &ad.Price, &ad.Type, &ad.Area, &ad.Building, &ad.District, &ad.Text, &ad.CreatedAt, &ad.IsPosted, &ad.ChatMessageId); err != nil {
-			slog.Error("Error scanning ad row", "error", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		ads = append(ads, ad)
+	var patched []byte
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case patch.MergePatchType, "application/json":
+		patched, err = patch.Merge(doc, body)
+	case patch.JSONPatchType:
+		patched, err = patch.Apply(doc, body)
+	default:
+		w.Header().Set("Accept-Patch", patch.MergePatchType+", "+patch.JSONPatchType)
+		response.Error(w, r, http.StatusUnsupportedMediaType, response.CodeUnsupportedMedia,
+			fmt.Sprintf("Content-Type must be %s or %s", patch.MergePatchType, patch.JSONPatchType))
+		return
+	}
+	if errors.Is(err, patch.ErrMalformed) {
+		response.Error(w, r, http.StatusBadRequest, response.CodeMalformedBody, err.Error())
+		return
+	}
+	if err != nil {
+		response.Error(w, r, http.StatusUnprocessableEntity, response.CodePatchFailed, err.Error())
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(ads); err != nil {
-		slog.Error("Error encoding response", "error", err)
+	var ad models.Ad
+	if !decodeJSONFrom(w, r, bytes.NewReader(patched), &ad) {
+		return
 	}
-	slog.Info("Ads retrieved successfully by user ID", "user_id", userid, "count", len(ads))
+	if errs := readOnlyChanges(existing, ad); len(errs) > 0 {
+		response.Invalid(w, r, errs)
+		return
+	}
+
+	h.saveAd(w, r, existing, ad)
 }
 
-func UpdateAd(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+// readOnlyChanges reports the server-managed fields a patch changed.
+func readOnlyChanges(existing, ad models.Ad) validation.Errors {
+	var errs validation.Errors
+	for _, f := range []struct {
+		field   string
+		changed bool
+	}{
+		{"id", ad.ID != existing.ID},
+		{"created_at", ad.CreatedAt != existing.CreatedAt},
+		{"state", ad.State != existing.State},
+		{"is_posted", ad.IsPosted != existing.IsPosted},
+		{"chat_message_id", ad.ChatMessageId != existing.ChatMessageId},
+		{"messages", !slices.Equal(ad.Messages, existing.Messages)},
+		{"version", ad.Version != existing.Version},
+		{"listed_at", !sameTime(ad.ListedAt, existing.ListedAt)},
+	} {
+		if f.changed {
+			errs = append(errs, validation.FieldError{Field: f.field, Message: "is read-only"})
+		}
+	}
+	return errs
+}
 
-	var ad Ad
-	if err := json.NewDecoder(r.Body).Decode(&ad); err != nil {
-		slog.Error("Error decoding request body", "error", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
 	}
+	return a.Equal(*b)
+}
 
-	var existingAd Ad
-	err := db.QueryRow("SELECT id FROM ads WHERE id = $1", id).Scan(&existingAd.ID)
-	if err == sql.ErrNoRows {
-		http.Error(w, "Ad not found", http.StatusNotFound)
+// saveAd validates and stores an updated ad, answering with the ad as
+// read back from the repository. When syncing is on and the change shows
+// in the channel, a sync of the post is queued.
+func (h *AdHandler) saveAd(w http.ResponseWriter, r *http.Request, existing, ad models.Ad) {
+	sync, err := h.syncRequested(r)
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
+	if errs := validation.Ad.Check(ad); len(errs) > 0 {
+		response.Invalid(w, r, errs)
 		return
-	} else if err != nil {
-		slog.Error("Error checking existing ad", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+
+	if err := h.ads.Update(r.Context(), &ad); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			response.Error(w, r, http.StatusNotFound, response.CodeAdNotFound, "Ad not found")
+		case errors.Is(err, repository.ErrStale):
+			writeStale(w, r)
+		default:
+			response.Internal(w, r, "Error updating ad in database", err)
+		}
 		return
 	}
 
-	_, err = db.Exec(
-		"UPDATE ads SET user_id = $1, username = $2, photos = $3, rooms = $4, price = $5, type = $6, area = $7, building = $8, district = $9, text = $10, is_posted = $11, chat_message_id = $12 WHERE id = $13",
-		ad.UserID, ad.Username, ad.Photos, ad.Rooms, ad.Price, ad.Type, ad.Area, ad.Building, ad.District, ad.Text, ad.IsPosted, ad.ChatMessageId, id,
-	)
+	stored, err := h.ads.Get(r.Context(), ad.ID)
 	if err != nil {
-		slog.Error("Error updating ad in database", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		response.Internal(w, r, "Error fetching updated ad", err, "ad_id", ad.ID)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(ad); err != nil {
-		slog.Error("Error encoding response", "error", err)
+	if sync && stored.IsPosted == 1 && h.postChanged(existing, stored) {
+		h.enqueueSync(r, stored.ID)
+	}
+
+	w.Header().Set("ETag", etag(stored.Version))
+	h.warnTruncated(w, stored)
+	response.JSON(w, http.StatusOK, stored)
+	slog.Info("Ad updated successfully", "ad_id", ad.ID)
+}
+
+// syncRequested reads ?sync=, falling back to AutoSync.
+func (h *AdHandler) syncRequested(r *http.Request) (bool, error) {
+	q := r.URL.Query()
+	sync := h.AutoSync
+	if q.Has("sync") {
+		var err error
+		if sync, err = strconv.ParseBool(q.Get("sync")); err != nil {
+			return false, &fieldError{Field: "sync", Message: "must be a boolean"}
+		}
+	}
+	return sync && h.jobs != nil && h.publisher != nil, nil
+}
+
+// postChanged reports whether an update changed what the channels show:
+// a rendered caption, a full text reply or the photos. A caption that
+// fails to render counts as changed so the sync job reports the error.
+func (h *AdHandler) postChanged(before, after models.Ad) bool {
+	if !slices.Equal(before.Photos.URLs(), after.Photos.URLs()) {
+		return true
+	}
+	for _, channelID := range h.publisher.PostedChannels(before) {
+		old, err := h.channelText(channelID, before)
+		if err != nil {
+			return true
+		}
+		text, err := h.channelText(channelID, after)
+		if err != nil || text != old {
+			return true
+		}
+	}
+	return false
+}
+
+// channelText returns the caption and full text reply ad is posted to
+// channelID with.
+func (h *AdHandler) channelText(channelID string, ad models.Ad) ([2]string, error) {
+	caption, err := h.publisher.Caption(channelID, ad)
+	if err != nil {
+		return [2]string{}, err
 	}
-	slog.Info("Ad updated successfully", "ad_id", id)
+	reply, err := h.publisher.Reply(channelID, ad)
+	return [2]string{caption, reply}, err
 }
 
-func PostAd(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+// truncatedWarning is the Warning header of responses for ads whose text
+// does not fit their caption.
+const truncatedWarning = `299 - "text will be truncated in the Telegram caption"`
 
-	var ad Ad
-	err := db.QueryRow("SELECT id, user_id, username, photos, rooms, price, type, area, building, district, text, is_posted, chat_message_id FROM ads WHERE id = $1", id).Scan(
-		&ad.ID, &ad.UserID, &ad.Username, &ad.Photos, &ad.Rooms, &ad.Price, &ad.Type, &ad.Area, &ad.Building, &ad.District, &ad.Text, &ad.IsPosted, &ad.ChatMessageId)
+// warnTruncated warns the client when the ad's text will be cut short in
+// its caption. The ad is valid regardless, so this never fails the request.
+func (h *AdHandler) warnTruncated(w http.ResponseWriter, ad models.Ad) {
+	if h.publisher == nil {
+		return
+	}
+	rendered, err := h.publisher.Render(h.publisher.ChannelID(), ad)
 	if err != nil {
-		slog.Error("Error fetching ad details", "error", err)
-		http.Error(w, "Error fetching ad details", http.StatusInternalServerError)
+		slog.Warn("Error rendering caption", "ad_id", ad.ID, "error", err)
 		return
 	}
+	if rendered.Truncated {
+		w.Header().Set("Warning", truncatedWarning)
+	}
+}
 
-	if ad.IsPosted == 1 {
-		http.Error(w, "Ad already posted", http.StatusBadRequest)
-		return
+// enqueueSync queues a sync of the ad's channel post. The update itself
+// has been stored, so failures are logged rather than answered; the post
+// can still be synced with /ads/{id}/edit-post.
+func (h *AdHandler) enqueueSync(r *http.Request, adID int) {
+	job := models.Job{AdID: adID, Kind: models.JobSync}
+	err := h.jobs.Enqueue(r.Context(), &job, time.Now().UTC())
+	switch {
+	case errors.Is(err, repository.ErrConflict):
+		slog.Info("Telegram sync already queued", "ad_id", adID)
+	case err != nil:
+		slog.Error("Error enqueuing Telegram sync", "ad_id", adID, "error", err, "request_id", response.RequestIDFrom(r.Context()))
+	default:
+		slog.Info("Telegram sync queued", "ad_id", adID, "job_id", job.ID)
+	}
+}
+
+// loadAd fetches the ad named by the {id} route variable, writing the
+// error response itself when it cannot.
+func (h *AdHandler) loadAd(w http.ResponseWriter, r *http.Request) (models.Ad, bool) {
+	id, ok := adID(w, r)
+	if !ok {
+		return models.Ad{}, false
 	}
 
-	err = postToTelegramChannel(ad)
+	ad, err := h.ads.Get(r.Context(), id)
 	if err != nil {
-		slog.Error("Error posting to Telegram", "error", err)
-		http.Error(w, "Error posting to Telegram", http.StatusInternalServerError)
+		if errors.Is(err, repository.ErrNotFound) {
+			response.Error(w, r, http.StatusNotFound, response.CodeAdNotFound, "Ad not found")
+		} else {
+			response.Internal(w, r, "Error fetching ad details", err)
+		}
+		return ad, false
+	}
+	return ad, true
+}
+
+// DeleteAd soft-deletes the ad, or purges it with ?hard=true. Hard
+// deletes must be guarded by RequireAdminForHardDelete.
+func (h *AdHandler) DeleteAd(w http.ResponseWriter, r *http.Request) {
+	ad, ok := h.loadAd(w, r)
+	if !ok {
+		return
+	}
+	version, ok := ifMatch(w, r, ad.Version)
+	if !ok {
 		return
 	}
 
-	_, err = db.Exec("UPDATE ads SET is_posted = 1 WHERE id = $1", id)
+	hard, _ := hardDelete(r)
+	remover := adRemover{ads: h.ads, transitions: h.transitions, publisher: h.publisher}
+	var err error
+	if hard {
+		err = remover.purge(r.Context(), ad, version)
+	} else {
+		err = remover.softDelete(r.Context(), ad, version)
+	}
 	if err != nil {
-		slog.Error("Error updating ad status", "error", err)
-		http.Error(w, "Error updating ad status", http.StatusInternalServerError)
+		writeDeleteError(w, r, "Error deleting ad", err, "ad_id", ad.ID, "hard", hard)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "Ad successfully posted to Telegram channel")
-	slog.Info("Ad posted successfully to Telegram", "ad_id", id)
+	w.WriteHeader(http.StatusNoContent)
+	slog.Info("Ad deleted", "ad_id", ad.ID, "hard", hard)
 }
 
-func calculatePriceHash(price int) int {
-	return ((price-1)/10000 + 1) * 10000
+// postResult is the body of a successful PostAd: the queued jobs, one per
+// channel, and where to follow their progress. Job is the first of them.
+type postResult struct {
+	AdID         int          `json:"ad_id"`
+	Result       string       `json:"result"`
+	Job          models.Job   `json:"job"`
+	Jobs         []models.Job `json:"jobs"`
+	Publications string       `json:"publications_url"`
 }
 
-func generateAdText(ad Ad, districtHash, priceHash string) string {
-	return fmt.Sprintf(
-		"#%s, #under_%s\n\n"+
-			"Rooms: %s\n"+
-			"Price: %d AED/Year\n"+
-			"Type: %s\n"+
-			"Area: %d sqm\n"+
-			"Building: %s\n"+
-			"District: %s\n\n"+
-			"%s\n\n"+
-			"Contact: @%s",
-		districtHash, priceHash,
-		ad.Rooms, ad.Price, ad.Type, ad.Area,
-		ad.Building, ad.District, ad.Text, ad.Username)
+// previewResult is the body of PreviewAd.
+type previewResult struct {
+	AdID      int    `json:"ad_id"`
+	ChannelID string `json:"channel_id"`
+	Caption   string `json:"caption"`
+	ParseMode string `json:"parse_mode"`
+	Length    int    `json:"length"`
+	Truncated bool   `json:"truncated"`
+	Reply     string `json:"reply,omitempty"`
 }
 
-func postToTelegramChannel(ad Ad) error {
-	botToken := os.Getenv("TELEGRAM_BOT_TOKEN")
-	channelID := os.Getenv("TELEGRAM_CHANNEL_ID")
-	if botToken == "" || channelID == "" {
-		return fmt.Errorf("TELEGRAM_BOT_TOKEN or TELEGRAM_CHANNEL_ID not set")
-	}
-
-	districtHash := strings.ReplaceAll(ad.District, " ", "_")
-	priceHash := fmt.Sprintf("%d", calculatePriceHash(ad.Price))
-	text := generateAdText(ad, districtHash, priceHash)
+// editResult is the body of a successful EditAdInTelegram.
+type editResult struct {
+	AdID int `json:"ad_id"`
+	publisher.SyncResult
+}
 
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMediaGroup", botToken)
-	photos := strings.Split(ad.Photos, ",")
-	media := make([]map[string]string, len(photos))
+// PostAd queues the ad for publishing to every channel whose rules match
+// it, or only to the channel given by ?channel=, whatever its rules. Each
+// channel gets its own job, planned into the channel's queue and not run
+// before ?publish_at= when given. The Telegram calls happen in the outbox
+// worker, so a Telegram outage or rate limit never loses the request;
+// clients follow progress at /ads/{id}/publications. Ads not yet published
+// are transitioned to published on the way.
+func (h *AdHandler) PostAd(w http.ResponseWriter, r *http.Request) {
+	ad, ok := h.loadAd(w, r)
+	if !ok {
+		return
+	}
 
-	for i, photo := range photos {
-		mediaItem := map[string]string{
-			"type":  "photo",
-			"media": photo,
+	q := r.URL.Query()
+	publishAt, err := parsePublishAt(q.Get("publish_at"))
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
+	targets, err := h.postTargets(r, ad, q.Get("channel"))
+	if err != nil {
+		var fe *fieldError
+		if errors.As(err, &fe) {
+			writeFieldError(w, r, fe)
+		} else {
+			response.Internal(w, r, "Error routing ad", err, "ad_id", ad.ID)
 		}
-		if i == 0 {
-			mediaItem["caption"] = text
-			mediaItem["parse_mode"] = "HTML"
+		return
+	}
+	if len(targets) == 0 {
+		response.Error(w, r, http.StatusUnprocessableEntity, response.CodeNoMatchingChannel, "No channel matches the ad")
+		return
+	}
+	posted := h.publisher.PostedChannels(ad)
+	var pending []string
+	for _, channelID := range targets {
+		if !slices.Contains(posted, channelID) {
+			pending = append(pending, channelID)
 		}
-		media[i] = mediaItem
 	}
-
-	payload := map[string]interface{}{
-		"chat_id": channelID,
-		"media":   media,
+	if len(pending) == 0 {
+		response.Error(w, r, http.StatusBadRequest, response.CodeAlreadyPosted, "Ad already posted")
+		return
 	}
-
-	jsonPayload, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("error marshaling payload: %v", err)
+	if !ad.State.Publishable() {
+		response.Error(w, r, http.StatusConflict, response.CodeInvalidTransition, fmt.Sprintf("Ad is %s", ad.State))
+		return
+	}
+	if ad.State != models.AdPublished && !checkPublishable(w, r, ad) {
+		return
 	}
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonPayload))
+	jobs, err := h.publishJobs(r.Context(), ad.ID, pending, publishAt)
 	if err != nil {
-		return fmt.Errorf("error making POST request: %v", err)
+		response.Internal(w, r, "Error scheduling publish jobs", err, "ad_id", ad.ID)
+		return
+	}
+	var enqueued []models.Job
+	if ad.State == models.AdDraft || ad.State == models.AdPendingReview {
+		resp, ok := h.transition(w, r, ad, models.AdPublished, "", jobs)
+		if !ok {
+			return
+		}
+		enqueued = resp.Jobs
+	} else if enqueued, ok = h.enqueueJobs(w, r, jobs); !ok {
+		return
+	}
+	if len(enqueued) == 0 {
+		response.Error(w, r, http.StatusConflict, response.CodeAlreadyQueued, "Ad already queued for posting")
+		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
+	publications := fmt.Sprintf("/ads/%d/publications", ad.ID)
+	w.Header().Set("Location", publications)
+	response.JSON(w, http.StatusAccepted, postResult{AdID: ad.ID, Result: "queued", Job: enqueued[0], Jobs: enqueued, Publications: publications})
+	for _, job := range enqueued {
+		slog.Info("Ad queued for posting to Telegram", "ad_id", ad.ID, "job_id", job.ID, "channel_id", job.ChannelID, "next_attempt_at", job.NextAttemptAt)
 	}
+}
 
-	var result struct {
-		Ok     bool `json:"ok"`
-		Result []struct {
-			MessageID int `json:"message_id"`
-		} `json:"result"`
+// enqueueJobs enqueues jobs, skipping those duplicating an unfinished job,
+// and returns the ones enqueued. It writes an error when one fails.
+func (h *AdHandler) enqueueJobs(w http.ResponseWriter, r *http.Request, jobs []models.Job) ([]models.Job, bool) {
+	var enqueued []models.Job
+	for _, job := range jobs {
+		err := h.jobs.Enqueue(r.Context(), &job, time.Now().UTC())
+		switch {
+		case errors.Is(err, repository.ErrConflict):
+			continue
+		case errors.Is(err, repository.ErrNotFound):
+			response.Error(w, r, http.StatusNotFound, response.CodeAdNotFound, "Ad not found")
+			return nil, false
+		case err != nil:
+			response.Internal(w, r, fmt.Sprintf("Error enqueuing %s job", job.Kind), err)
+			return nil, false
+		}
+		enqueued = append(enqueued, job)
 	}
+	return enqueued, true
+}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return fmt.Errorf("error decoding response: %v", err)
+// BumpAd reposts a published ad at the top of every channel it is posted
+// to, deleting the old posts, and restarts its listing once the reposts
+// are out. An ad is bumped at most once per BumpCooldown.
+func (h *AdHandler) BumpAd(w http.ResponseWriter, r *http.Request) {
+	ad, ok := h.loadAd(w, r)
+	if !ok {
+		return
 	}
 
-	if len(result.Result) > 0 {
-		_, err = db.Exec("UPDATE ads SET chat_message_id = $1 WHERE id = $2", result.Result[0].MessageID, ad.ID)
-		if err != nil {
-			return fmt.Errorf("error updating chat_message_id: %v", err)
+	if ad.State != models.AdPublished {
+		response.Error(w, r, http.StatusConflict, response.CodeInvalidTransition, fmt.Sprintf("Ad is %s", ad.State))
+		return
+	}
+	channels := h.publisher.PostedChannels(ad)
+	if len(channels) == 0 {
+		response.Error(w, r, http.StatusBadRequest, response.CodeNotPosted, "Ad is not posted to Telegram")
+		return
+	}
+	if ad.ListedAt != nil {
+		if wait := time.Until(ad.ListedAt.Add(h.BumpCooldown)); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			response.Error(w, r, http.StatusTooManyRequests, response.CodeBumpCooldown,
+				fmt.Sprintf("Ad can be bumped again after %s", ad.ListedAt.Add(h.BumpCooldown).UTC().Format(time.RFC3339)))
+			return
 		}
 	}
 
-	slog.Info("Ad successfully posted to Telegram", "ad_id", ad.ID)
-	return nil
+	jobs := make([]models.Job, len(channels))
+	for i, channelID := range channels {
+		jobs[i] = models.Job{AdID: ad.ID, Kind: models.JobBump, ChannelID: channelID}
+	}
+	enqueued, ok := h.enqueueJobs(w, r, jobs)
+	if !ok {
+		return
+	}
+	if len(enqueued) == 0 {
+		response.Error(w, r, http.StatusConflict, response.CodeAlreadyQueued, "Ad already queued for bumping")
+		return
+	}
+
+	publications := fmt.Sprintf("/ads/%d/publications", ad.ID)
+	w.Header().Set("Location", publications)
+	response.JSON(w, http.StatusAccepted, postResult{AdID: ad.ID, Result: "queued", Job: enqueued[0], Jobs: enqueued, Publications: publications})
+	for _, job := range enqueued {
+		slog.Info("Ad queued for bumping", "ad_id", ad.ID, "job_id", job.ID, "channel_id", job.ChannelID)
+	}
+}
+
+// parsePublishAt parses ?publish_at=, an RFC 3339 timestamp. It returns
+// nil when none is given.
+func parsePublishAt(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, &fieldError{Field: "publish_at", Message: "must be an RFC 3339 timestamp"}
+	}
+	t = t.UTC()
+	return &t, nil
 }
 
-func EditAdInTelegram(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+// publishJobs returns a publish job of the ad for each channel, planned
+// into the channel's queue by the Scheduler if there is one.
+func (h *AdHandler) publishJobs(ctx context.Context, adID int, channels []string, publishAt *time.Time) ([]models.Job, error) {
+	jobs := make([]models.Job, len(channels))
+	for i, channelID := range channels {
+		jobs[i] = models.Job{AdID: adID, Kind: models.JobPublish, ChannelID: channelID, PublishAt: publishAt}
+		switch {
+		case h.Scheduler != nil:
+			at, err := h.Scheduler.Plan(ctx, channelID, publishAt)
+			if err != nil {
+				return nil, err
+			}
+			jobs[i].NextAttemptAt = at
+		case publishAt != nil:
+			jobs[i].NextAttemptAt = *publishAt
+		}
+	}
+	return jobs, nil
+}
 
-	var ad Ad
-	err := db.QueryRow("SELECT id, user_id, username, photos, rooms, price, type, area, building, district, text, is_posted, chat_message_id FROM ads WHERE id = $1", id).Scan(
-		&ad.ID, &ad.UserID, &ad.Username, &ad.Photos, &ad.Rooms, &ad.Price, &ad.Type, &ad.Area, &ad.Building, &ad.District, &ad.Text, &ad.IsPosted, &ad.ChatMessageId)
+// postTargets returns the channels PostAd would post ad to: channelID
+// when given, which must be known, or else the channels routing picks.
+func (h *AdHandler) postTargets(r *http.Request, ad models.Ad, channelID string) ([]string, error) {
+	if channelID == "" {
+		return h.publisher.Targets(r.Context(), ad)
+	}
+	known, err := h.publisher.KnownChannel(r.Context(), channelID)
 	if err != nil {
-		slog.Error("Error fetching ad details", "error", err)
-		http.Error(w, "Error fetching ad details", http.StatusInternalServerError)
-		return
+		return nil, err
+	}
+	if !known {
+		return nil, &fieldError{Field: "channel", Message: fmt.Sprintf("unknown channel %q", channelID)}
 	}
+	return []string{channelID}, nil
+}
 
-	if ad.IsPosted != 1 || ad.ChatMessageId == 0 {
-		http.Error(w, "Ad not posted or message ID not available", http.StatusBadRequest)
+// GetPublications lists the outbox jobs of an ad, oldest first, including
+// their attempts and last error.
+func (h *AdHandler) GetPublications(w http.ResponseWriter, r *http.Request) {
+	ad, ok := h.loadAd(w, r)
+	if !ok {
 		return
 	}
 
-	err = editTelegramMessage(ad)
+	jobs, err := h.jobs.ListByAd(r.Context(), ad.ID)
 	if err != nil {
-		slog.Error("Error editing Telegram message", "error", err)
-		http.Error(w, "Error editing Telegram message", http.StatusInternalServerError)
+		response.Internal(w, r, "Error querying publish jobs", err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "Ad successfully edited in Telegram channel")
-	slog.Info("Ad successfully edited in Telegram channel", "ad_id", id)
+	response.JSON(w, http.StatusOK, jobs)
 }
 
-func editTelegramMessage(ad Ad) error {
-	botToken := os.Getenv("TELEGRAM_BOT_TOKEN")
-	channelID := os.Getenv("TELEGRAM_CHANNEL_ID")
-	if botToken == "" || channelID == "" {
-		return fmt.Errorf("TELEGRAM_BOT_TOKEN or TELEGRAM_CHANNEL_ID not set")
+// PreviewAd renders the caption the ad would be posted with, and its full
+// text reply if any, for the channel given by ?channel= or the default one.
+func (h *AdHandler) PreviewAd(w http.ResponseWriter, r *http.Request) {
+	ad, ok := h.loadAd(w, r)
+	if !ok {
+		return
 	}
 
-	districtHash := strings.ReplaceAll(ad.District, " ", "_")
-	priceHash := fmt.Sprintf("%d", calculatePriceHash(ad.Price))
-	newText := generateAdText(ad, districtHash, priceHash)
-
-	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/editMessageCaption", botToken)
-
-	params := map[string]interface{}{
-		"chat_id":    channelID,
-		"message_id": ad.ChatMessageId,
-		"caption":    newText,
-		"parse_mode": "HTML",
+	channelID := r.URL.Query().Get("channel")
+	if channelID == "" {
+		channelID = h.publisher.ChannelID()
 	}
-
-	jsonParams, err := json.Marshal(params)
+	rendered, err := h.publisher.Render(channelID, ad)
 	if err != nil {
-		return fmt.Errorf("error marshaling params: %v", err)
+		response.Internal(w, r, "Error rendering caption", err, "ad_id", ad.ID)
+		return
 	}
-
-	resp, err := http.Post(apiURL, "application/json", bytes.NewBuffer(jsonParams))
+	reply, err := h.publisher.Reply(channelID, ad)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
+		response.Internal(w, r, "Error rendering reply", err, "ad_id", ad.ID)
+		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
-	}
+	response.JSON(w, http.StatusOK, previewResult{
+		AdID:      ad.ID,
+		ChannelID: channelID,
+		Caption:   rendered.Caption,
+		ParseMode: telegram.ParseModeHTML,
+		Length:    rendered.Length,
+		Truncated: rendered.Truncated,
+		Reply:     reply,
+	})
+}
 
-	var result struct {
-		Ok          bool   `json:"ok"`
-		ErrorCode   int    `json:"error_code,omitempty"`
-		Description string `json:"description,omitempty"`
+// EditAdInTelegram brings the ad's posts in every channel in line with
+// it, reporting whether captions, individual photos or whole albums were
+// replaced.
+func (h *AdHandler) EditAdInTelegram(w http.ResponseWriter, r *http.Request) {
+	ad, ok := h.loadAd(w, r)
+	if !ok {
+		return
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return fmt.Errorf("failed to parse response: %v", err)
+	if ad.IsPosted != 1 || ad.ChatMessageId == 0 {
+		response.Error(w, r, http.StatusBadRequest, response.CodeNotPosted, "Ad is not posted to Telegram")
+		return
 	}
 
-	if !result.Ok {
-		return fmt.Errorf("failed to edit message: %s (code: %d)", result.Description, result.ErrorCode)
+	result, syncErr := h.publisher.Sync(r.Context(), ad)
+	if result.Messages != nil {
+		if err := h.ads.SetMessages(r.Context(), ad.ID, result.Messages); err != nil {
+			response.Internal(w, r, "Error recording Telegram messages", err, "ad_id", ad.ID)
+			return
+		}
+	}
+	if syncErr != nil {
+		response.Unavailable(w, r, http.StatusBadGateway, response.CodeTelegramUnavailable, "Error editing Telegram message", syncErr, "ad_id", ad.ID)
+		return
 	}
 
-	slog.Info("Telegram message successfully edited", "ad_id", ad.ID)
-	return nil
+	response.JSON(w, http.StatusOK, editResult{AdID: ad.ID, SyncResult: result})
+	slog.Info("Ad successfully edited in Telegram channel", "ad_id", ad.ID, "operation", result.Operation)
 }
@@ -2,35 +2,147 @@ package handlers
 
 import (
 	"bytes"
-	"database/sql"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
-	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/outbox"
+	"github.com/1karp/ads_api/internal/app/publisher"
+	"github.com/1karp/ads_api/internal/app/render"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/response"
+	"github.com/1karp/ads_api/internal/app/telegram"
+	"github.com/1karp/ads_api/internal/app/telegram/telegramtest"
+	"github.com/1karp/ads_api/internal/app/validation"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestCreateAd(t *testing.T) {
-	// Create a new mock database
-	mockDB, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+// failingAdRepository returns err from every method, standing in for a
+// database outage.
+type failingAdRepository struct {
+	err error
+}
+
+func (r failingAdRepository) Create(context.Context, *models.Ad) error { return r.err }
+func (r failingAdRepository) Get(context.Context, int) (models.Ad, error) {
+	return models.Ad{}, r.err
+}
+func (r failingAdRepository) List(context.Context, repository.AdFilter, repository.ListOptions) ([]models.Ad, error) {
+	return nil, r.err
+}
+func (r failingAdRepository) Update(context.Context, *models.Ad) error { return r.err }
+func (r failingAdRepository) SetMessages(context.Context, int, []models.TelegramMessage) error {
+	return r.err
+}
+func (r failingAdRepository) ReplacePhoto(context.Context, int, string, models.Photo) error {
+	return r.err
+}
+func (r failingAdRepository) ReorderPhotos(context.Context, int, []int) error { return r.err }
+func (r failingAdRepository) DeletePhoto(context.Context, int, int) error     { return r.err }
+func (r failingAdRepository) Delete(context.Context, int, int) error          { return r.err }
+
+// validAd returns an ad passing validation, owned by userID.
+func validAd(userID int, price int) models.Ad {
+	return models.Ad{
+		UserID:   userID,
+		Username: "testuser",
+		Photos:   models.LegacyPhotos("https://example.com/1.jpg"),
+		Rooms:    "2",
+		Price:    price,
+		Type:     "apartment",
+		Area:     50,
+		District: "downtown",
+	}
+}
+
+// publishableAd is a valid ad with the given photos, of which the fake
+// Telegram server needs at least two to accept its album.
+func publishableAd(userID int, photos string) models.Ad {
+	ad := validAd(userID, 60000)
+	ad.Photos = models.LegacyPhotos(photos)
+	return ad
+}
+
+func newAdTestRouter(h *AdHandler) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/ads", h.CreateAd).Methods("POST")
+	router.HandleFunc("/ads", h.GetAds).Methods("GET")
+	router.HandleFunc("/ads/{id}", h.GetAdByID).Methods("GET")
+	router.HandleFunc("/ads/{id}", h.UpdateAd).Methods("PUT")
+	router.HandleFunc("/ads/{id}", h.PatchAd).Methods("PATCH")
+	router.HandleFunc("/ads/{id}", RequireAdminForHardDelete(testAdminToken, h.DeleteAd)).Methods("DELETE")
+	router.HandleFunc("/ads/{id}/post", h.PostAd).Methods("POST")
+	router.HandleFunc("/ads/{id}/edit-post", h.EditAdInTelegram).Methods("POST")
+	router.HandleFunc("/ads/{id}/bump", h.BumpAd).Methods("POST")
+	router.HandleFunc("/ads/{id}/publications", h.GetPublications).Methods("GET")
+	router.HandleFunc("/ads/{id}/preview", h.PreviewAd).Methods("GET")
+	router.HandleFunc("/ads/{id}/transitions", h.CreateTransition).Methods("POST")
+	router.HandleFunc("/ads/{id}/transitions", h.GetTransitions).Methods("GET")
+	router.HandleFunc("/ads/{id}/photos", h.UploadPhotos).Methods("POST")
+	router.HandleFunc("/ads/{id}/photos/order", h.ReorderPhotos).Methods("PUT")
+	router.HandleFunc("/ads/{id}/photos/{photoID}", h.DeletePhoto).Methods("DELETE")
+	return router
+}
+
+func serve(router http.Handler, method, target string, body interface{}) *httptest.ResponseRecorder {
+	return serveWithHeader(router, method, target, body, nil)
+}
+
+func serveWithHeader(router http.Handler, method, target string, body interface{}, header http.Header) *httptest.ResponseRecorder {
+	var reader *bytes.Reader
+	switch b := body.(type) {
+	case nil:
+		reader = bytes.NewReader(nil)
+	case string:
+		reader = bytes.NewReader([]byte(b))
+	default:
+		data, _ := json.Marshal(b)
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func decodeProblem(t *testing.T, rr *httptest.ResponseRecorder) response.Problem {
+	t.Helper()
+	assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+	var problem response.Problem
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+	return problem
+}
+
+func seedAds(t *testing.T, repo repository.AdRepository, ads ...models.Ad) []models.Ad {
+	t.Helper()
+	for i := range ads {
+		if err := repo.Create(context.Background(), &ads[i]); err != nil {
+			t.Fatal(err)
+		}
 	}
-	defer mockDB.Close()
+	return ads
+}
 
-	// Set our mock database as the handler's database
-	db = mockDB
+func TestCreateAd(t *testing.T) {
+	ads := repository.NewMemoryAdRepository()
+	router := newAdTestRouter(NewAdHandler(ads, repository.NewMemoryUserRepository(), nil, nil, nil, nil))
 
 	// Create a new ad
-	ad := Ad{
+	ad := models.Ad{
 		UserID:   1,
 		Username: "testuser",
-		Photos:   "photo1.jpg,photo2.jpg",
+		Photos:   models.LegacyPhotos("https://example.com/1.jpg,https://example.com/2.jpg"),
 		Rooms:    "2",
 		Price:    1000,
 		Type:     "apartment",
@@ -41,25 +153,7 @@ func TestCreateAd(t *testing.T) {
 		IsPosted: 1,
 	}
 
-	// Expect the insert query
-	mock.ExpectExec("INSERT INTO ads").WithArgs(
-		ad.UserID, ad.Username, ad.Photos, ad.Rooms, ad.Price, ad.Type, ad.Area,
-		ad.Building, ad.District, ad.Text, ad.IsPosted, ad.ChatMessageId,
-	).WillReturnResult(sqlmock.NewResult(1, 1))
-
-	// Create a request body
-	body, _ := json.Marshal(ad)
-	req, err := http.NewRequest("POST", "/ads", bytes.NewBuffer(body))
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	// Create a response recorder
-	rr := httptest.NewRecorder()
-
-	// Call the handler
-	handler := http.HandlerFunc(CreateAd)
-	handler.ServeHTTP(rr, req)
+	rr := serve(router, "POST", "/ads", ad)
 
 	// Check the status code
 	if status := rr.Code; status != http.StatusCreated {
@@ -67,8 +161,8 @@ func TestCreateAd(t *testing.T) {
 	}
 
 	// Check the response body
-	var createdAd Ad
-	err = json.Unmarshal(rr.Body.Bytes(), &createdAd)
+	var createdAd models.Ad
+	err := json.Unmarshal(rr.Body.Bytes(), &createdAd)
 	if err != nil {
 		t.Errorf("Could not unmarshal response: %v", err)
 	}
@@ -77,58 +171,113 @@ func TestCreateAd(t *testing.T) {
 		t.Errorf("Expected created ad ID to be 1, got %d", createdAd.ID)
 	}
 
-	// We make sure that all expectations were met
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
-	}
+	stored, err := ads.Get(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://example.com/1.jpg", "https://example.com/2.jpg"}, stored.Photos.URLs())
+	assert.Equal(t, models.AdDraft, stored.State)
+
+	t.Run("Photo Objects", func(t *testing.T) {
+		rr := serve(router, "POST", "/ads", `{"user_id":1,"username":"testuser","price":1000,"type":"villa","area":300,"district":"Jumeirah",`+
+			`"photos":[{"url":"/media/b.jpg","position":1,"caption":"Kitchen"},{"url":"/media/a.jpg","position":0,"width":800,"height":600}]}`)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+		var created models.Ad
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+		assert.Equal(t, models.Photos{
+			{ID: created.Photos[0].ID, URL: "/media/a.jpg", Position: 0, Width: 800, Height: 600},
+			{ID: created.Photos[1].ID, URL: "/media/b.jpg", Position: 1, Caption: "Kitchen"},
+		}, created.Photos)
+		assert.NotZero(t, created.Photos[0].ID)
+	})
+
+	t.Run("Legacy Photo String", func(t *testing.T) {
+		rr := serve(router, "POST", "/ads", `{"user_id":1,"username":"testuser","price":1000,"type":"villa","area":300,"district":"Jumeirah","photos":"/media/a.jpg, /media/b.jpg"}`)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+		var created models.Ad
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+		assert.Equal(t, []string{"/media/a.jpg", "/media/b.jpg"}, created.Photos.URLs())
+	})
+
+	t.Run("Invalid Ad", func(t *testing.T) {
+		rr := serve(router, "POST", "/ads", `{"user_id":1,"username":"x","price":-5,"type":"castle","area":0,"district":"Marina","state":"published",`+
+			`"photos":[{"url":"https://example.com/1.jpg"},{"caption":"no url"},{"url":"ftp://example.com/3.jpg"}]}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{
+			"type": "/problems/validation_failed",
+			"title": "Bad Request",
+			"status": 400,
+			"code": "validation_failed",
+			"detail": "The request has invalid fields.",
+			"errors": [
+				{"field": "username", "message": "must be a Telegram username of 5 to 32 letters, digits or underscores"},
+				{"field": "photos[1].url", "message": "is required"},
+				{"field": "photos[2].url", "message": "must be an http or https URL"},
+				{"field": "price", "message": "must be greater than 0"},
+				{"field": "type", "message": "must be one of apartment, villa, townhouse, penthouse, studio"},
+				{"field": "area", "message": "must be greater than 0"},
+				{"field": "state", "message": "must be draft or pending_review"}
+			]
+		}`, rr.Body.String())
+	})
+
+	t.Run("Photos", func(t *testing.T) {
+		ad := validAd(1, 1000)
+		ad.Photos = models.LegacyPhotos(strings.Repeat("https://example.com/1.jpg,", 10) + "https://example.com/1.jpg")
+		rr := serve(router, "POST", "/ads", ad)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), `{"field":"photos","message":"must have at most 10 items"}`)
+
+		// Drafts may wait for their photos to be uploaded; ads submitted
+		// for review may not.
+		ad.Photos = nil
+		assert.Equal(t, http.StatusCreated, serve(router, "POST", "/ads", ad).Code)
+		ad.State = models.AdPendingReview
+		rr = serve(router, "POST", "/ads", ad)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), `{"field":"photos","message":"must not be empty"}`)
+	})
+
+	t.Run("Unknown Field", func(t *testing.T) {
+		rr := serve(router, "POST", "/ads", `{"user_id":1,"username":"testuser","colour":"blue"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), `{"field":"colour","message":"is not a known field"}`)
+
+		rr = serve(router, "POST", "/ads", `{"user_id":1,"photos":[{"url":"/media/a.jpg","alt":"x"}]}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Wrong Type", func(t *testing.T) {
+		rr := serve(router, "POST", "/ads", `{"user_id":1,"price":"cheap"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), `{"field":"price","message":"cannot be a string"}`)
+	})
 
 	// Test case for invalid JSON
 	t.Run("Invalid JSON", func(t *testing.T) {
-		req, _ := http.NewRequest("POST", "/ads", strings.NewReader(`{"invalid json"`))
-		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(CreateAd)
-		handler.ServeHTTP(rr, req)
-
+		rr := serve(router, "POST", "/ads", `{"invalid json"`)
 		assert.Equal(t, http.StatusBadRequest, rr.Code)
 	})
 
 	// Test case for database error
 	t.Run("Database Error", func(t *testing.T) {
-		// Mock the database to return an error
-		db, mock, _ := sqlmock.New()
-		defer db.Close()
-		InitDB(db)
-
-		mock.ExpectExec("INSERT INTO ads").WillReturnError(fmt.Errorf("database error"))
-
-		ad := Ad{UserID: 1, Username: "testuser", Price: 1000}
-		body, _ := json.Marshal(ad)
-		req, _ := http.NewRequest("POST", "/ads", bytes.NewBuffer(body))
-		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(CreateAd)
-		handler.ServeHTTP(rr, req)
+		router := newAdTestRouter(NewAdHandler(failingAdRepository{fmt.Errorf("database error")}, repository.NewMemoryUserRepository(), nil, nil, nil, nil))
 
+		rr := serve(router, "POST", "/ads", validAd(1, 1000))
 		assert.Equal(t, http.StatusInternalServerError, rr.Code)
 	})
 }
 
 func TestGetAdByID(t *testing.T) {
-	// Create a new mock database
-	mockDB, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
-	}
-	defer mockDB.Close()
-
-	// Set our mock database as the handler's database
-	db = mockDB
+	ads := repository.NewMemoryAdRepository()
+	router := newAdTestRouter(NewAdHandler(ads, repository.NewMemoryUserRepository(), nil, nil, nil, nil))
 
 	// Create a sample ad
-	ad := Ad{
-		ID:       1,
+	ad := seedAds(t, ads, models.Ad{
 		UserID:   1,
 		Username: "testuser",
-		Photos:   "photo1.jpg,photo2.jpg",
+		Photos:   models.LegacyPhotos("photo1.jpg,photo2.jpg"),
 		Rooms:    "2",
 		Price:    1000,
 		Type:     "apartment",
@@ -137,29 +286,9 @@ func TestGetAdByID(t *testing.T) {
 		District: "downtown",
 		Text:     "Nice apartment",
 		IsPosted: 1,
-	}
-
-	// Expect the select query
-	rows := sqlmock.NewRows([]string{"id", "user_id", "username", "photos", "rooms", "price", "type", "area", "building", "district", "text", "created_at", "is_posted", "chat_message_id"}).
-		AddRow(ad.ID, ad.UserID, ad.Username, ad.Photos, ad.Rooms, ad.Price, ad.Type, ad.Area, ad.Building, ad.District, ad.Text, "2023-05-01", ad.IsPosted, ad.ChatMessageId)
-
-	mock.ExpectQuery("SELECT (.+) FROM ads WHERE id = ?").WithArgs("1").WillReturnRows(rows)
-
-	// Create a request
-	req, err := http.NewRequest("GET", "/ads/1", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
+	})[0]
 
-	// Set up the mux router
-	router := mux.NewRouter()
-	router.HandleFunc("/ads/{id}", GetAdByID)
-
-	// Create a response recorder
-	rr := httptest.NewRecorder()
-
-	// Call the handler
-	router.ServeHTTP(rr, req)
+	rr := serve(router, "GET", "/ads/1", nil)
 
 	// Check the status code
 	if status := rr.Code; status != http.StatusOK {
@@ -167,8 +296,8 @@ func TestGetAdByID(t *testing.T) {
 	}
 
 	// Check the response body
-	var retrievedAd Ad
-	err = json.Unmarshal(rr.Body.Bytes(), &retrievedAd)
+	var retrievedAd models.Ad
+	err := json.Unmarshal(rr.Body.Bytes(), &retrievedAd)
 	if err != nil {
 		t.Errorf("Could not unmarshal response: %v", err)
 	}
@@ -177,123 +306,1075 @@ func TestGetAdByID(t *testing.T) {
 		t.Errorf("Expected ad ID to be %d, got %d", ad.ID, retrievedAd.ID)
 	}
 
-	// We make sure that all expectations were met
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
-	}
-
 	// Test case for non-existent ad
 	t.Run("Non-existent Ad", func(t *testing.T) {
-		db, mock, _ := sqlmock.New()
-		defer db.Close()
-		InitDB(db)
-
-		mock.ExpectQuery("SELECT (.+) FROM ads WHERE id = ?").WithArgs("999").WillReturnError(sql.ErrNoRows)
-
-		req, _ := http.NewRequest("GET", "/ads/999", nil)
-		rr := httptest.NewRecorder()
-		router := mux.NewRouter()
-		router.HandleFunc("/ads/{id}", GetAdByID)
-		router.ServeHTTP(rr, req)
-
+		rr := serve(router, "GET", "/ads/999", nil)
 		assert.Equal(t, http.StatusNotFound, rr.Code)
 	})
 
 	// Test case for database error
 	t.Run("Database Error", func(t *testing.T) {
-		db, mock, _ := sqlmock.New()
-		defer db.Close()
-		InitDB(db)
-
-		mock.ExpectQuery("SELECT (.+) FROM ads WHERE id = ?").WithArgs("1").WillReturnError(fmt.Errorf("database error"))
-
-		req, _ := http.NewRequest("GET", "/ads/1", nil)
-		rr := httptest.NewRecorder()
-		router := mux.NewRouter()
-		router.HandleFunc("/ads/{id}", GetAdByID)
-		router.ServeHTTP(rr, req)
+		router := newAdTestRouter(NewAdHandler(failingAdRepository{fmt.Errorf("database error")}, repository.NewMemoryUserRepository(), nil, nil, nil, nil))
 
+		rr := serve(router, "GET", "/ads/1", nil)
 		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+		problem := decodeProblem(t, rr)
+		assert.Equal(t, response.CodeInternal, problem.Code)
+		assert.NotContains(t, problem.Detail, "database error", "internal details must not leak")
 	})
 }
 
 func TestGetAds(t *testing.T) {
-	// ... existing test ...
-
 	// Test case for database error
 	t.Run("Database Error", func(t *testing.T) {
-		db, mock, _ := sqlmock.New()
-		defer db.Close()
-		InitDB(db)
-
-		mock.ExpectQuery("SELECT (.+) FROM ads").WillReturnError(fmt.Errorf("database error"))
-
-		req, _ := http.NewRequest("GET", "/ads", nil)
-		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(GetAds)
-		handler.ServeHTTP(rr, req)
+		router := newAdTestRouter(NewAdHandler(failingAdRepository{fmt.Errorf("database error")}, repository.NewMemoryUserRepository(), nil, nil, nil, nil))
 
+		rr := serve(router, "GET", "/ads", nil)
 		assert.Equal(t, http.StatusInternalServerError, rr.Code)
 	})
 
 	// Test case for empty result
 	t.Run("Empty Result", func(t *testing.T) {
-		db, mock, _ := sqlmock.New()
-		defer db.Close()
-		InitDB(db)
+		router := newAdTestRouter(NewAdHandler(repository.NewMemoryAdRepository(), repository.NewMemoryUserRepository(), nil, nil, nil, nil))
+
+		rr := serve(router, "GET", "/ads", nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "null", strings.TrimSpace(rr.Body.String()))
+	})
+}
+
+func TestGetAdsFiltered(t *testing.T) {
+	ads := repository.NewMemoryAdRepository()
+	router := newAdTestRouter(NewAdHandler(ads, repository.NewMemoryUserRepository(), nil, nil, nil, nil))
 
-		rows := sqlmock.NewRows([]string{"id", "user_id", "username", "photos", "rooms", "price", "type", "area", "building", "district", "text", "created_at", "is_posted", "chat_message_id"})
-		mock.ExpectQuery("SELECT (.+) FROM ads").WillReturnRows(rows)
+	seedAds(t, ads,
+		models.Ad{UserID: 1, Photos: models.LegacyPhotos("a.jpg"), Rooms: "2", Price: 90000, Type: "apartment", Area: 80, District: "Marina"},
+		models.Ad{UserID: 1, Photos: models.LegacyPhotos("a.jpg"), Rooms: "2", Price: 90000, Type: "apartment", Area: 80, District: "Marina", IsPosted: 1},
+		models.Ad{UserID: 1, Photos: models.LegacyPhotos("a.jpg"), Rooms: "3", Price: 150000, Type: "villa", Area: 200, District: "Marina"},
+		models.Ad{UserID: 2, Photos: models.LegacyPhotos("a.jpg"), Rooms: "1", Price: 60000, Type: "apartment", Area: 40, District: "Deira"},
+	)
 
-		req, _ := http.NewRequest("GET", "/ads", nil)
-		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(GetAds)
-		handler.ServeHTTP(rr, req)
+	t.Run("Filters Combined With AND", func(t *testing.T) {
+		rr := serve(router, "GET", "/ads?min_price=50000&max_price=100000&district=marina&min_area=60&is_posted=false", nil)
 
 		assert.Equal(t, http.StatusOK, rr.Code)
-		assert.Equal(t, "null", strings.TrimSpace(rr.Body.String()))
+		var result []models.Ad
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		if assert.Len(t, result, 1) {
+			assert.Equal(t, 1, result[0].ID)
+		}
+	})
+
+	t.Run("List Values", func(t *testing.T) {
+		rr := serve(router, "GET", "/ads?type=villa,apartment&rooms=1&rooms=3", nil)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var result []models.Ad
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		assert.Len(t, result, 2)
+	})
+
+	t.Run("Invalid Parameter", func(t *testing.T) {
+		tests := []struct {
+			query string
+			field string
+		}{
+			{"min_price=abc", "min_price"},
+			{"max_area=-1", "max_area"},
+			{"min_price=10&max_price=5", "max_price"},
+			{"is_posted=maybe", "is_posted"},
+			{"created_after=yesterday", "created_after"},
+			{"type=apartment,", "type"},
+		}
+
+		for _, tt := range tests {
+			rr := serve(router, "GET", "/ads?"+tt.query, nil)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code, tt.query)
+			problem := decodeProblem(t, rr)
+			assert.Equal(t, response.CodeValidation, problem.Code, tt.query)
+			if assert.Len(t, problem.Errors, 1, tt.query) {
+				assert.Equal(t, tt.field, problem.Errors[0].Field, tt.query)
+			}
+		}
+	})
+}
+
+func TestGetAdsPaginated(t *testing.T) {
+	ads := repository.NewMemoryAdRepository()
+	router := newAdTestRouter(NewAdHandler(ads, repository.NewMemoryUserRepository(), nil, nil, nil, nil))
+
+	seedAds(t, ads,
+		models.Ad{UserID: 1, Photos: models.LegacyPhotos("a.jpg"), Price: 50000},
+		models.Ad{UserID: 1, Photos: models.LegacyPhotos("a.jpg"), Price: 90000},
+		models.Ad{UserID: 1, Photos: models.LegacyPhotos("a.jpg"), Price: 120000},
+	)
+
+	var next string
+	t.Run("First Page", func(t *testing.T) {
+		rr := serve(router, "GET", "/ads?sort=-price&limit=2", nil)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var body page[models.Ad]
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		if assert.Len(t, body.Items, 2) {
+			assert.Equal(t, 120000, body.Items[0].Price)
+			assert.Equal(t, 90000, body.Items[1].Price)
+		}
+		assert.True(t, body.HasMore)
+
+		c, err := decodeCursor(body.NextCursor)
+		assert.NoError(t, err)
+		assert.Equal(t, cursor{Sort: "-price", Value: "90000", ID: 2}, *c)
+		next = body.NextCursor
+	})
+
+	t.Run("Next Page", func(t *testing.T) {
+		rr := serve(router, "GET", "/ads?sort=-price&limit=2&cursor="+next, nil)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var body page[models.Ad]
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		if assert.Len(t, body.Items, 1) {
+			assert.Equal(t, 50000, body.Items[0].Price)
+		}
+		assert.False(t, body.HasMore)
+		assert.Empty(t, body.NextCursor)
+	})
+
+	t.Run("Invalid Parameter", func(t *testing.T) {
+		tests := []struct {
+			query string
+			field string
+		}{
+			{"sort=rooms", "sort"},
+			{"limit=0", "limit"},
+			{"limit=1000", "limit"},
+			{"cursor=not-a-cursor", "cursor"},
+			{"sort=price&cursor=" + cursor{Sort: "-price", ID: 1}.encode(), "cursor"},
+		}
+
+		for _, tt := range tests {
+			rr := serve(router, "GET", "/ads?"+tt.query, nil)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code, tt.query)
+			problem := decodeProblem(t, rr)
+			assert.Equal(t, response.CodeValidation, problem.Code, tt.query)
+			if assert.Len(t, problem.Errors, 1, tt.query) {
+				assert.Equal(t, tt.field, problem.Errors[0].Field, tt.query)
+			}
+		}
 	})
 }
 
 func TestUpdateAd(t *testing.T) {
-	// ... existing test ...
+	ads := repository.NewMemoryAdRepository()
+	router := newAdTestRouter(NewAdHandler(ads, repository.NewMemoryUserRepository(), nil, nil, nil, nil))
+	seedAds(t, ads, models.Ad{UserID: 1, Username: "testuser", Photos: models.LegacyPhotos("a.jpg"), Price: 1000})
 
-	// Test case for non-existent ad
-	t.Run("Non-existent Ad", func(t *testing.T) {
-		db, mock, _ := sqlmock.New()
-		defer db.Close()
-		InitDB(db)
+	update := validAd(1, 2000)
+	update.Photos = models.LegacyPhotos("https://example.com/b.jpg")
+	rr := serve(router, "PUT", "/ads/1", update)
+	assert.Equal(t, http.StatusOK, rr.Code)
 
-		mock.ExpectQuery("SELECT id FROM ads WHERE id = ?").WithArgs("999").WillReturnError(sql.ErrNoRows)
+	stored, err := ads.Get(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2000, stored.Price)
+	assert.Equal(t, []string{"https://example.com/b.jpg"}, stored.Photos.URLs())
 
-		ad := Ad{UserID: 1, Username: "testuser", Price: 1000}
-		body, _ := json.Marshal(ad)
-		req, _ := http.NewRequest("PUT", "/ads/999", bytes.NewBuffer(body))
-		rr := httptest.NewRecorder()
-		router := mux.NewRouter()
-		router.HandleFunc("/ads/{id}", UpdateAd)
-		router.ServeHTTP(rr, req)
+	var returned models.Ad
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &returned))
+	assert.Equal(t, stored, returned, "PUT answers with the persisted ad")
+	assert.NotZero(t, returned.Photos[0].ID)
+
+	t.Run("Keeps Telegram Post", func(t *testing.T) {
+		require.NoError(t, ads.SetMessages(context.Background(), 1, []models.TelegramMessage{{ChannelID: testChannelID, MessageID: 7}}))
+
+		rr := serve(router, "PUT", "/ads/1", validAd(1, 2500))
+		assert.Equal(t, http.StatusOK, rr.Code)
 
+		stored, _ := ads.Get(context.Background(), 1)
+		assert.Equal(t, 1, stored.IsPosted)
+		assert.Equal(t, 7, stored.ChatMessageId)
+	})
+
+	t.Run("Invalid Ad", func(t *testing.T) {
+		update := validAd(1, 0)
+		rr := serve(router, "PUT", "/ads/1", update)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), `{"field":"price","message":"must be greater than 0"}`)
+	})
+
+	// Test case for non-existent ad
+	t.Run("Non-existent Ad", func(t *testing.T) {
+		rr := serve(router, "PUT", "/ads/999", validAd(1, 1000))
 		assert.Equal(t, http.StatusNotFound, rr.Code)
 	})
 
 	// Test case for database error during update
 	t.Run("Database Error During Update", func(t *testing.T) {
-		db, mock, _ := sqlmock.New()
-		defer db.Close()
-		InitDB(db)
+		router := newAdTestRouter(NewAdHandler(failingAdRepository{fmt.Errorf("database error")}, repository.NewMemoryUserRepository(), nil, nil, nil, nil))
 
-		mock.ExpectQuery("SELECT id FROM ads WHERE id = ?").WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-		mock.ExpectExec("UPDATE ads SET").WillReturnError(fmt.Errorf("database error"))
+		rr := serve(router, "PUT", "/ads/1", validAd(1, 1000))
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	})
+}
 
-		ad := Ad{UserID: 1, Username: "testuser", Price: 1000}
-		body, _ := json.Marshal(ad)
-		req, _ := http.NewRequest("PUT", "/ads/1", bytes.NewBuffer(body))
-		rr := httptest.NewRecorder()
-		router := mux.NewRouter()
-		router.HandleFunc("/ads/{id}", UpdateAd)
-		router.ServeHTTP(rr, req)
+func TestPatchAd(t *testing.T) {
+	ads := repository.NewMemoryAdRepository()
+	router := newAdTestRouter(NewAdHandler(ads, repository.NewMemoryUserRepository(), nil, nil, nil, nil))
+	ad := validAd(1, 1000)
+	ad.Text = "Sea view"
+	ad.Photos = models.LegacyPhotos("https://example.com/a.jpg,https://example.com/b.jpg")
+	seedAds(t, ads, ad)
+	require.NoError(t, ads.SetMessages(context.Background(), 1, []models.TelegramMessage{{ChannelID: testChannelID, MessageID: 7}}))
 
-		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	patchAd := func(contentType, body string) *httptest.ResponseRecorder {
+		return serveWithHeader(router, "PATCH", "/ads/1", body, http.Header{"Content-Type": {contentType}})
+	}
+
+	t.Run("Merge Patch", func(t *testing.T) {
+		rr := patchAd("application/merge-patch+json", `{"price": 90000, "building": null}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		var patched models.Ad
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &patched))
+		assert.Equal(t, 90000, patched.Price)
+		assert.Equal(t, "Sea view", patched.Text)
+		assert.Equal(t, 7, patched.ChatMessageId)
+		assert.Len(t, patched.Photos, 2)
+
+		stored, _ := ads.Get(context.Background(), 1)
+		assert.Equal(t, stored, patched)
+	})
+
+	t.Run("JSON Patch", func(t *testing.T) {
+		rr := patchAd("application/json-patch+json", `[
+			{"op": "test", "path": "/price", "value": 90000},
+			{"op": "replace", "path": "/price", "value": 85000},
+			{"op": "remove", "path": "/photos/0"}
+		]`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		stored, _ := ads.Get(context.Background(), 1)
+		assert.Equal(t, 85000, stored.Price)
+		assert.Equal(t, []string{"https://example.com/b.jpg"}, stored.Photos.URLs())
+	})
+
+	t.Run("Failed Test", func(t *testing.T) {
+		rr := patchAd("application/json-patch+json", `[{"op": "test", "path": "/price", "value": 1}, {"op": "replace", "path": "/price", "value": 2}]`)
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+		assert.Equal(t, response.CodePatchFailed, decodeProblem(t, rr).Code)
+	})
+
+	t.Run("Read-only Field", func(t *testing.T) {
+		rr := patchAd("application/merge-patch+json", `{"chat_message_id": 0, "state": "rented"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, validation.Errors{
+			{Field: "state", Message: "is read-only"},
+			{Field: "chat_message_id", Message: "is read-only"},
+		}, decodeProblem(t, rr).Errors)
+	})
+
+	t.Run("Invalid Result", func(t *testing.T) {
+		rr := patchAd("application/merge-patch+json", `{"price": -1, "colour": "blue"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, response.CodeValidation, decodeProblem(t, rr).Code)
+
+		stored, _ := ads.Get(context.Background(), 1)
+		assert.Equal(t, 85000, stored.Price)
+	})
+
+	t.Run("Malformed Patch", func(t *testing.T) {
+		rr := patchAd("application/json-patch+json", `{"op": "replace"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, response.CodeMalformedBody, decodeProblem(t, rr).Code)
+	})
+
+	t.Run("Unsupported Media Type", func(t *testing.T) {
+		rr := patchAd("text/plain", `price=1`)
+		assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+		assert.Equal(t, "application/merge-patch+json, application/json-patch+json", rr.Header().Get("Accept-Patch"))
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		rr := serveWithHeader(router, "PATCH", "/ads/99", `{}`, http.Header{"Content-Type": {"application/merge-patch+json"}})
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
+
+func TestAdConditionalRequests(t *testing.T) {
+	ads := repository.NewMemoryAdRepository()
+	router := newAdTestRouter(NewAdHandler(ads, repository.NewMemoryUserRepository(), nil, nil, nil, nil))
+	seedAds(t, ads, validAd(1, 1000))
+
+	rr := serve(router, "GET", "/ads/1", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"1"`, rr.Header().Get("ETag"))
+
+	t.Run("Not Modified", func(t *testing.T) {
+		rr := serveWithHeader(router, "GET", "/ads/1", nil, http.Header{"If-None-Match": {`"0", W/"1"`}})
+		assert.Equal(t, http.StatusNotModified, rr.Code)
+		assert.Equal(t, `"1"`, rr.Header().Get("ETag"))
+		assert.Empty(t, rr.Body.String())
+	})
+
+	rr = serveWithHeader(router, "PUT", "/ads/1", validAd(1, 2000), http.Header{"If-Match": {`"1"`}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+
+	t.Run("Stale PUT", func(t *testing.T) {
+		rr := serveWithHeader(router, "PUT", "/ads/1", validAd(1, 3000), http.Header{"If-Match": {`"1"`}})
+		assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+		assert.Equal(t, response.CodePreconditionFailed, decodeProblem(t, rr).Code)
+
+		stored, _ := ads.Get(context.Background(), 1)
+		assert.Equal(t, 2000, stored.Price)
+	})
+
+	t.Run("Stale PATCH", func(t *testing.T) {
+		rr := serveWithHeader(router, "PATCH", "/ads/1", `{"price": 3000}`, http.Header{
+			"Content-Type": {"application/merge-patch+json"},
+			"If-Match":     {`"1"`},
+		})
+		assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+
+		rr = serveWithHeader(router, "PATCH", "/ads/1", `{"version": 7}`, http.Header{"Content-Type": {"application/merge-patch+json"}})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Stale DELETE", func(t *testing.T) {
+		rr := serveWithHeader(router, "DELETE", "/ads/1", nil, http.Header{"If-Match": {`W/"2"`}})
+		assert.Equal(t, http.StatusPreconditionFailed, rr.Code, "If-Match uses strong comparison")
+	})
+
+	t.Run("Unconditional", func(t *testing.T) {
+		rr := serve(router, "PUT", "/ads/1", validAd(1, 4000))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, `"3"`, rr.Header().Get("ETag"))
+
+		rr = serveWithHeader(router, "PUT", "/ads/1", validAd(1, 5000), http.Header{"If-Match": {"*"}})
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+}
+
+func TestMatchesETag(t *testing.T) {
+	assert.True(t, matchesETag(`"1", "2"`, `"2"`, false))
+	assert.True(t, matchesETag(`*`, `"2"`, false))
+	assert.False(t, matchesETag(`W/"2"`, `"2"`, false))
+	assert.True(t, matchesETag(`W/"2"`, `"2"`, true))
+	assert.False(t, matchesETag(`"12"`, `"2"`, true))
+	assert.False(t, matchesETag(``, `"2"`, true))
+}
+
+const (
+	testChannelID  = "@test_channel"
+	testAdminToken = "admin-secret"
+)
+
+// newPublishingAdHandler wires an AdHandler to a fake Bot API, returning
+// the worker that drains its outbox.
+func newPublishingAdHandler(tg *telegramtest.Server, ads *repository.MemoryAdRepository) (*AdHandler, *outbox.Worker) {
+	jobs := repository.NewMemoryJobRepository(ads)
+	transitions := repository.NewMemoryTransitionRepository(ads, jobs)
+	pub := publisher.New(tg.Client(), testChannelID, nil, nil)
+	cfg := outbox.DefaultConfig()
+	cfg.MaxAttempts = 1
+	return NewAdHandler(ads, repository.NewMemoryUserRepository(), jobs, transitions, pub, nil), outbox.NewWorker(jobs, ads, pub, nil, cfg)
+}
+
+func TestPostAd(t *testing.T) {
+	tg := telegramtest.NewServer()
+	defer tg.Close()
+
+	ads := repository.NewMemoryAdRepository()
+	h, worker := newPublishingAdHandler(tg, ads)
+	router := newAdTestRouter(h)
+	seedAds(t, ads, models.Ad{
+		UserID:   1,
+		Username: "landlord",
+		Photos:   models.LegacyPhotos("https://example.com/1.jpg,https://example.com/2.jpg"),
+		Rooms:    "2",
+		Price:    85000,
+		Type:     "apartment",
+		Area:     95,
+		Building: "Marina Gate",
+		District: "Dubai Marina",
+		Text:     "Sea view",
+	})
+
+	rr := serve(router, "POST", "/ads/1/post", nil)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	assert.Equal(t, "/ads/1/publications", rr.Header().Get("Location"))
+
+	var result postResult
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.Equal(t, 1, result.AdID)
+	assert.Equal(t, "queued", result.Result)
+	assert.Equal(t, "/ads/1/publications", result.Publications)
+	assert.Equal(t, models.JobPublish, result.Job.Kind)
+	assert.Equal(t, models.JobPending, result.Job.Status)
+	assert.Empty(t, tg.Calls(), "handler must not call Telegram")
+
+	t.Run("Already Queued", func(t *testing.T) {
+		rr := serve(router, "POST", "/ads/1/post", nil)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.Equal(t, response.CodeAlreadyQueued, decodeProblem(t, rr).Code)
+	})
+
+	processed, err := worker.ProcessNext(context.Background())
+	assert.True(t, processed)
+	assert.NoError(t, err)
+
+	calls := tg.CallsTo("sendMediaGroup")
+	if assert.Len(t, calls, 1) {
+		var params telegram.SendMediaGroupParams
+		assert.NoError(t, calls[0].Decode(&params))
+		assert.Equal(t, telegram.SendMediaGroupParams{
+			ChatID: testChannelID,
+			Media: []telegram.InputMediaPhoto{
+				{
+					Type:      "photo",
+					Media:     "https://example.com/1.jpg",
+					Caption:   "#Dubai_Marina, #under_90000\n\nRooms: 2\nPrice: 85000 AED/Year\nType: apartment\nArea: 95 sqm\nBuilding: Marina Gate\nDistrict: Dubai Marina\n\nSea view\n\nContact: @landlord",
+					ParseMode: "HTML",
+				},
+				{Type: "photo", Media: "https://example.com/2.jpg"},
+			},
+		}, params)
+	}
+
+	stored, err := ads.Get(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stored.IsPosted)
+	assert.Equal(t, 100, stored.ChatMessageId)
+	caption, err := h.publisher.Caption(testChannelID, stored)
+	require.NoError(t, err)
+	assert.Equal(t, []models.TelegramMessage{
+		{ChannelID: testChannelID, MessageID: 100, Position: 0, FileID: "file-100", PhotoURL: "https://example.com/1.jpg", Caption: caption},
+		{ChannelID: testChannelID, MessageID: 101, Position: 1, FileID: "file-101", PhotoURL: "https://example.com/2.jpg"},
+	}, stored.Messages)
+
+	var fetched map[string]interface{}
+	assert.NoError(t, json.Unmarshal(serve(router, "GET", "/ads/1", nil).Body.Bytes(), &fetched))
+	assert.Len(t, fetched["messages"], 2)
+
+	t.Run("Already Posted", func(t *testing.T) {
+		rr := serve(router, "POST", "/ads/1/post", nil)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, response.CodeAlreadyPosted, decodeProblem(t, rr).Code)
+		assert.Len(t, tg.CallsTo("sendMediaGroup"), 1)
+	})
+
+	t.Run("Publications", func(t *testing.T) {
+		rr := serve(router, "GET", "/ads/1/publications", nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var jobs []models.Job
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &jobs))
+		if assert.Len(t, jobs, 1) {
+			assert.Equal(t, models.JobSucceeded, jobs[0].Status)
+			assert.Equal(t, 1, jobs[0].Attempts)
+			assert.NotNil(t, jobs[0].CompletedAt)
+		}
+	})
+
+	t.Run("Telegram Error", func(t *testing.T) {
+		seedAds(t, ads, models.Ad{UserID: 1, Username: "landlord", Photos: models.LegacyPhotos("https://example.com/3.jpg,https://example.com/4.jpg"), Price: 60000, Type: "apartment", Area: 40, District: "JLT"})
+		tg.FailNext("sendMediaGroup", telegramtest.ServerError())
+
+		rr := serve(router, "POST", "/ads/2/post", nil)
+		assert.Equal(t, http.StatusAccepted, rr.Code)
+		_, err := worker.ProcessNext(context.Background())
+		assert.NoError(t, err)
+
+		stored, err := ads.Get(context.Background(), 2)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, stored.IsPosted)
+
+		rr = serve(router, "GET", "/ads/2/publications", nil)
+		var jobs []models.Job
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &jobs))
+		if assert.Len(t, jobs, 1) {
+			assert.Equal(t, models.JobFailed, jobs[0].Status)
+			assert.Contains(t, jobs[0].LastError, "Internal Server Error")
+		}
+	})
+
+	t.Run("Rented", func(t *testing.T) {
+		rented := seedAds(t, ads, models.Ad{UserID: 1, Photos: models.LegacyPhotos("https://example.com/5.jpg,https://example.com/6.jpg"), State: models.AdRented})[0]
+
+		rr := serve(router, "POST", fmt.Sprintf("/ads/%d/post", rented.ID), nil)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.Equal(t, response.CodeInvalidTransition, decodeProblem(t, rr).Code)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		rr := serve(router, "POST", "/ads/99/post", nil)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, response.CodeAdNotFound, decodeProblem(t, rr).Code)
+		assert.Equal(t, http.StatusNotFound, serve(router, "GET", "/ads/99/publications", nil).Code)
+	})
+}
+
+func TestEditAdInTelegram(t *testing.T) {
+	tg := telegramtest.NewServer()
+	defer tg.Close()
+
+	ads := repository.NewMemoryAdRepository()
+	h, worker := newPublishingAdHandler(tg, ads)
+	router := newAdTestRouter(h)
+	seedAds(t, ads, models.Ad{UserID: 1, Username: "landlord", Photos: models.LegacyPhotos("https://example.com/1.jpg,https://example.com/2.jpg"), Rooms: "1", Price: 60000, Type: "apartment", Area: 40, District: "JLT"})
+
+	t.Run("Not Posted", func(t *testing.T) {
+		rr := serve(router, "POST", "/ads/1/edit-post", nil)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Empty(t, tg.CallsTo("editMessageCaption"))
+	})
+
+	assert.Equal(t, http.StatusAccepted, serve(router, "POST", "/ads/1/post", nil).Code)
+	_, err := worker.ProcessNext(context.Background())
+	assert.NoError(t, err)
+
+	ad, _ := ads.Get(context.Background(), 1)
+	ad.Price = 55000
+	assert.NoError(t, ads.Update(context.Background(), &ad))
+
+	rr := serve(router, "POST", "/ads/1/edit-post", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "edit_caption", decodeSyncResult(t, rr).Operation)
+	assert.Empty(t, tg.CallsTo("editMessageMedia"))
+
+	calls := tg.CallsTo("editMessageCaption")
+	if assert.Len(t, calls, 1) {
+		var params telegram.EditMessageCaptionParams
+		assert.NoError(t, calls[0].Decode(&params))
+		assert.Equal(t, telegram.EditMessageCaptionParams{
+			ChatID:    testChannelID,
+			MessageID: ad.ChatMessageId,
+			Caption:   "#JLT, #under_60000\n\nRooms: 1\nPrice: 55000 AED/Year\nType: apartment\nArea: 40 sqm\nBuilding: \nDistrict: JLT\n\n\n\nContact: @landlord",
+			ParseMode: "HTML",
+		}, params)
+	}
+
+	t.Run("Unchanged", func(t *testing.T) {
+		rr := serve(router, "POST", "/ads/1/edit-post", nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "none", decodeSyncResult(t, rr).Operation)
+		assert.Len(t, tg.CallsTo("editMessageCaption"), 1, "an unchanged caption is not sent again")
+	})
+
+	t.Run("Changed Photo", func(t *testing.T) {
+		ad, _ := ads.Get(context.Background(), 1)
+		ad.Photos = models.LegacyPhotos("https://example.com/1.jpg,https://example.com/2b.jpg")
+		assert.NoError(t, ads.Update(context.Background(), &ad))
+
+		rr := serve(router, "POST", "/ads/1/edit-post", nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		result := decodeSyncResult(t, rr)
+		assert.Equal(t, "edit_media", result.Operation)
+		assert.Equal(t, []int{1}, result.Slots)
+
+		calls := tg.CallsTo("editMessageMedia")
+		if assert.Len(t, calls, 1) {
+			var params telegram.EditMessageMediaParams
+			assert.NoError(t, calls[0].Decode(&params))
+			assert.Equal(t, telegram.EditMessageMediaParams{
+				ChatID:    testChannelID,
+				MessageID: 101,
+				Media:     telegram.InputMediaPhoto{Type: "photo", Media: "https://example.com/2b.jpg"},
+			}, params)
+		}
+
+		m, _ := tg.Message(testChannelID, 101)
+		assert.Equal(t, "https://example.com/2b.jpg", m.Media)
+		stored, _ := ads.Get(context.Background(), 1)
+		assert.Equal(t, "https://example.com/2b.jpg", stored.Messages[1].PhotoURL)
+	})
+
+	t.Run("Album Size Changed", func(t *testing.T) {
+		ad, _ := ads.Get(context.Background(), 1)
+		ad.Photos = models.LegacyPhotos("https://example.com/1.jpg,https://example.com/2b.jpg,https://example.com/3.jpg")
+		assert.NoError(t, ads.Update(context.Background(), &ad))
+
+		rr := serve(router, "POST", "/ads/1/edit-post", nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "repost", decodeSyncResult(t, rr).Operation)
+
+		live := tg.Messages(testChannelID)
+		if assert.Len(t, live, 3) {
+			assert.Equal(t, 102, live[0].MessageID)
+			assert.Equal(t, "https://example.com/3.jpg", live[2].Media)
+		}
+
+		stored, _ := ads.Get(context.Background(), 1)
+		assert.Equal(t, 102, stored.ChatMessageId)
+		assert.Len(t, stored.Messages, 3)
+	})
+}
+
+func TestPreviewAd(t *testing.T) {
+	tg := telegramtest.NewServer()
+	defer tg.Close()
+
+	ads := repository.NewMemoryAdRepository()
+	h, _ := newPublishingAdHandler(tg, ads)
+	router := newAdTestRouter(h)
+	seedAds(t, ads, models.Ad{UserID: 1, Username: "landlord", Rooms: "2", Price: 60000, District: "JLT", Text: "2<3 bedrooms & a view"})
+
+	rr := serve(router, "GET", "/ads/1/preview", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var result previewResult
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.Equal(t, 1, result.AdID)
+	assert.Equal(t, testChannelID, result.ChannelID)
+	assert.Equal(t, "HTML", result.ParseMode)
+	assert.Contains(t, result.Caption, "\n\n2&lt;3 bedrooms &amp; a view\n\n")
+	assert.Empty(t, tg.Calls(), "previews are not sent to Telegram")
+
+	rr = serve(router, "GET", "/ads/1/preview?channel=@other", nil)
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.Equal(t, "@other", result.ChannelID)
+
+	rr = serve(router, "GET", "/ads/2/preview", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, response.CodeAdNotFound, decodeProblem(t, rr).Code)
+}
+
+func TestUpdateAdSync(t *testing.T) {
+	tg := telegramtest.NewServer()
+	defer tg.Close()
+
+	ads := repository.NewMemoryAdRepository()
+	h, worker := newPublishingAdHandler(tg, ads)
+	router := newAdTestRouter(h)
+	// Albums need at least two photos.
+	update := func(price int) models.Ad {
+		ad := validAd(1, price)
+		ad.Photos = models.LegacyPhotos("https://example.com/1.jpg,https://example.com/2.jpg")
+		return ad
+	}
+	seedAds(t, ads, update(60000))
+
+	assert.Equal(t, http.StatusAccepted, serve(router, "POST", "/ads/1/post", nil).Code)
+	_, err := worker.ProcessNext(context.Background())
+	require.NoError(t, err)
+
+	pending := func() []models.Job {
+		jobs, err := h.jobs.ListByAd(context.Background(), 1)
+		require.NoError(t, err)
+		var out []models.Job
+		for _, job := range jobs {
+			if job.Status == models.JobPending {
+				out = append(out, job)
+			}
+		}
+		return out
+	}
+
+	t.Run("Off By Default", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(router, "PUT", "/ads/1", update(55000)).Code)
+		assert.Empty(t, pending())
+	})
+
+	t.Run("Per Request", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(router, "PUT", "/ads/1?sync=true", update(50000)).Code)
+		jobs := pending()
+		if assert.Len(t, jobs, 1) {
+			assert.Equal(t, models.JobSync, jobs[0].Kind)
+		}
+
+		_, err := worker.ProcessNext(context.Background())
+		require.NoError(t, err)
+		calls := tg.CallsTo("editMessageCaption")
+		if assert.Len(t, calls, 1) {
+			var params telegram.EditMessageCaptionParams
+			assert.NoError(t, calls[0].Decode(&params))
+			assert.Contains(t, params.Caption, "Price: 50000 AED/Year")
+		}
+		assert.Empty(t, tg.CallsTo("editMessageMedia"))
+	})
+
+	h.AutoSync = true
+
+	t.Run("Unchanged Caption", func(t *testing.T) {
+		rr := serve(router, "PUT", "/ads/1", update(50000))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Empty(t, pending(), "nothing shown in the channel changed")
+	})
+
+	t.Run("Opt Out", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(router, "PUT", "/ads/1?sync=false", update(45000)).Code)
+		assert.Empty(t, pending())
+	})
+
+	t.Run("Global", func(t *testing.T) {
+		rr := serveWithHeader(router, "PATCH", "/ads/1", `{"price":40000}`, http.Header{"Content-Type": {"application/merge-patch+json"}})
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Len(t, pending(), 1)
+
+		_, err := worker.ProcessNext(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, tg.CallsTo("editMessageCaption"), 2)
+
+		stored, _ := ads.Get(context.Background(), 1)
+		caption, err := h.publisher.Caption(testChannelID, stored)
+		require.NoError(t, err)
+		assert.Equal(t, caption, stored.Messages[0].Caption)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		rr := serve(router, "PUT", "/ads/1?sync=maybe", update(35000))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, "sync", decodeProblem(t, rr).Errors[0].Field)
+
+		stored, _ := ads.Get(context.Background(), 1)
+		assert.Equal(t, 40000, stored.Price)
+	})
+}
+
+func TestLongTextReply(t *testing.T) {
+	tg := telegramtest.NewServer()
+	defer tg.Close()
+
+	ads := repository.NewMemoryAdRepository()
+	h, worker := newPublishingAdHandler(tg, ads)
+	h.publisher.FullTextReply = true
+	h.AutoSync = true
+	router := newAdTestRouter(h)
+	withText := func(text string) models.Ad {
+		ad := validAd(1, 60000)
+		ad.Photos = models.LegacyPhotos("https://example.com/1.jpg,https://example.com/2.jpg")
+		ad.Text = text
+		return ad
+	}
+	long := strings.Repeat("Sunny & quiet, ", 100)
+
+	rr := serve(router, "POST", "/ads", withText(long))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Contains(t, rr.Header().Get("Warning"), "truncated")
+
+	assert.Equal(t, http.StatusAccepted, serve(router, "POST", "/ads/1/post", nil).Code)
+	_, err := worker.ProcessNext(context.Background())
+	require.NoError(t, err)
+
+	live := tg.Messages(testChannelID)
+	if assert.Len(t, live, 3) {
+		assert.LessOrEqual(t, render.Length(live[0].Caption), render.MaxCaption)
+		assert.Contains(t, live[0].Caption, "Sunny &amp; quiet…")
+		assert.Equal(t, live[0].MessageID, live[2].ReplyTo)
+		assert.Equal(t, render.Escape(strings.TrimSpace(long)), live[2].Text)
+	}
+	stored, _ := ads.Get(context.Background(), 1)
+	if assert.Len(t, stored.Messages, 3) {
+		assert.True(t, stored.Messages[2].Reply)
+		assert.Equal(t, 2, stored.Messages[2].Position)
+	}
+
+	t.Run("Preview", func(t *testing.T) {
+		rr := serve(router, "GET", "/ads/1/preview", nil)
+		var result previewResult
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		assert.True(t, result.Truncated)
+		assert.Equal(t, render.Length(result.Caption), result.Length)
+		assert.Equal(t, live[2].Text, result.Reply)
+	})
+
+	t.Run("Edited", func(t *testing.T) {
+		rr := serve(router, "PUT", "/ads/1", withText(long+"Pets allowed."))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		_, err := worker.ProcessNext(context.Background())
+		require.NoError(t, err)
+
+		calls := tg.CallsTo("editMessageText")
+		if assert.Len(t, calls, 1) {
+			var params telegram.EditMessageTextParams
+			assert.NoError(t, calls[0].Decode(&params))
+			assert.Equal(t, live[2].MessageID, params.MessageID)
+			assert.True(t, strings.HasSuffix(params.Text, "Pets allowed."))
+		}
+		assert.Empty(t, tg.CallsTo("editMessageCaption"), "the truncated caption did not change")
+	})
+
+	t.Run("Fits Again", func(t *testing.T) {
+		rr := serve(router, "PUT", "/ads/1", withText("Short."))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Empty(t, rr.Header().Get("Warning"))
+		_, err := worker.ProcessNext(context.Background())
+		require.NoError(t, err)
+
+		_, ok := tg.Message(testChannelID, live[2].MessageID)
+		assert.False(t, ok, "the reply is removed")
+		stored, _ := ads.Get(context.Background(), 1)
+		assert.Len(t, stored.Messages, 2)
+	})
+}
+
+func decodeSyncResult(t *testing.T, rr *httptest.ResponseRecorder) publisher.SyncResult {
+	t.Helper()
+	var result editResult
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.NotZero(t, result.AdID)
+	return result.SyncResult
+}
+
+func TestDeleteAd(t *testing.T) {
+	tg := telegramtest.NewServer()
+	defer tg.Close()
+
+	ads := repository.NewMemoryAdRepository()
+	h, worker := newPublishingAdHandler(tg, ads)
+	router := newAdTestRouter(h)
+	seedAds(t, ads,
+		publishableAd(1, "https://example.com/1.jpg,https://example.com/2.jpg,https://example.com/3.jpg"),
+		publishableAd(1, "https://example.com/4.jpg,https://example.com/5.jpg"),
+	)
+	adminHeader := http.Header{AdminTokenHeader: {testAdminToken}}
+
+	for _, id := range []string{"1", "2"} {
+		assert.Equal(t, http.StatusAccepted, serve(router, "POST", "/ads/"+id+"/post", nil).Code)
+		_, err := worker.ProcessNext(context.Background())
+		assert.NoError(t, err)
+	}
+	assert.Len(t, tg.Messages(testChannelID), 5)
+
+	rr := serve(router, "DELETE", "/ads/1", nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	_, err := worker.ProcessNext(context.Background())
+	assert.NoError(t, err)
+
+	calls := tg.CallsTo("deleteMessage")
+	if assert.Len(t, calls, 3) {
+		for i, call := range calls {
+			var params telegram.DeleteMessageParams
+			assert.NoError(t, call.Decode(&params))
+			assert.Equal(t, telegram.DeleteMessageParams{ChatID: testChannelID, MessageID: 100 + i}, params)
+		}
+	}
+	assert.Len(t, tg.Messages(testChannelID), 2)
+
+	stored, err := ads.Get(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, models.AdDeleted, stored.State)
+
+	t.Run("Hidden From Listing", func(t *testing.T) {
+		var listed []models.Ad
+		json.Unmarshal(serve(router, "GET", "/ads", nil).Body.Bytes(), &listed)
+		if assert.Len(t, listed, 1) {
+			assert.Equal(t, 2, listed[0].ID)
+		}
+
+		json.Unmarshal(serve(router, "GET", "/ads?state=deleted", nil).Body.Bytes(), &listed)
+		if assert.Len(t, listed, 1) {
+			assert.Equal(t, 1, listed[0].ID)
+		}
+	})
+
+	t.Run("Idempotent", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, serve(router, "DELETE", "/ads/1", nil).Code)
+		processed, _ := worker.ProcessNext(context.Background())
+		assert.False(t, processed)
+	})
+
+	t.Run("Hard Delete Requires Admin", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve(router, "DELETE", "/ads/2?hard=true", nil).Code)
+		rr := serveWithHeader(router, "DELETE", "/ads/2?hard=true", nil, http.Header{AdminTokenHeader: {"wrong"}})
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Equal(t, http.StatusBadRequest, serve(router, "DELETE", "/ads/2?hard=maybe", nil).Code)
+	})
+
+	t.Run("Hard Delete", func(t *testing.T) {
+		tg.FailNext("deleteMessage", telegramtest.ServerError())
+		rr := serveWithHeader(router, "DELETE", "/ads/2?hard=true", nil, adminHeader)
+		assert.Equal(t, http.StatusBadGateway, rr.Code)
+		_, err := ads.Get(context.Background(), 2)
+		assert.NoError(t, err, "ad must survive a failed Telegram removal")
+
+		rr = serveWithHeader(router, "DELETE", "/ads/2?hard=true", nil, adminHeader)
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		assert.Empty(t, tg.Messages(testChannelID))
+		_, err = ads.Get(context.Background(), 2)
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+
+		rr = serveWithHeader(router, "DELETE", "/ads/1?hard=true", nil, adminHeader)
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		assert.Equal(t, http.StatusNotFound, serve(router, "GET", "/ads/1", nil).Code)
+	})
+}
+
+func TestMultiChannelPosting(t *testing.T) {
+	tg := telegramtest.NewServer()
+	defer tg.Close()
+
+	ads := repository.NewMemoryAdRepository()
+	h, worker := newPublishingAdHandler(tg, ads)
+	h.publisher.Channels = repository.NewMemoryChannelRepository(
+		models.Channel{ID: "@marina_rentals", Districts: []string{"dubai marina"}},
+		models.Channel{ID: "@all_rentals"},
+		models.Channel{ID: "@jlt_rentals", Districts: []string{"JLT"}},
+	)
+	router := newAdTestRouter(h)
+	ad := validAd(1, 90000)
+	ad.Photos = models.LegacyPhotos("https://example.com/1.jpg,https://example.com/2.jpg")
+	ad.District = "Dubai Marina"
+	seedAds(t, ads, ad)
+
+	t.Run("Unknown Channel", func(t *testing.T) {
+		rr := serve(router, "POST", "/ads/1/post?channel=@nowhere", nil)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, response.CodeValidation, decodeProblem(t, rr).Code)
+	})
+
+	rr := serve(router, "POST", "/ads/1/post", nil)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	var queued postResult
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &queued))
+	assert.Len(t, queued.Jobs, 2, "one job per channel")
+	for range queued.Jobs {
+		_, err := worker.ProcessNext(context.Background())
+		require.NoError(t, err)
+	}
+	assert.Len(t, tg.Messages("@all_rentals"), 2)
+	assert.Len(t, tg.Messages("@marina_rentals"), 2)
+	assert.Empty(t, tg.Messages("@jlt_rentals"))
+
+	stored, _ := ads.Get(context.Background(), 1)
+	assert.Equal(t, []string{"@all_rentals", "@marina_rentals"}, h.publisher.PostedChannels(stored))
+
+	t.Run("Already Posted To Every Match", func(t *testing.T) {
+		rr := serve(router, "POST", "/ads/1/post", nil)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, response.CodeAlreadyPosted, decodeProblem(t, rr).Code)
+	})
+
+	t.Run("Manual Override", func(t *testing.T) {
+		rr := serve(router, "POST", "/ads/1/post?channel=@jlt_rentals", nil)
+		assert.Equal(t, http.StatusAccepted, rr.Code)
+		var result postResult
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		assert.Equal(t, "@jlt_rentals", result.Job.ChannelID)
+
+		_, err := worker.ProcessNext(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, tg.Messages("@jlt_rentals"), 2)
+		assert.Len(t, tg.CallsTo("sendMediaGroup"), 3)
+	})
+
+	t.Run("Sync Reaches Every Channel", func(t *testing.T) {
+		ad, _ := ads.Get(context.Background(), 1)
+		ad.Price = 85000
+		require.NoError(t, ads.Update(context.Background(), &ad))
+
+		rr := serve(router, "POST", "/ads/1/edit-post", nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		result := decodeSyncResult(t, rr)
+		assert.Equal(t, "edit_caption", result.Operation)
+		assert.Len(t, result.Channels, 3)
+		assert.Len(t, tg.CallsTo("editMessageCaption"), 3)
+
+		ad, _ = ads.Get(context.Background(), 1)
+		ad.Photos = models.LegacyPhotos("https://example.com/1.jpg,https://example.com/2.jpg,https://example.com/3.jpg")
+		require.NoError(t, ads.Update(context.Background(), &ad))
+		rr = serve(router, "POST", "/ads/1/edit-post", nil)
+		assert.Equal(t, "repost", decodeSyncResult(t, rr).Operation)
+		for _, channelID := range []string{"@all_rentals", "@jlt_rentals", "@marina_rentals"} {
+			assert.Len(t, tg.Messages(channelID), 3, channelID)
+		}
+		stored, _ := ads.Get(context.Background(), 1)
+		assert.Len(t, stored.Messages, 9)
+	})
+
+	t.Run("Delete Reaches Every Channel", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, serve(router, "DELETE", "/ads/1", nil).Code)
+		_, err := worker.ProcessNext(context.Background())
+		require.NoError(t, err)
+		for _, channelID := range []string{"@all_rentals", "@jlt_rentals", "@marina_rentals"} {
+			assert.Empty(t, tg.Messages(channelID), channelID)
+		}
+	})
+
+	t.Run("No Matching Channel", func(t *testing.T) {
+		ad := validAd(1, 50000)
+		ad.District = "Deira"
+		h.publisher.Channels = repository.NewMemoryChannelRepository(models.Channel{ID: "@jlt_rentals", Districts: []string{"JLT"}})
+		seedAds(t, ads, ad)
+
+		rr := serve(router, "POST", "/ads/2/post", nil)
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+		assert.Equal(t, response.CodeNoMatchingChannel, decodeProblem(t, rr).Code)
+	})
+}
+
+func TestBumpAd(t *testing.T) {
+	tg := telegramtest.NewServer()
+	defer tg.Close()
+
+	ads := repository.NewMemoryAdRepository()
+	h, worker := newPublishingAdHandler(tg, ads)
+	h.BumpCooldown = time.Hour
+	router := newAdTestRouter(h)
+	ad := validAd(1, 90000)
+	ad.Photos = models.LegacyPhotos("https://example.com/1.jpg,https://example.com/2.jpg")
+	seedAds(t, ads, ad)
+
+	t.Run("Not Posted", func(t *testing.T) {
+		rr := serve(router, "POST", "/ads/1/bump", nil)
+		assert.Equal(t, http.StatusConflict, rr.Code, "drafts cannot be bumped")
+		assert.Equal(t, response.CodeInvalidTransition, decodeProblem(t, rr).Code)
+	})
+
+	assert.Equal(t, http.StatusAccepted, serve(router, "POST", "/ads/1/post", nil).Code)
+	_, err := worker.ProcessNext(context.Background())
+	require.NoError(t, err)
+	posted, _ := ads.Get(context.Background(), 1)
+	require.NotNil(t, posted.ListedAt)
+
+	t.Run("Cooldown", func(t *testing.T) {
+		rr := serve(router, "POST", "/ads/1/bump", nil)
+		assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+		assert.Equal(t, response.CodeBumpCooldown, decodeProblem(t, rr).Code)
+		assert.Equal(t, "3600", rr.Header().Get("Retry-After"))
+	})
+
+	h.BumpCooldown = 0
+	rr := serve(router, "POST", "/ads/1/bump", nil)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	var queued postResult
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &queued))
+	assert.Equal(t, models.JobBump, queued.Job.Kind)
+	assert.Equal(t, testChannelID, queued.Job.ChannelID)
+
+	t.Run("Already Queued", func(t *testing.T) {
+		rr := serve(router, "POST", "/ads/1/bump", nil)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.Equal(t, response.CodeAlreadyQueued, decodeProblem(t, rr).Code)
+	})
+
+	_, err = worker.ProcessNext(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, tg.CallsTo("sendMediaGroup"), 2)
+	assert.Len(t, tg.CallsTo("deleteMessage"), 2)
+	messages := tg.Messages(testChannelID)
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, 102, messages[0].MessageID, "the old album is gone")
+	}
+	bumped, _ := ads.Get(context.Background(), 1)
+	assert.Equal(t, 102, bumped.ChatMessageId)
+	if assert.NotNil(t, bumped.ListedAt) {
+		assert.True(t, bumped.ListedAt.After(*posted.ListedAt), "the listing restarts")
+	}
+
+	t.Run("Expired", func(t *testing.T) {
+		rr := serve(router, "POST", "/ads/1/transitions", map[string]interface{}{"to": "expired"})
+		require.Equal(t, http.StatusCreated, rr.Code)
+		_, err := worker.ProcessNext(context.Background())
+		require.NoError(t, err)
+		if m, ok := tg.Message(testChannelID, 102); assert.True(t, ok) {
+			assert.Contains(t, m.Caption, "<b>NO LONGER AVAILABLE</b>")
+		}
+
+		rr = serve(router, "POST", "/ads/1/bump", nil)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.Equal(t, response.CodeInvalidTransition, decodeProblem(t, rr).Code)
 	})
 }
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/response"
+	"github.com/1karp/ads_api/internal/app/validation"
+	"github.com/gorilla/mux"
+)
+
+// ChannelHandler manages the channels ads are routed to. Removing a
+// channel stops new ads from going there; ads already posted to it are
+// still edited and deleted there.
+type ChannelHandler struct {
+	channels repository.ChannelRepository
+}
+
+func NewChannelHandler(channels repository.ChannelRepository) *ChannelHandler {
+	return &ChannelHandler{channels: channels}
+}
+
+// RequireAdmin rejects requests that do not carry the admin token. An
+// empty token refuses every request.
+func RequireAdmin(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		given := r.Header.Get(AdminTokenHeader)
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			response.Error(w, r, http.StatusForbidden, response.CodeForbidden, "Admin token required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (h *ChannelHandler) ListChannels(w http.ResponseWriter, r *http.Request) {
+	channels, err := h.channels.List(r.Context())
+	if err != nil {
+		response.Internal(w, r, "Error querying channels", err)
+		return
+	}
+	if channels == nil {
+		channels = []models.Channel{}
+	}
+	response.JSON(w, http.StatusOK, channels)
+}
+
+func (h *ChannelHandler) GetChannel(w http.ResponseWriter, r *http.Request) {
+	channel, err := h.channels.Get(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeChannelError(w, r, "Error fetching channel", err)
+		return
+	}
+	response.JSON(w, http.StatusOK, channel)
+}
+
+// PutChannel creates or replaces the channel named by {id}. An id in the
+// body must match it.
+func (h *ChannelHandler) PutChannel(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var channel models.Channel
+	if !decodeJSON(w, r, &channel) {
+		return
+	}
+	if channel.ID != "" && channel.ID != id {
+		writeFieldError(w, r, &fieldError{Field: "id", Message: "must match the channel in the URL"})
+		return
+	}
+	channel.ID = id
+	if errs := validation.Channel.Check(channel); len(errs) > 0 {
+		response.Invalid(w, r, errs)
+		return
+	}
+	if channel.Districts == nil {
+		channel.Districts = []string{}
+	}
+	if channel.Types == nil {
+		channel.Types = []string{}
+	}
+
+	created, err := h.channels.Save(r.Context(), channel)
+	if err != nil {
+		response.Internal(w, r, "Error saving channel", err, "channel_id", id)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	response.JSON(w, status, channel)
+	slog.Info("Channel saved", "channel_id", id, "created", created)
+}
+
+func (h *ChannelHandler) DeleteChannel(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := h.channels.Delete(r.Context(), id); err != nil {
+		writeChannelError(w, r, "Error deleting channel", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	slog.Info("Channel deleted", "channel_id", id)
+}
+
+func writeChannelError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	if errors.Is(err, repository.ErrNotFound) {
+		response.Error(w, r, http.StatusNotFound, response.CodeChannelNotFound, "Channel not found")
+		return
+	}
+	response.Internal(w, r, msg, err)
+}
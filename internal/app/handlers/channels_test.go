@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/response"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newChannelTestRouter(h *ChannelHandler) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/channels", h.ListChannels).Methods("GET")
+	router.HandleFunc("/channels/{id}", h.GetChannel).Methods("GET")
+	router.HandleFunc("/channels/{id}", RequireAdmin(testAdminToken, h.PutChannel)).Methods("PUT")
+	router.HandleFunc("/channels/{id}", RequireAdmin(testAdminToken, h.DeleteChannel)).Methods("DELETE")
+	return router
+}
+
+func TestChannels(t *testing.T) {
+	router := newChannelTestRouter(NewChannelHandler(repository.NewMemoryChannelRepository()))
+	admin := http.Header{AdminTokenHeader: {testAdminToken}}
+
+	rr := serve(router, "GET", "/channels", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, "[]", rr.Body.String())
+
+	channel := map[string]interface{}{"name": "Marina", "districts": []string{"Dubai Marina"}, "max_price": 100000}
+	t.Run("Requires Admin", func(t *testing.T) {
+		rr := serve(router, "PUT", "/channels/@marina_rentals", channel)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Equal(t, response.CodeForbidden, decodeProblem(t, rr).Code)
+	})
+
+	rr = serveWithHeader(router, "PUT", "/channels/@marina_rentals", channel, admin)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var created models.Channel
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	assert.Equal(t, "@marina_rentals", created.ID)
+	assert.Equal(t, []string{}, created.Types)
+	if assert.NotNil(t, created.MaxPrice) {
+		assert.Equal(t, 100000, *created.MaxPrice)
+	}
+
+	channel["disabled"] = true
+	assert.Equal(t, http.StatusOK, serveWithHeader(router, "PUT", "/channels/@marina_rentals", channel, admin).Code)
+	var fetched models.Channel
+	rr = serve(router, "GET", "/channels/@marina_rentals", nil)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &fetched))
+	assert.True(t, fetched.Disabled)
+
+	t.Run("Invalid", func(t *testing.T) {
+		for path, body := range map[string]interface{}{
+			"/channels/marina":          map[string]interface{}{},
+			"/channels/@marina_rentals": map[string]interface{}{"types": []string{"castle"}},
+			"/channels/@jlt_rentals":    map[string]interface{}{"min_price": 90000, "max_price": 50000},
+			"/channels/@dubai_villas":   map[string]interface{}{"id": "@other_channel"},
+		} {
+			rr := serveWithHeader(router, "PUT", path, body, admin)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, path)
+		}
+	})
+
+	assert.Equal(t, http.StatusNoContent, serveWithHeader(router, "DELETE", "/channels/@marina_rentals", nil, admin).Code)
+
+	t.Run("Not Found", func(t *testing.T) {
+		rr := serve(router, "GET", "/channels/@marina_rentals", nil)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, response.CodeChannelNotFound, decodeProblem(t, rr).Code)
+		assert.Equal(t, http.StatusNotFound, serveWithHeader(router, "DELETE", "/channels/@marina_rentals", nil, admin).Code)
+	})
+}
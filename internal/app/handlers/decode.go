@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/1karp/ads_api/internal/app/response"
+	"github.com/1karp/ads_api/internal/app/validation"
+)
+
+// decodeJSON decodes a JSON request body into v, refusing fields v does
+// not have. On failure it writes the error response itself.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	return decodeJSONFrom(w, r, r.Body, v)
+}
+
+// decodeJSONFrom is decodeJSON for a body already read, e.g. one that had
+// a patch applied.
+func decodeJSONFrom(w http.ResponseWriter, r *http.Request, body io.Reader, v interface{}) bool {
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil {
+		return true
+	}
+
+	slog.Warn("Error decoding request body", "error", err)
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		response.Invalid(w, r, validation.Errors{{Field: strings.Trim(field, `"`), Message: "is not a known field"}})
+		return false
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		response.Invalid(w, r, validation.Errors{{Field: typeErr.Field, Message: fmt.Sprintf("cannot be a %s", typeErr.Value)}})
+		return false
+	}
+	response.Error(w, r, http.StatusBadRequest, response.CodeMalformedBody, "The request body is not valid JSON: "+err.Error())
+	return false
+}
+
+// writeFieldError answers a request with one invalid parameter.
+func writeFieldError(w http.ResponseWriter, r *http.Request, err *fieldError) {
+	response.Invalid(w, r, validation.Errors{{Field: err.Field, Message: err.Message}})
+}
+
+// writeParamError is writeFieldError for the error of a parser of query
+// parameters. Errors other than a *fieldError are unexpected and answered
+// with a 500.
+func writeParamError(w http.ResponseWriter, r *http.Request, err error) {
+	var fe *fieldError
+	if !errors.As(err, &fe) {
+		response.Internal(w, r, "Error parsing request parameters", err)
+		return
+	}
+	writeFieldError(w, r, fe)
+}
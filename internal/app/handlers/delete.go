@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/publisher"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/response"
+)
+
+// AdminTokenHeader carries the token required for admin-only operations.
+const AdminTokenHeader = "X-Admin-Token"
+
+// RequireAdminForHardDelete rejects ?hard=true requests that do not carry
+// the admin token. An empty token disables hard deletes entirely.
+func RequireAdminForHardDelete(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hard, err := hardDelete(r)
+		if err != nil {
+			writeParamError(w, r, err)
+			return
+		}
+		if hard {
+			given := r.Header.Get(AdminTokenHeader)
+			if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				response.Error(w, r, http.StatusForbidden, response.CodeForbidden, "Admin token required for hard delete")
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+func hardDelete(r *http.Request) (bool, error) {
+	q := r.URL.Query()
+	if !q.Has("hard") {
+		return false, nil
+	}
+	hard, err := strconv.ParseBool(q.Get("hard"))
+	if err != nil {
+		return false, &fieldError{Field: "hard", Message: "must be a boolean"}
+	}
+	return hard, nil
+}
+
+// adRemover implements ad deletion for both the ad and the user endpoints.
+type adRemover struct {
+	ads         repository.AdRepository
+	transitions repository.TransitionRepository
+	publisher   *publisher.Publisher
+}
+
+// softDelete moves the ad to the deleted state; the outbox removes its
+// channel post. Deleting a deleted ad does nothing. A version that is not
+// zero must still be the ad's, as checked by ifMatch.
+func (d adRemover) softDelete(ctx context.Context, ad models.Ad, version int) error {
+	if ad.State == models.AdDeleted {
+		return nil
+	}
+	t := models.AdTransition{AdID: ad.ID, From: ad.State, To: models.AdDeleted, Version: version}
+	_, err := d.transitions.Transition(ctx, &t, transitionEffects(ad, models.AdDeleted), time.Now().UTC())
+	return err
+}
+
+// purge removes the channel post right away, since no row is left for the
+// outbox to work from, and then the ad itself, conditionally on version
+// like softDelete.
+func (d adRemover) purge(ctx context.Context, ad models.Ad, version int) error {
+	if ad.IsPosted == 1 {
+		if err := d.publisher.Delete(ctx, ad); err != nil {
+			return &telegramError{err}
+		}
+	}
+	err := d.ads.Delete(ctx, ad.ID, version)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// telegramError marks a failure of the Bot API rather than the database.
+type telegramError struct {
+	err error
+}
+
+func (e *telegramError) Error() string { return e.err.Error() }
+func (e *telegramError) Unwrap() error { return e.err }
+
+// writeDeleteError answers a failed removal: Telegram failures are
+// reported as such, a failed If-Match as 412 and other conflicts with
+// concurrent changes as 409.
+func writeDeleteError(w http.ResponseWriter, r *http.Request, msg string, err error, args ...interface{}) {
+	var tgErr *telegramError
+	switch {
+	case errors.As(err, &tgErr):
+		response.Unavailable(w, r, http.StatusBadGateway, response.CodeTelegramUnavailable, msg, err, args...)
+	case errors.Is(err, repository.ErrStale):
+		writeStale(w, r)
+	case errors.Is(err, repository.ErrConflict):
+		response.Error(w, r, http.StatusConflict, response.CodeConflict, "Ad changed while deleting; try again")
+	default:
+		response.Internal(w, r, msg, err, args...)
+	}
+}
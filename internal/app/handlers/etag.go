@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/1karp/ads_api/internal/app/response"
+)
+
+// etag is the entity tag of a resource at version.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// notModified sets the ETag of a resource about to be returned and
+// answers 304 when the client's If-None-Match already names it.
+func notModified(w http.ResponseWriter, r *http.Request, version int) bool {
+	tag := etag(version)
+	w.Header().Set("ETag", tag)
+	if !matchesETag(r.Header.Get("If-None-Match"), tag, true) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// ifMatch enforces If-Match against the current version of a resource,
+// writing a 412 when it does not match. It returns the version a
+// conditional write must still find, or 0 when the request is not
+// conditional.
+func ifMatch(w http.ResponseWriter, r *http.Request, version int) (int, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" || strings.TrimSpace(header) == "*" {
+		return 0, true
+	}
+	if !matchesETag(header, etag(version), false) {
+		writeStale(w, r)
+		return 0, false
+	}
+	return version, true
+}
+
+// writeStale answers a write based on an outdated version.
+func writeStale(w http.ResponseWriter, r *http.Request) {
+	response.Error(w, r, http.StatusPreconditionFailed, response.CodePreconditionFailed, "The resource has been modified; fetch it again")
+}
+
+// matchesETag reports whether the list of entity tags in header names tag.
+// Weak comparison, used for If-None-Match, ignores the W/ prefix; strong
+// comparison never matches a weak tag.
+func matchesETag(header, tag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/repository"
+)
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *fieldError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Message)
+}
+
+func parseAdFilter(q url.Values) (repository.AdFilter, error) {
+	var f repository.AdFilter
+	var err error
+
+	if f.UserID, err = parseNonNegativeInt(q, "userid"); err != nil {
+		return f, err
+	}
+	if f.MinPrice, err = parseNonNegativeInt(q, "min_price"); err != nil {
+		return f, err
+	}
+	if f.MaxPrice, err = parseNonNegativeInt(q, "max_price"); err != nil {
+		return f, err
+	}
+	if f.MinPrice != nil && f.MaxPrice != nil && *f.MinPrice > *f.MaxPrice {
+		return f, &fieldError{Field: "max_price", Message: "must be greater than or equal to min_price"}
+	}
+
+	if f.MinArea, err = parseNonNegativeInt(q, "min_area"); err != nil {
+		return f, err
+	}
+	if f.MaxArea, err = parseNonNegativeInt(q, "max_area"); err != nil {
+		return f, err
+	}
+	if f.MinArea != nil && f.MaxArea != nil && *f.MinArea > *f.MaxArea {
+		return f, &fieldError{Field: "max_area", Message: "must be greater than or equal to min_area"}
+	}
+
+	if f.Rooms, err = parseList(q, "rooms"); err != nil {
+		return f, err
+	}
+	if f.Types, err = parseList(q, "type"); err != nil {
+		return f, err
+	}
+
+	if q.Has("district") {
+		f.District = strings.TrimSpace(q.Get("district"))
+		if f.District == "" {
+			return f, &fieldError{Field: "district", Message: "must not be empty"}
+		}
+	}
+
+	if f.States, err = parseList(q, "state"); err != nil {
+		return f, err
+	}
+	for _, state := range f.States {
+		if !models.AdState(state).Valid() {
+			return f, &fieldError{Field: "state", Message: fmt.Sprintf("unknown state %q", state)}
+		}
+		if models.AdState(state) == models.AdDeleted {
+			f.IncludeDeleted = true
+		}
+	}
+
+	if q.Has("is_posted") {
+		v, err := strconv.ParseBool(q.Get("is_posted"))
+		if err != nil {
+			return f, &fieldError{Field: "is_posted", Message: "must be a boolean"}
+		}
+		f.IsPosted = &v
+	}
+
+	if q.Has("created_after") {
+		t, err := parseTimestamp(q.Get("created_after"))
+		if err != nil {
+			return f, &fieldError{Field: "created_after", Message: "must be an RFC 3339 timestamp or a YYYY-MM-DD date"}
+		}
+		f.CreatedAfter = &t
+	}
+
+	return f, nil
+}
+
+func parseNonNegativeInt(q url.Values, key string) (*int, error) {
+	if !q.Has(key) {
+		return nil, nil
+	}
+	v, err := strconv.Atoi(q.Get(key))
+	if err != nil || v < 0 {
+		return nil, &fieldError{Field: key, Message: "must be a non-negative integer"}
+	}
+	return &v, nil
+}
+
+// parseList accepts either repeated parameters (?type=a&type=b) or a
+// comma-separated value (?type=a,b).
+func parseList(q url.Values, key string) ([]string, error) {
+	if !q.Has(key) {
+		return nil, nil
+	}
+	var values []string
+	for _, raw := range q[key] {
+		for _, v := range strings.Split(raw, ",") {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				return nil, &fieldError{Field: key, Message: "must not contain empty values"}
+			}
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
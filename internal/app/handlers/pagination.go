@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/response"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// cursor is the decoded form of the opaque next_cursor token. It records
+// the sort it was issued for and the keyset of the last row returned.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v,omitempty"`
+	ID    int    `json:"id"`
+}
+
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// pageRequest is the parsed limit/cursor/sort portion of a listing query.
+// Paged is set when the client sent limit or cursor; only then is the
+// response wrapped in a page envelope, so clients reading a bare array
+// keep working.
+type pageRequest struct {
+	Sort  string
+	Limit int
+	After *cursor
+	Paged bool
+}
+
+// page is the response envelope returned to clients that opted into
+// pagination.
+type page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+func parsePageRequest(q url.Values, validSort func(string) bool, defaultSort string) (pageRequest, error) {
+	p := pageRequest{Sort: defaultSort}
+
+	if q.Has("sort") {
+		p.Sort = q.Get("sort")
+	}
+	if !validSort(p.Sort) {
+		return p, &fieldError{Field: "sort", Message: fmt.Sprintf("unsupported sort %q", p.Sort)}
+	}
+
+	if q.Has("limit") {
+		limit, err := strconv.Atoi(q.Get("limit"))
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return p, &fieldError{Field: "limit", Message: fmt.Sprintf("must be an integer between 1 and %d", maxPageLimit)}
+		}
+		p.Limit = limit
+		p.Paged = true
+	}
+
+	if q.Has("cursor") {
+		c, err := decodeCursor(q.Get("cursor"))
+		if err != nil {
+			return p, &fieldError{Field: "cursor", Message: "is malformed"}
+		}
+		if c.Sort != p.Sort {
+			return p, &fieldError{Field: "cursor", Message: "was issued for a different sort"}
+		}
+		p.After = c
+		p.Paged = true
+	}
+
+	if p.Paged && p.Limit == 0 {
+		p.Limit = defaultPageLimit
+	}
+
+	return p, nil
+}
+
+// options converts the request into repository list options. One extra
+// row is requested so has_more can be set without a separate count query.
+func (p pageRequest) options() repository.ListOptions {
+	opts := repository.ListOptions{Sort: p.Sort}
+	if p.Paged {
+		opts.Limit = p.Limit + 1
+	}
+	if p.After != nil {
+		opts.After = &repository.Keyset{Value: p.After.Value, ID: p.After.ID}
+	}
+	return opts
+}
+
+// paginate trims the extra row fetched by options and builds the envelope.
+func paginate[T any](p pageRequest, items []T, keyset func(T) repository.Keyset) page[T] {
+	result := page[T]{Items: items}
+	if result.Items == nil {
+		result.Items = []T{}
+	}
+	if len(items) > p.Limit {
+		result.Items = items[:p.Limit]
+		result.HasMore = true
+		k := keyset(result.Items[len(result.Items)-1])
+		result.NextCursor = cursor{Sort: p.Sort, Value: k.Value, ID: k.ID}.encode()
+	}
+	return result
+}
+
+func writeAdList(w http.ResponseWriter, p pageRequest, ads []models.Ad) {
+	var body interface{} = ads
+	if p.Paged {
+		body = paginate(p, ads, func(ad models.Ad) repository.Keyset { return repository.AdKeyset(p.Sort, ad) })
+	}
+
+	response.JSON(w, http.StatusOK, body)
+}
@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/response"
+	"github.com/1karp/ads_api/internal/app/storage"
+	"github.com/1karp/ads_api/internal/app/validation"
+	"github.com/gorilla/mux"
+)
+
+// maxPhotoSize is Telegram's limit for uploaded photos.
+const maxPhotoSize = 10 << 20
+
+// photoTypes are the accepted upload formats, the ones the image pipeline
+// can decode, and the extensions they are stored with.
+var photoTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// UploadPhotos stores the files of the multipart "photos" field, appends
+// their URLs to the ad's photos and queues them for processing.
+func (h *AdHandler) UploadPhotos(w http.ResponseWriter, r *http.Request) {
+	if h.media == nil {
+		response.Error(w, r, http.StatusServiceUnavailable, response.CodeStorageUnavailable, "Photo uploads are not configured")
+		return
+	}
+
+	ad, ok := h.loadAd(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, validation.MaxPhotos*maxPhotoSize+1<<20)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			response.Error(w, r, http.StatusRequestEntityTooLarge, response.CodePayloadTooLarge, "Upload too large")
+			return
+		}
+		slog.Warn("Error parsing upload", "error", err)
+		response.Error(w, r, http.StatusBadRequest, response.CodeMalformedBody, "The request is not a valid multipart form")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := r.MultipartForm.File["photos"]
+	if len(files) == 0 {
+		writeFieldError(w, r, &fieldError{Field: "photos", Message: "no files uploaded"})
+		return
+	}
+
+	if len(ad.Photos)+len(files) > validation.MaxPhotos {
+		writeFieldError(w, r, &fieldError{Field: "photos", Message: fmt.Sprintf("an ad has at most %d photos", validation.MaxPhotos)})
+		return
+	}
+
+	var keys []string
+	for _, fh := range files {
+		photo, key, err := h.storePhoto(r.Context(), ad.ID, fh)
+		if err != nil {
+			h.deletePhotos(keys)
+			var fieldErr *fieldError
+			if errors.As(err, &fieldErr) {
+				writeFieldError(w, r, fieldErr)
+				return
+			}
+			response.Internal(w, r, "Error storing photo", err, "ad_id", ad.ID)
+			return
+		}
+		keys = append(keys, key)
+		photo.Position = len(ad.Photos)
+		ad.Photos = append(ad.Photos, photo)
+	}
+
+	if err := h.ads.Update(r.Context(), &ad); err != nil {
+		h.deletePhotos(keys)
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			response.Error(w, r, http.StatusNotFound, response.CodeAdNotFound, "Ad not found")
+		case errors.Is(err, repository.ErrStale):
+			response.Error(w, r, http.StatusConflict, response.CodeConflict, "Ad changed while uploading; try again")
+		default:
+			response.Internal(w, r, "Error updating ad in database", err)
+		}
+		return
+	}
+
+	// A job that is still pending picks these photos up as well. One that
+	// is running checks the ad again before it finishes.
+	job := models.Job{AdID: ad.ID, Kind: models.JobProcessPhotos}
+	if err := h.jobs.Enqueue(r.Context(), &job, time.Now().UTC()); err != nil && !errors.Is(err, repository.ErrConflict) {
+		slog.Error("Error queueing photo processing", "ad_id", ad.ID, "error", err)
+	}
+
+	response.JSON(w, http.StatusCreated, ad)
+	slog.Info("Photos uploaded successfully", "ad_id", ad.ID, "count", len(keys))
+}
+
+// storePhoto checks the uploaded file by its content, not the name or
+// type the client claims, and stores it under a random key. The photo
+// returned has the URL and dimensions of the upload.
+func (h *AdHandler) storePhoto(ctx context.Context, adID int, fh *multipart.FileHeader) (models.Photo, string, error) {
+	if fh.Size > maxPhotoSize {
+		return models.Photo{}, "", &fieldError{Field: "photos", Message: fmt.Sprintf("%s is larger than %d MB", fh.Filename, maxPhotoSize>>20)}
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return models.Photo{}, "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return models.Photo{}, "", err
+	}
+	contentType := http.DetectContentType(head[:n])
+	ext, ok := photoTypes[contentType]
+	if !ok {
+		return models.Photo{}, "", &fieldError{Field: "photos", Message: fmt.Sprintf("%s is not a JPEG or PNG image", fh.Filename)}
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return models.Photo{}, "", err
+	}
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return models.Photo{}, "", &fieldError{Field: "photos", Message: fmt.Sprintf("%s is not a valid image", fh.Filename)}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return models.Photo{}, "", err
+	}
+
+	name := make([]byte, 16)
+	if _, err := rand.Read(name); err != nil {
+		return models.Photo{}, "", err
+	}
+	key := fmt.Sprintf("ads/%d/%s%s", adID, hex.EncodeToString(name), ext)
+	if err := h.media.Backend.Put(ctx, key, f, contentType); err != nil {
+		return models.Photo{}, "", err
+	}
+	return models.Photo{URL: h.media.URL(key), Width: cfg.Width, Height: cfg.Height}, key, nil
+}
+
+type photoOrderRequest struct {
+	Order []int `json:"order"`
+}
+
+// ReorderPhotos puts the ad's photos in the order of the given photo ids,
+// which must name each of its photos once.
+func (h *AdHandler) ReorderPhotos(w http.ResponseWriter, r *http.Request) {
+	ad, ok := h.loadAd(w, r)
+	if !ok {
+		return
+	}
+
+	var req photoOrderRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if !isPermutation(req.Order, ad.Photos) {
+		writeFieldError(w, r, &fieldError{Field: "order", Message: "must list the id of each photo of the ad once"})
+		return
+	}
+
+	err := h.ads.ReorderPhotos(r.Context(), ad.ID, req.Order)
+	if errors.Is(err, repository.ErrConflict) {
+		response.Error(w, r, http.StatusConflict, response.CodeConflict, "Photos changed while reordering")
+		return
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		response.Error(w, r, http.StatusNotFound, response.CodeAdNotFound, "Ad not found")
+		return
+	}
+	if err != nil {
+		response.Internal(w, r, "Error reordering photos", err, "ad_id", ad.ID)
+		return
+	}
+
+	h.writeAd(w, r, ad.ID)
+	slog.Info("Photos reordered successfully", "ad_id", ad.ID)
+}
+
+func isPermutation(ids []int, photos models.Photos) bool {
+	if len(ids) != len(photos) {
+		return false
+	}
+	seen := map[int]bool{}
+	for _, photo := range photos {
+		seen[photo.ID] = false
+	}
+	for _, id := range ids {
+		if used, ok := seen[id]; !ok || used {
+			return false
+		}
+		seen[id] = true
+	}
+	return true
+}
+
+// DeletePhoto removes one photo from the ad. Files in our storage are
+// deleted with it.
+func (h *AdHandler) DeletePhoto(w http.ResponseWriter, r *http.Request) {
+	ad, ok := h.loadAd(w, r)
+	if !ok {
+		return
+	}
+	photoID, err := strconv.Atoi(mux.Vars(r)["photoID"])
+	if err != nil {
+		response.Error(w, r, http.StatusNotFound, response.CodePhotoNotFound, "Photo not found")
+		return
+	}
+
+	var photo models.Photo
+	for _, p := range ad.Photos {
+		if p.ID == photoID {
+			photo = p
+		}
+	}
+
+	err = h.ads.DeletePhoto(r.Context(), ad.ID, photoID)
+	if errors.Is(err, repository.ErrNotFound) {
+		response.Error(w, r, http.StatusNotFound, response.CodePhotoNotFound, "Photo not found")
+		return
+	}
+	if err != nil {
+		response.Internal(w, r, "Error deleting photo", err, "ad_id", ad.ID, "photo_id", photoID)
+		return
+	}
+
+	var keys []string
+	for _, url := range []string{photo.URL, photo.Large, photo.Thumbnail} {
+		if key, ok := h.media.Key(url); ok && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	h.deletePhotos(keys)
+
+	w.WriteHeader(http.StatusNoContent)
+	slog.Info("Photo deleted successfully", "ad_id", ad.ID, "photo_id", photoID)
+}
+
+// writeAd responds with the ad as it is stored now.
+func (h *AdHandler) writeAd(w http.ResponseWriter, r *http.Request, id int) {
+	ad, err := h.ads.Get(r.Context(), id)
+	if err != nil {
+		response.Internal(w, r, "Error fetching ad details", err)
+		return
+	}
+
+	response.JSON(w, http.StatusOK, ad)
+}
+
+// deletePhotos removes photos from storage, e.g. ones stored by a failed
+// upload.
+func (h *AdHandler) deletePhotos(keys []string) {
+	for _, key := range keys {
+		if err := h.media.Backend.Delete(context.Background(), key); err != nil {
+			slog.Error("Error deleting photo", "key", key, "error", err)
+		}
+	}
+}
+
+type MediaHandler struct {
+	backend storage.Backend
+}
+
+func NewMediaHandler(backend storage.Backend) *MediaHandler {
+	return &MediaHandler{backend: backend}
+}
+
+// ServeMedia serves stored photos below /media/. Keys are random, so the
+// content never changes and may be cached indefinitely. Uploads are not
+// served until they are processed.
+func (h *MediaHandler) ServeMedia(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/media/")
+	if !storage.ValidKey(key) || storage.IsUpload(key) {
+		response.Error(w, r, http.StatusNotFound, response.CodeNotFound, "Media not found")
+		return
+	}
+
+	body, obj, err := h.backend.Open(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		response.Error(w, r, http.StatusNotFound, response.CodeNotFound, "Media not found")
+		return
+	}
+	if err != nil {
+		response.Internal(w, r, "Error opening media", err, "key", key)
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", obj.ContentType)
+	if obj.Size >= 0 {
+		w.Header().Set("Content-Length", fmt.Sprint(obj.Size))
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		io.Copy(w, body)
+	}
+}
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/outbox"
+	"github.com/1karp/ads_api/internal/app/publisher"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/storage"
+	"github.com/1karp/ads_api/internal/app/telegram/telegramtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testPNG  = encodeTestImage(png.Encode)
+	testJPEG = encodeTestImage(func(w io.Writer, img image.Image) error { return jpeg.Encode(w, img, nil) })
+)
+
+func encodeTestImage(encode func(io.Writer, image.Image) error) string {
+	var buf bytes.Buffer
+	encode(&buf, image.NewRGBA(image.Rect(0, 0, 640, 480)))
+	return buf.String()
+}
+
+// uploadBody encodes files, keyed by filename, as the "photos" field of a
+// multipart form.
+func uploadBody(t *testing.T, files ...[2]string) (string, http.Header) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, f := range files {
+		part, err := mw.CreateFormFile("photos", f[0])
+		require.NoError(t, err)
+		io.WriteString(part, f[1])
+	}
+	require.NoError(t, mw.Close())
+	return buf.String(), http.Header{"Content-Type": {mw.FormDataContentType()}}
+}
+
+func TestUploadPhotos(t *testing.T) {
+	tg := telegramtest.NewServer()
+	defer tg.Close()
+
+	media := &storage.Media{Backend: storage.NewLocal(t.TempDir()), BaseURL: "/media"}
+	ads := repository.NewMemoryAdRepository()
+	jobs := repository.NewMemoryJobRepository(ads)
+	transitions := repository.NewMemoryTransitionRepository(ads, jobs)
+	pub := publisher.New(tg.Client(), testChannelID, media, nil)
+	cfg := outbox.DefaultConfig()
+	cfg.MaxAttempts = 1
+	worker := outbox.NewWorker(jobs, ads, pub, media, cfg)
+
+	router := newAdTestRouter(NewAdHandler(ads, repository.NewMemoryUserRepository(), jobs, transitions, pub, media))
+	router.PathPrefix("/media/").HandlerFunc(NewMediaHandler(media.Backend).ServeMedia)
+	seedAds(t, ads, models.Ad{UserID: 1, Username: "landlord", Photos: models.LegacyPhotos("https://example.com/1.jpg"), Price: 85000, Type: "apartment", Area: 95, District: "Dubai Marina"})
+
+	body, header := uploadBody(t, [2]string{"a.png", testPNG}, [2]string{"b.jpg", testJPEG})
+	rr := serveWithHeader(router, "POST", "/ads/1/photos", body, header)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	var ad models.Ad
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &ad))
+	require.Len(t, ad.Photos, 3)
+	photos := ad.Photos.URLs()
+	assert.Equal(t, "https://example.com/1.jpg", photos[0])
+	assert.Regexp(t, `^/media/ads/1/[0-9a-f]{32}\.png$`, photos[1])
+	assert.Regexp(t, `^/media/ads/1/[0-9a-f]{32}\.jpg$`, photos[2])
+	assert.Equal(t, models.Photo{ID: ad.Photos[2].ID, URL: photos[2], Position: 2, Width: 640, Height: 480}, ad.Photos[2])
+
+	t.Run("Uploads Not Served", func(t *testing.T) {
+		// The upload may carry metadata such as a GPS position.
+		assert.Equal(t, http.StatusNotFound, serve(router, "GET", photos[1], nil).Code)
+		assert.Equal(t, http.StatusNotFound, serve(router, "GET", "/media/ads/1/missing.png", nil).Code)
+	})
+
+	t.Run("Processed", func(t *testing.T) {
+		_, err := worker.ProcessNext(context.Background())
+		require.NoError(t, err)
+
+		processed, _ := ads.Get(context.Background(), 1)
+		require.Len(t, processed.Photos, 3)
+		assert.Equal(t, models.Photo{ID: ad.Photos[0].ID, URL: "https://example.com/1.jpg"}, processed.Photos[0])
+		for i, photo := range processed.Photos[1:] {
+			base := strings.TrimSuffix(photos[i+1], path.Ext(photos[i+1]))
+			assert.Equal(t, models.Photo{
+				ID: ad.Photos[i+1].ID, URL: base + "/original.jpg", Position: i + 1, Width: 640, Height: 480,
+				Large: base + "/original.jpg", Thumbnail: base + "/thumbnail.jpg",
+			}, photo)
+		}
+
+		// The upload is replaced by the processed copy.
+		assert.Equal(t, http.StatusNotFound, serve(router, "GET", photos[1], nil).Code)
+		rr := serve(router, "GET", processed.Photos[1].Thumbnail, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "image/jpeg", rr.Header().Get("Content-Type"))
+		photos = processed.Photos.URLs()
+	})
+
+	t.Run("Posted As Files", func(t *testing.T) {
+		require.Equal(t, http.StatusAccepted, serve(router, "POST", "/ads/1/post", nil).Code)
+		_, err := worker.ProcessNext(context.Background())
+		require.NoError(t, err)
+
+		calls := tg.CallsTo("sendMediaGroup")
+		require.Len(t, calls, 1)
+		media := calls[0].Params["media"].([]interface{})
+		assert.Equal(t, "https://example.com/1.jpg", media[0].(map[string]interface{})["media"])
+		assert.Equal(t, "attach://photo1", media[1].(map[string]interface{})["media"])
+		assert.Equal(t, "attach://photo2", media[2].(map[string]interface{})["media"])
+		assert.Equal(t, "image/jpeg", http.DetectContentType(calls[0].Files["photo1"]))
+		assert.Equal(t, "image/jpeg", http.DetectContentType(calls[0].Files["photo2"]))
+
+		posted, _ := ads.Get(context.Background(), 1)
+		assert.Len(t, posted.Messages, 3)
+		assert.Equal(t, photos[1], posted.Messages[1].PhotoURL)
+	})
+
+	t.Run("Reorder", func(t *testing.T) {
+		ids := []int{ad.Photos[2].ID, ad.Photos[0].ID, ad.Photos[1].ID}
+		rr := serve(router, "PUT", "/ads/1/photos/order", map[string][]int{"order": ids})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		var reordered models.Ad
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &reordered))
+		assert.Equal(t, []string{photos[2], photos[0], photos[1]}, reordered.Photos.URLs())
+		for i, photo := range reordered.Photos {
+			assert.Equal(t, ids[i], photo.ID)
+			assert.Equal(t, i, photo.Position)
+		}
+
+		for _, order := range [][]int{ids[:2], {ids[0], ids[0], ids[1]}, {ids[0], ids[1], 999}} {
+			rr := serve(router, "PUT", "/ads/1/photos/order", map[string][]int{"order": order})
+			assert.Equal(t, http.StatusBadRequest, rr.Code, order)
+		}
+		photos = reordered.Photos.URLs()
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		stored, _ := ads.Get(context.Background(), 1)
+		removed := stored.Photos[2]
+
+		rr := serve(router, "DELETE", fmt.Sprintf("/ads/1/photos/%d", removed.ID), nil)
+		require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+
+		stored, _ = ads.Get(context.Background(), 1)
+		assert.Equal(t, photos[:2], stored.Photos.URLs())
+		assert.Equal(t, 1, stored.Photos[1].Position)
+		assert.Equal(t, http.StatusNotFound, serve(router, "GET", removed.URL, nil).Code)
+		assert.Equal(t, http.StatusNotFound, serve(router, "GET", removed.Thumbnail, nil).Code)
+
+		assert.Equal(t, http.StatusNotFound, serve(router, "DELETE", fmt.Sprintf("/ads/1/photos/%d", removed.ID), nil).Code)
+		assert.Equal(t, http.StatusNotFound, serve(router, "DELETE", "/ads/1/photos/abc", nil).Code)
+	})
+
+	t.Run("Corrupt Photo", func(t *testing.T) {
+		body, header := uploadBody(t, [2]string{"a.png", testPNG}, [2]string{"cut.png", testPNG[:len(testPNG)/2]})
+		rr := serveWithHeader(router, "POST", "/ads/1/photos", body, header)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		var uploaded models.Ad
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &uploaded))
+		require.Len(t, uploaded.Photos, 4)
+		corrupt := uploaded.Photos[3].URL
+
+		_, err := worker.ProcessNext(context.Background())
+		require.NoError(t, err)
+
+		stored, _ := ads.Get(context.Background(), 1)
+		require.Len(t, stored.Photos, 3, "the corrupt photo is removed")
+		assert.NotEmpty(t, stored.Photos[2].Large, "the other photo is processed")
+		key, _ := media.Key(corrupt)
+		_, _, err = media.Backend.Open(context.Background(), key)
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+
+		queued, _ := jobs.ListByAd(context.Background(), 1)
+		job := queued[len(queued)-1]
+		assert.Equal(t, models.JobProcessPhotos, job.Kind)
+		assert.Equal(t, models.JobFailed, job.Status)
+		assert.Contains(t, job.LastError, corrupt)
+	})
+
+	t.Run("Unprocessed Not Posted", func(t *testing.T) {
+		require.NoError(t, media.Backend.Put(context.Background(), "ads/2/raw.png", strings.NewReader(testPNG), "image/png"))
+		seedAds(t, ads, models.Ad{UserID: 1, Username: "landlord", Photos: models.LegacyPhotos("https://example.com/1.jpg,/media/ads/2/raw.png"), Price: 85000, Type: "apartment", Area: 95, District: "Dubai Marina"})
+		require.Equal(t, http.StatusAccepted, serve(router, "POST", "/ads/2/post", nil).Code)
+
+		_, err := worker.ProcessNext(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, tg.CallsTo("sendMediaGroup"), 1, "only the earlier post went out")
+		queued, _ := jobs.ListByAd(context.Background(), 2)
+		require.Len(t, queued, 1)
+		assert.Contains(t, queued[0].LastError, "not processed yet")
+	})
+
+	t.Run("Unsupported Type", func(t *testing.T) {
+		body, header := uploadBody(t, [2]string{"notes.png", "plain text"})
+		rr := serveWithHeader(router, "POST", "/ads/1/photos", body, header)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "not a JPEG or PNG image")
+	})
+
+	t.Run("Too Many Photos", func(t *testing.T) {
+		files := make([][2]string, 9)
+		for i := range files {
+			files[i] = [2]string{"a.png", testPNG}
+		}
+		body, header := uploadBody(t, files...)
+		rr := serveWithHeader(router, "POST", "/ads/1/photos", body, header)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "at most 10 photos")
+	})
+
+	t.Run("No Files", func(t *testing.T) {
+		body, header := uploadBody(t)
+		assert.Equal(t, http.StatusBadRequest, serveWithHeader(router, "POST", "/ads/1/photos", body, header).Code)
+	})
+
+	t.Run("Ad Not Found", func(t *testing.T) {
+		body, header := uploadBody(t, [2]string{"a.png", testPNG})
+		assert.Equal(t, http.StatusNotFound, serveWithHeader(router, "POST", "/ads/99/photos", body, header).Code)
+	})
+}
+
+// updateFailingAdRepository fails every update with err, e.g. as if the ad
+// changed or was deleted meanwhile.
+type updateFailingAdRepository struct {
+	*repository.MemoryAdRepository
+	err error
+}
+
+func (r updateFailingAdRepository) Update(context.Context, *models.Ad) error { return r.err }
+
+func TestUploadPhotosUpdateFails(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{repository.ErrStale, http.StatusConflict},
+		{repository.ErrNotFound, http.StatusNotFound},
+	} {
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			media := &storage.Media{Backend: storage.NewLocal(t.TempDir()), BaseURL: "/media"}
+			ads := updateFailingAdRepository{repository.NewMemoryAdRepository(), tc.err}
+			seedAds(t, ads, models.Ad{UserID: 1, Username: "landlord", Price: 85000})
+			router := newAdTestRouter(NewAdHandler(ads, repository.NewMemoryUserRepository(), nil, nil, nil, media))
+
+			body, header := uploadBody(t, [2]string{"a.png", testPNG})
+			rr := serveWithHeader(router, "POST", "/ads/1/photos", body, header)
+			assert.Equal(t, tc.want, rr.Code, rr.Body.String())
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/response"
+	"github.com/1karp/ads_api/internal/app/schedule"
+	"github.com/gorilla/mux"
+)
+
+// QueueHandler shows and edits the posting queue: the publish jobs still
+// waiting for their slot in a channel.
+type QueueHandler struct {
+	scheduler *schedule.Scheduler
+}
+
+func NewQueueHandler(scheduler *schedule.Scheduler) *QueueHandler {
+	return &QueueHandler{scheduler: scheduler}
+}
+
+type queueOrderRequest struct {
+	Order []int `json:"order"`
+}
+
+// GetQueue lists the queued publish jobs in the order they will run,
+// optionally only those of ?channel=.
+func (h *QueueHandler) GetQueue(w http.ResponseWriter, r *http.Request) {
+	jobs, err := h.scheduler.Queue(r.Context(), r.URL.Query().Get("channel"))
+	if err != nil {
+		response.Internal(w, r, "Error querying posting queue", err)
+		return
+	}
+	response.JSON(w, http.StatusOK, jobs)
+}
+
+// ReorderQueue reorders the queue of the {channel} route variable. The
+// body lists the ids of all its queued jobs in their new order.
+func (h *QueueHandler) ReorderQueue(w http.ResponseWriter, r *http.Request) {
+	channelID := mux.Vars(r)["channel"]
+	var req queueOrderRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	jobs, err := h.scheduler.Reorder(r.Context(), channelID, req.Order)
+	switch {
+	case errors.Is(err, schedule.ErrOrderMismatch), errors.Is(err, schedule.ErrBeforePublishAt):
+		writeFieldError(w, r, &fieldError{Field: "order", Message: err.Error()})
+		return
+	case errors.Is(err, repository.ErrConflict):
+		response.Error(w, r, http.StatusConflict, response.CodeConflict, "Posting queue changed concurrently")
+		return
+	case err != nil:
+		response.Internal(w, r, "Error reordering posting queue", err, "channel_id", channelID)
+		return
+	}
+
+	response.JSON(w, http.StatusOK, jobs)
+	slog.Info("Posting queue reordered", "channel_id", channelID, "order", req.Order)
+}
+
+// CancelQueued takes the publish job {id} off the queue. The ad itself is
+// left as it is.
+func (h *QueueHandler) CancelQueued(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeFieldError(w, r, &fieldError{Field: "id", Message: "must be an integer"})
+		return
+	}
+
+	err = h.scheduler.Cancel(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		response.Error(w, r, http.StatusNotFound, response.CodeJobNotFound, "No queued job with this id")
+		return
+	}
+	if err != nil {
+		response.Internal(w, r, "Error cancelling queued job", err, "job_id", id)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	slog.Info("Queued job cancelled", "job_id", id)
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/response"
+	"github.com/1karp/ads_api/internal/app/schedule"
+	"github.com/1karp/ads_api/internal/app/telegram/telegramtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostingQueue(t *testing.T) {
+	tg := telegramtest.NewServer()
+	defer tg.Close()
+
+	ads := repository.NewMemoryAdRepository()
+	h, _ := newPublishingAdHandler(tg, ads)
+	h.Scheduler = schedule.New(h.jobs, schedule.Pacing{Interval: time.Hour})
+	router := newAdTestRouter(h)
+	queue := NewQueueHandler(h.Scheduler)
+	router.HandleFunc("/queue", queue.GetQueue).Methods("GET")
+	router.HandleFunc("/queue/{channel}/order", queue.ReorderQueue).Methods("PUT")
+	router.HandleFunc("/queue/{id:[0-9]+}", queue.CancelQueued).Methods("DELETE")
+	seedAds(t, ads, validAd(1, 50000), validAd(1, 60000), validAd(1, 70000))
+
+	post := func(target string) models.Job {
+		t.Helper()
+		rr := serve(router, "POST", target, nil)
+		require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+		var result postResult
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		return result.Job
+	}
+	publishAt := time.Now().UTC().Add(24 * time.Hour).Truncate(time.Second)
+	scheduled := post("/ads/1/post?publish_at=" + publishAt.Format(time.RFC3339))
+	first := post("/ads/2/post")
+	second := post("/ads/3/post")
+
+	if assert.NotNil(t, scheduled.PublishAt) {
+		assert.Equal(t, publishAt, *scheduled.PublishAt)
+	}
+	assert.Equal(t, publishAt, scheduled.NextAttemptAt)
+	assert.Equal(t, time.Hour, second.NextAttemptAt.Sub(first.NextAttemptAt), "posts are spaced by the interval")
+
+	getQueue := func() []models.Job {
+		t.Helper()
+		rr := serve(router, "GET", "/queue?channel="+testChannelID, nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var jobs []models.Job
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &jobs))
+		return jobs
+	}
+	assert.Equal(t, []int{first.ID, second.ID, scheduled.ID}, queueIDs(getQueue()))
+
+	t.Run("Invalid Publish At", func(t *testing.T) {
+		rr := serve(router, "POST", "/ads/1/post?publish_at=tomorrow", nil)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, response.CodeValidation, decodeProblem(t, rr).Code)
+	})
+
+	t.Run("Reorder", func(t *testing.T) {
+		rr := serve(router, "PUT", "/queue/"+testChannelID+"/order", map[string]interface{}{"order": []int{second.ID, first.ID, scheduled.ID}})
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, []int{second.ID, first.ID, scheduled.ID}, queueIDs(getQueue()))
+
+		rr = serve(router, "PUT", "/queue/"+testChannelID+"/order", map[string]interface{}{"order": []int{scheduled.ID, first.ID, second.ID}})
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "a scheduled post cannot be moved before its publish_at")
+		rr = serve(router, "PUT", "/queue/"+testChannelID+"/order", map[string]interface{}{"order": []int{first.ID}})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, serve(router, "DELETE", "/queue/"+strconv.Itoa(scheduled.ID), nil).Code)
+		assert.Equal(t, []int{second.ID, first.ID}, queueIDs(getQueue()))
+
+		rr := serve(router, "DELETE", "/queue/"+strconv.Itoa(scheduled.ID), nil)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, response.CodeJobNotFound, decodeProblem(t, rr).Code)
+
+		rr = serve(router, "GET", "/ads/1/publications", nil)
+		var jobs []models.Job
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &jobs))
+		if assert.Len(t, jobs, 1) {
+			assert.Equal(t, models.JobCancelled, jobs[0].Status)
+		}
+	})
+}
+
+func queueIDs(jobs []models.Job) []int {
+	ids := make([]int, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.ID
+	}
+	return ids
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/response"
+	"github.com/1karp/ads_api/internal/app/validation"
+)
+
+type transitionRequest struct {
+	To   models.AdState `json:"to"`
+	Note string         `json:"note"`
+}
+
+type transitionResponse struct {
+	models.AdTransition
+	Jobs []models.Job `json:"jobs"`
+}
+
+// transitionEffects returns the Telegram work caused by moving ad to the
+// given state. Jobs for ads that turn out not to be posted complete
+// without calling Telegram. Publishing an ad not posted yet is left to
+// publishEffects, which needs the channels it goes to.
+func transitionEffects(ad models.Ad, to models.AdState) []models.Job {
+	switch to {
+	case models.AdPublished:
+		if ad.IsPosted == 1 {
+			return []models.Job{{AdID: ad.ID, Kind: models.JobEditCaption}}
+		}
+	case models.AdRented, models.AdExpired:
+		return []models.Job{{AdID: ad.ID, Kind: models.JobEditCaption}}
+	case models.AdDeleted:
+		return []models.Job{{AdID: ad.ID, Kind: models.JobDelete}}
+	}
+	return nil
+}
+
+// transition moves ad to the given state, queueing effects with it, and
+// writes a 409 when the move is not allowed or the ad changed state
+// meanwhile.
+func (h *AdHandler) transition(w http.ResponseWriter, r *http.Request, ad models.Ad, to models.AdState, note string, effects []models.Job) (transitionResponse, bool) {
+	if !ad.State.CanTransitionTo(to) {
+		response.Error(w, r, http.StatusConflict, response.CodeInvalidTransition, fmt.Sprintf("Cannot transition ad from %s to %s", ad.State, to))
+		return transitionResponse{}, false
+	}
+
+	t := models.AdTransition{AdID: ad.ID, From: ad.State, To: to, Note: note}
+	jobs, err := h.transitions.Transition(r.Context(), &t, effects, time.Now().UTC())
+	if err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			response.Error(w, r, http.StatusConflict, response.CodeConflict, "Ad state changed concurrently")
+		} else {
+			response.Internal(w, r, "Error recording ad transition", err)
+		}
+		return transitionResponse{}, false
+	}
+
+	slog.Info("Ad transitioned", "ad_id", ad.ID, "from", t.From, "to", t.To)
+	return transitionResponse{AdTransition: t, Jobs: jobs}, true
+}
+
+// checkPublishable writes a 422 listing what ad lacks to be published,
+// such as the photos a draft may be saved without.
+func checkPublishable(w http.ResponseWriter, r *http.Request, ad models.Ad) bool {
+	ad.State = models.AdPublished
+	if errs := validation.Ad.Check(ad); len(errs) > 0 {
+		response.Unprocessable(w, r, response.CodeNotPublishable, "The ad cannot be published.", errs)
+		return false
+	}
+	return true
+}
+
+// publishEffects returns the publish jobs of an ad about to be published,
+// one per channel routing picks, planned into each channel's queue like
+// those of PostAd. It writes a 422 when no channel matches the ad.
+func (h *AdHandler) publishEffects(w http.ResponseWriter, r *http.Request, ad models.Ad) ([]models.Job, bool) {
+	targets, err := h.publisher.Targets(r.Context(), ad)
+	if err != nil {
+		response.Internal(w, r, "Error routing ad", err, "ad_id", ad.ID)
+		return nil, false
+	}
+	if len(targets) == 0 {
+		response.Error(w, r, http.StatusUnprocessableEntity, response.CodeNoMatchingChannel, "No channel matches the ad")
+		return nil, false
+	}
+	jobs, err := h.publishJobs(r.Context(), ad.ID, targets, nil)
+	if err != nil {
+		response.Internal(w, r, "Error scheduling publish jobs", err, "ad_id", ad.ID)
+		return nil, false
+	}
+	return jobs, true
+}
+
+func (h *AdHandler) CreateTransition(w http.ResponseWriter, r *http.Request) {
+	ad, ok := h.loadAd(w, r)
+	if !ok {
+		return
+	}
+
+	var req transitionRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if !req.To.Valid() {
+		writeFieldError(w, r, &fieldError{Field: "to", Message: fmt.Sprintf("unknown state %q", req.To)})
+		return
+	}
+
+	effects := transitionEffects(ad, req.To)
+	if req.To == models.AdPublished && ad.State.CanTransitionTo(req.To) {
+		if !checkPublishable(w, r, ad) {
+			return
+		}
+		if ad.IsPosted != 1 {
+			if effects, ok = h.publishEffects(w, r, ad); !ok {
+				return
+			}
+		}
+	}
+	resp, ok := h.transition(w, r, ad, req.To, req.Note, effects)
+	if !ok {
+		return
+	}
+
+	response.JSON(w, http.StatusCreated, resp)
+}
+
+func (h *AdHandler) GetTransitions(w http.ResponseWriter, r *http.Request) {
+	ad, ok := h.loadAd(w, r)
+	if !ok {
+		return
+	}
+
+	transitions, err := h.transitions.ListTransitions(r.Context(), ad.ID)
+	if err != nil {
+		response.Internal(w, r, "Error querying ad transitions", err)
+		return
+	}
+
+	response.JSON(w, http.StatusOK, transitions)
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/response"
+	"github.com/1karp/ads_api/internal/app/schedule"
+	"github.com/1karp/ads_api/internal/app/telegram"
+	"github.com/1karp/ads_api/internal/app/telegram/telegramtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateTransition(t *testing.T) {
+	tg := telegramtest.NewServer()
+	defer tg.Close()
+
+	ads := repository.NewMemoryAdRepository()
+	h, worker := newPublishingAdHandler(tg, ads)
+	router := newAdTestRouter(h)
+	seedAds(t, ads, models.Ad{UserID: 1, Username: "landlord", Photos: models.LegacyPhotos("https://example.com/1.jpg,https://example.com/2.jpg"), Rooms: "1", Price: 60000, Type: "apartment", Area: 40, District: "JLT"})
+
+	transition := func(to models.AdState) (int, transitionResponse) {
+		rr := serve(router, "POST", "/ads/1/transitions", map[string]string{"to": string(to), "note": "test"})
+		var resp transitionResponse
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp
+	}
+	drain := func() {
+		for {
+			processed, err := worker.ProcessNext(context.Background())
+			assert.NoError(t, err)
+			if !processed {
+				return
+			}
+		}
+	}
+
+	t.Run("Unknown State", func(t *testing.T) {
+		code, _ := transition("sold")
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("Illegal Transition", func(t *testing.T) {
+		code, _ := transition(models.AdRented)
+		assert.Equal(t, http.StatusConflict, code)
+	})
+
+	code, resp := transition(models.AdPendingReview)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, models.AdDraft, resp.From)
+	assert.Empty(t, resp.Jobs)
+
+	code, resp = transition(models.AdPublished)
+	assert.Equal(t, http.StatusCreated, code)
+	if assert.Len(t, resp.Jobs, 1) {
+		assert.Equal(t, models.JobPublish, resp.Jobs[0].Kind)
+		assert.Equal(t, testChannelID, resp.Jobs[0].ChannelID)
+	}
+	drain()
+	assert.Len(t, tg.Messages(testChannelID), 2)
+
+	code, resp = transition(models.AdRented)
+	assert.Equal(t, http.StatusCreated, code)
+	if assert.Len(t, resp.Jobs, 1) {
+		assert.Equal(t, models.JobEditCaption, resp.Jobs[0].Kind)
+	}
+	drain()
+
+	calls := tg.CallsTo("editMessageCaption")
+	if assert.Len(t, calls, 1) {
+		var params telegram.EditMessageCaptionParams
+		assert.NoError(t, calls[0].Decode(&params))
+		assert.Equal(t, 100, params.MessageID)
+		assert.Equal(t, "<b>RENTED</b>\n\n#JLT, #under_60000\n\nRooms: 1\nPrice: 60000 AED/Year\nType: apartment\nArea: 40 sqm\nBuilding: \nDistrict: JLT\n\n\n\nContact: @landlord", params.Caption)
+	}
+
+	code, _ = transition(models.AdArchived)
+	assert.Equal(t, http.StatusCreated, code)
+	drain()
+	assert.Len(t, tg.Messages(testChannelID), 2, "archiving keeps the post")
+
+	code, resp = transition(models.AdDeleted)
+	assert.Equal(t, http.StatusCreated, code)
+	if assert.Len(t, resp.Jobs, 1) {
+		assert.Equal(t, models.JobDelete, resp.Jobs[0].Kind)
+	}
+	drain()
+
+	assert.Len(t, tg.CallsTo("deleteMessage"), 2)
+	assert.Empty(t, tg.Messages(testChannelID))
+	stored, _ := ads.Get(context.Background(), 1)
+	assert.Equal(t, models.AdDeleted, stored.State)
+	assert.Equal(t, 0, stored.IsPosted)
+
+	t.Run("Deleted Is Terminal", func(t *testing.T) {
+		code, _ := transition(models.AdDraft)
+		assert.Equal(t, http.StatusConflict, code)
+		assert.Equal(t, http.StatusConflict, serve(router, "POST", "/ads/1/post", nil).Code)
+	})
+
+	t.Run("History", func(t *testing.T) {
+		rr := serve(router, "GET", "/ads/1/transitions", nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var history []models.AdTransition
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &history))
+		var path []models.AdState
+		for _, tr := range history {
+			path = append(path, tr.To)
+		}
+		assert.Equal(t, []models.AdState{models.AdPendingReview, models.AdPublished, models.AdRented, models.AdArchived, models.AdDeleted}, path)
+		assert.Equal(t, "test", history[0].Note)
+	})
+}
+
+func TestTransitionPublishPaced(t *testing.T) {
+	tg := telegramtest.NewServer()
+	defer tg.Close()
+
+	ads := repository.NewMemoryAdRepository()
+	h, worker := newPublishingAdHandler(tg, ads)
+	// Quiet hours from an hour ago until an hour from now.
+	now := time.Now().UTC()
+	sinceMidnight := now.Sub(now.Truncate(24 * time.Hour))
+	h.Scheduler = schedule.New(h.jobs, schedule.Pacing{
+		QuietStart: (sinceMidnight + 23*time.Hour) % (24 * time.Hour),
+		QuietEnd:   (sinceMidnight + time.Hour) % (24 * time.Hour),
+		Location:   time.UTC,
+	})
+	router := newAdTestRouter(h)
+	seedAds(t, ads, validAd(1, 50000))
+
+	rr := serve(router, "POST", "/ads/1/transitions", map[string]string{"to": string(models.AdPublished)})
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var resp transitionResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Len(t, resp.Jobs, 1)
+	assert.Equal(t, testChannelID, resp.Jobs[0].ChannelID)
+	assert.True(t, resp.Jobs[0].NextAttemptAt.After(now.Add(30*time.Minute)), "held until the quiet hours end, got %s", resp.Jobs[0].NextAttemptAt)
+
+	processed, err := worker.ProcessNext(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, processed)
+	assert.Empty(t, tg.Calls())
+}
+
+func TestPublishSkipsWithdrawnAd(t *testing.T) {
+	tg := telegramtest.NewServer()
+	defer tg.Close()
+
+	ads := repository.NewMemoryAdRepository()
+	h, worker := newPublishingAdHandler(tg, ads)
+	router := newAdTestRouter(h)
+	seedAds(t, ads, publishableAd(1, "https://example.com/1.jpg,https://example.com/2.jpg"))
+
+	assert.Equal(t, http.StatusAccepted, serve(router, "POST", "/ads/1/post", nil).Code)
+	assert.Equal(t, http.StatusCreated, serve(router, "POST", "/ads/1/transitions", map[string]string{"to": "deleted"}).Code)
+
+	for {
+		processed, _ := worker.ProcessNext(context.Background())
+		if !processed {
+			break
+		}
+	}
+	assert.Empty(t, tg.Calls())
+
+	jobs, _ := h.jobs.ListByAd(context.Background(), 1)
+	if assert.Len(t, jobs, 2) {
+		assert.Equal(t, models.JobFailed, jobs[0].Status)
+		assert.Equal(t, "ad is deleted", jobs[0].LastError)
+		assert.Equal(t, models.JobSucceeded, jobs[1].Status)
+	}
+}
+
+func TestPublishRequiresPublishableAd(t *testing.T) {
+	tg := telegramtest.NewServer()
+	defer tg.Close()
+
+	ads := repository.NewMemoryAdRepository()
+	h, _ := newPublishingAdHandler(tg, ads)
+	router := newAdTestRouter(h)
+	draft := validAd(1, 60000)
+	draft.Photos = nil
+	seedAds(t, ads, draft)
+
+	for _, rr := range []*httptest.ResponseRecorder{
+		serve(router, "POST", "/ads/1/post", nil),
+		serve(router, "POST", "/ads/1/transitions", map[string]string{"to": "published"}),
+	} {
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+		problem := decodeProblem(t, rr)
+		assert.Equal(t, response.CodeNotPublishable, problem.Code)
+		if assert.Len(t, problem.Errors, 1) {
+			assert.Equal(t, "photos", problem.Errors[0].Field)
+		}
+	}
+
+	stored, _ := ads.Get(context.Background(), 1)
+	assert.Equal(t, models.AdDraft, stored.State)
+	jobs, _ := h.jobs.ListByAd(context.Background(), 1)
+	assert.Empty(t, jobs)
+}
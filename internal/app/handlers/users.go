@@ -1,177 +1,254 @@
 package handlers
 
 import (
-	"database/sql"
-	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/publisher"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/response"
+	"github.com/1karp/ads_api/internal/app/validation"
 	"github.com/gorilla/mux"
 )
 
-type User struct {
-	UserID   int    `json:"userid"`
-	Ads      string `json:"ads"`
-	Username string `json:"username"`
+type UserHandler struct {
+	users       repository.UserRepository
+	ads         repository.AdRepository
+	transitions repository.TransitionRepository
+	publisher   *publisher.Publisher
 }
 
-func CreateUser(w http.ResponseWriter, r *http.Request) {
-	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		log.Printf("Error decoding request body: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+func NewUserHandler(users repository.UserRepository, ads repository.AdRepository, transitions repository.TransitionRepository, pub *publisher.Publisher) *UserHandler {
+	return &UserHandler{users: users, ads: ads, transitions: transitions, publisher: pub}
+}
+
+// userID parses the {userid} route variable, writing a 400 when it is not
+// an integer.
+func userID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["userid"])
+	if err != nil {
+		writeFieldError(w, r, &fieldError{Field: "userid", Message: "must be an integer"})
+		return 0, false
+	}
+	return id, true
+}
+
+func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var user models.User
+	if !decodeJSON(w, r, &user) {
+		return
+	}
+	if errs := validation.User.Check(user); len(errs) > 0 {
+		response.Invalid(w, r, errs)
 		return
 	}
 
-	var existingUser User
-	err := db.QueryRow("SELECT userid FROM users WHERE username = $1", user.Username).Scan(&existingUser.UserID)
+	existingUser, err := h.users.GetByUsername(r.Context(), user.Username)
 	if err == nil {
 		log.Printf("User already exists: %v", existingUser)
-		w.WriteHeader(http.StatusOK)
+		response.JSON(w, http.StatusOK, existingUser)
 		return
-	} else if err != sql.ErrNoRows {
-		log.Printf("Error checking for existing user: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		response.Internal(w, r, "Error checking for existing user", err)
 		return
 	}
 
-	err = db.QueryRow("INSERT INTO users (username, userid) VALUES ($1, $2) RETURNING userid", user.Username, user.UserID).Scan(&user.UserID)
-	if err != nil {
-		log.Printf("Error inserting user into database: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.users.Create(r.Context(), &user); err != nil {
+		response.Internal(w, r, "Error inserting user into database", err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(user); err != nil {
-		log.Printf("Error encoding response: %v", err)
-	}
+	response.JSON(w, http.StatusCreated, user)
 	log.Printf("User created: %v", user)
 }
 
-func GetUsers(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query("SELECT userid, ads, username FROM users")
+func (h *UserHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePageRequest(r.URL.Query(), repository.ValidUserSort, repository.DefaultUserSort)
 	if err != nil {
-		log.Printf("Error querying users: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("Invalid pagination parameters: %v", err)
+		writeParamError(w, r, err)
 		return
 	}
-	defer rows.Close()
 
-	users := []User{}
-	for rows.Next() {
-		var user User
-		if err := rows.Scan(&user.UserID, &user.Ads, &user.Username); err != nil {
-			log.Printf("Error scanning user: %v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		users = append(users, user)
+	users, err := h.users.List(r.Context(), p.options())
+	if err != nil {
+		response.Internal(w, r, "Error querying users", err)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(users); err != nil {
-		log.Printf("Error encoding response: %v", err)
+	var body interface{} = users
+	if users == nil {
+		body = []models.User{}
 	}
-	log.Printf("Users retrieved: %v", users)
+	if p.Paged {
+		body = paginate(p, users, repository.UserKeyset)
+	}
+
+	response.JSON(w, http.StatusOK, body)
+	log.Printf("Users retrieved: %d", len(users))
 }
 
-func GetUserByID(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID := vars["userid"]
+func (h *UserHandler) GetUserByID(w http.ResponseWriter, r *http.Request) {
+	id, ok := userID(w, r)
+	if !ok {
+		return
+	}
 
-	var user User
-	err := db.QueryRow("SELECT userid, ads, username FROM users WHERE userid = $1", userID).Scan(
-		&user.UserID, &user.Ads, &user.Username,
-	)
+	user, err := h.users.Get(r.Context(), id)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "User not found", http.StatusNotFound)
+		if errors.Is(err, repository.ErrNotFound) {
+			response.Error(w, r, http.StatusNotFound, response.CodeUserNotFound, "User not found")
 		} else {
-			log.Printf("Error querying user by ID: %v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			response.Internal(w, r, "Error querying user by ID", err)
 		}
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(user); err != nil {
-		log.Printf("Error encoding response: %v", err)
+	if notModified(w, r, user.Version) {
+		return
 	}
+	response.JSON(w, http.StatusOK, user)
 	log.Printf("User retrieved: %v", user)
 }
 
-func UpdateUser(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID := vars["userid"]
+func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := userID(w, r)
+	if !ok {
+		return
+	}
 
-	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		log.Printf("Error decoding request body: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	existing, err := h.users.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			response.Error(w, r, http.StatusNotFound, response.CodeUserNotFound, "User not found")
+		} else {
+			response.Internal(w, r, "Error querying user by ID", err)
+		}
+		return
+	}
+	version, ok := ifMatch(w, r, existing.Version)
+	if !ok {
 		return
 	}
 
-	var existingUser User
-	err := db.QueryRow("SELECT userid FROM users WHERE userid = $1", userID).Scan(&existingUser.UserID)
-	if err == sql.ErrNoRows {
-		http.Error(w, "User not found", http.StatusNotFound)
+	var user models.User
+	if !decodeJSON(w, r, &user) {
 		return
-	} else if err != nil {
-		log.Printf("Error checking existing user: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	user.UserID = id
+	user.Version = version
+	if errs := validation.User.Check(user); len(errs) > 0 {
+		response.Invalid(w, r, errs)
 		return
 	}
 
-	_, err = db.Exec("UPDATE users SET ads = $1, username = $2 WHERE userid = $3", user.Ads, user.Username, userID)
-	if err != nil {
-		log.Printf("Error updating user: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.users.Update(r.Context(), &user); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			response.Error(w, r, http.StatusNotFound, response.CodeUserNotFound, "User not found")
+		case errors.Is(err, repository.ErrStale):
+			writeStale(w, r)
+		default:
+			response.Internal(w, r, "Error updating user", err)
+		}
 		return
 	}
 
-	err = db.QueryRow("SELECT userid, ads, username FROM users WHERE userid = $1", userID).Scan(&user.UserID, &user.Ads, &user.Username)
+	user, err = h.users.Get(r.Context(), id)
 	if err != nil {
-		log.Printf("Error fetching updated user: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		response.Internal(w, r, "Error fetching updated user", err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(user); err != nil {
-		log.Printf("Error encoding response: %v", err)
-	}
+	w.Header().Set("ETag", etag(user.Version))
+	response.JSON(w, http.StatusOK, user)
 	log.Printf("User updated: %v", user)
 }
 
-func GetAdsByUserID(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID := vars["userid"]
-	rows, err := db.Query("SELECT ads FROM users WHERE userid = $1", userID)
+func (h *UserHandler) GetAdsByUserID(w http.ResponseWriter, r *http.Request) {
+	id, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	filter, err := parseAdFilter(r.URL.Query())
+	if err != nil {
+		log.Printf("Invalid ad filter: %v", err)
+		writeParamError(w, r, err)
+		return
+	}
+	filter.UserID = &id
+
+	listAds(w, r, h.ads, h.users, filter)
+}
+
+// DeleteUser soft-deletes the user together with their ads, or purges
+// both with ?hard=true. Hard deletes must be guarded by
+// RequireAdminForHardDelete.
+func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := h.users.Get(r.Context(), id)
 	if err != nil {
-		log.Printf("Error querying ads by user ID: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if errors.Is(err, repository.ErrNotFound) {
+			response.Error(w, r, http.StatusNotFound, response.CodeUserNotFound, "User not found")
+		} else {
+			response.Internal(w, r, "Error querying user by ID", err)
+		}
+		return
+	}
+	version, ok := ifMatch(w, r, user.Version)
+	if !ok {
 		return
 	}
-	defer rows.Close()
-	ads := []string{}
-	for rows.Next() {
-		var ad string
-		if err := rows.Scan(&ad); err != nil {
-			log.Printf("Error scanning ad: %v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+	// The user is deleted first, so a stale If-Match is refused before
+	// any of their ads are touched; the ads then go with them whatever
+	// their version. A hard delete removes the row only once the ads
+	// referencing it are gone.
+	err = h.users.SoftDelete(r.Context(), id, version)
+	if errors.Is(err, repository.ErrStale) {
+		writeStale(w, r)
+		return
+	}
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		response.Internal(w, r, "Error deleting user", err)
+		return
+	}
+
+	hard, _ := hardDelete(r)
+	ads, err := h.ads.List(r.Context(), repository.AdFilter{UserID: &id, IncludeDeleted: hard}, repository.ListOptions{Sort: repository.DefaultAdSort})
+	if err != nil {
+		response.Internal(w, r, "Error querying user ads", err)
+		return
+	}
+
+	remover := adRemover{ads: h.ads, transitions: h.transitions, publisher: h.publisher}
+	for _, ad := range ads {
+		if hard {
+			err = remover.purge(r.Context(), ad, 0)
+		} else {
+			err = remover.softDelete(r.Context(), ad, 0)
+		}
+		if err != nil {
+			writeDeleteError(w, r, "Error deleting user ads", err, "ad_id", ad.ID, "user_id", id)
 			return
 		}
-		ads = append(ads, ad)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(ads); err != nil {
-		log.Printf("Error encoding response: %v", err)
+
+	if hard {
+		if err := h.users.Delete(r.Context(), id, 0); err != nil && !errors.Is(err, repository.ErrNotFound) {
+			response.Internal(w, r, "Error deleting user", err)
+			return
+		}
 	}
-	log.Printf("Ads retrieved: %v", ads)
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("User %d deleted with %d ads (hard: %v)", id, len(ads), hard)
 }
@@ -1,94 +1,283 @@
 package handlers
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
-	"net/http/httptest"
 	"testing"
 
-	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/telegram/telegramtest"
 	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
 )
 
-func TestCreateUser(t *testing.T) {
-	mockDB, mock, _ := sqlmock.New()
-	db = mockDB
-	defer mockDB.Close()
+func newUserTestRouter(h *UserHandler) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/users", h.CreateUser).Methods("POST")
+	router.HandleFunc("/users", h.GetUsers).Methods("GET")
+	router.HandleFunc("/users/{userid}", h.GetUserByID).Methods("GET")
+	router.HandleFunc("/users/{userid}", h.UpdateUser).Methods("PUT")
+	router.HandleFunc("/users/{userid}", RequireAdminForHardDelete(testAdminToken, h.DeleteUser)).Methods("DELETE")
+	router.HandleFunc("/users/{userid}/ads", h.GetAdsByUserID).Methods("GET")
+	return router
+}
+
+// racingUserRepository reports every conditional delete as stale, as if
+// the user changed between the handler reading and deleting them.
+type racingUserRepository struct {
+	repository.UserRepository
+}
 
-	mock.ExpectExec("INSERT INTO users").WillReturnResult(sqlmock.NewResult(1, 1))
+func (r racingUserRepository) SoftDelete(context.Context, int, int) error { return repository.ErrStale }
+func (r racingUserRepository) Delete(context.Context, int, int) error     { return repository.ErrStale }
 
-	user := User{UserID: 1, Username: "testuser"}
-	body, _ := json.Marshal(user)
-	req, _ := http.NewRequest("POST", "/users", bytes.NewBuffer(body))
-	rr := httptest.NewRecorder()
+func seedUsers(t *testing.T, repo repository.UserRepository, users ...models.User) {
+	t.Helper()
+	for i := range users {
+		if err := repo.Create(context.Background(), &users[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	users := repository.NewMemoryUserRepository()
+	router := newUserTestRouter(NewUserHandler(users, repository.NewMemoryAdRepository(), nil, nil))
 
-	CreateUser(rr, req)
+	rr := serve(router, "POST", "/users", models.User{UserID: 1, Username: "testuser"})
 
 	if status := rr.Code; status != http.StatusCreated {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
 	}
-}
 
-func TestGetUsers(t *testing.T) {
-	mockDB, mock, _ := sqlmock.New()
-	db = mockDB
-	defer mockDB.Close()
+	t.Run("Existing Username", func(t *testing.T) {
+		rr := serve(router, "POST", "/users", models.User{UserID: 2, Username: "testuser"})
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var existing models.User
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &existing))
+		assert.Equal(t, 1, existing.UserID)
+		assert.Equal(t, "testuser", existing.Username)
+	})
+
+	t.Run("Invalid User", func(t *testing.T) {
+		rr := serve(router, "POST", "/users", `{"userid":0,"username":"a b"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.JSONEq(t, `{
+			"type": "/problems/validation_failed",
+			"title": "Bad Request",
+			"status": 400,
+			"code": "validation_failed",
+			"detail": "The request has invalid fields.",
+			"errors": [
+				{"field": "userid", "message": "must be greater than 0"},
+				{"field": "username", "message": "must be a Telegram username of 5 to 32 letters, digits or underscores"}
+			]
+		}`, rr.Body.String())
 
-	rows := sqlmock.NewRows([]string{"userid", "ads", "username"}).
-		AddRow(1, "test ads", "testuser")
-	mock.ExpectQuery("SELECT (.+) FROM users").WillReturnRows(rows)
+		rr = serve(router, "POST", "/users", `{"userid":3,"username":"newuser","admin":true}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
 
-	req, _ := http.NewRequest("GET", "/users", nil)
-	rr := httptest.NewRecorder()
+func TestGetUsers(t *testing.T) {
+	users := repository.NewMemoryUserRepository()
+	router := newUserTestRouter(NewUserHandler(users, repository.NewMemoryAdRepository(), nil, nil))
+	seedUsers(t, users, models.User{UserID: 1, Username: "testuser"})
 
-	GetUsers(rr, req)
+	rr := serve(router, "GET", "/users", nil)
 
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 }
 
-func TestGetUserByID(t *testing.T) {
-	mockDB, mock, _ := sqlmock.New()
-	db = mockDB
-	defer mockDB.Close()
+func TestGetUsersPaginated(t *testing.T) {
+	users := repository.NewMemoryUserRepository()
+	router := newUserTestRouter(NewUserHandler(users, repository.NewMemoryAdRepository(), nil, nil))
+	seedUsers(t, users,
+		models.User{UserID: 5, Username: "user5"},
+		models.User{UserID: 6, Username: "user6"},
+		models.User{UserID: 7, Username: "user7"},
+	)
 
-	rows := sqlmock.NewRows([]string{"userid", "ads", "username"}).
-		AddRow(1, "test ads", "testuser")
-	mock.ExpectQuery("SELECT (.+) FROM users WHERE userid = ?").WithArgs("1").WillReturnRows(rows)
+	rr := serve(router, "GET", "/users?limit=1&cursor="+cursor{Sort: "userid", ID: 5}.encode(), nil)
 
-	req, _ := http.NewRequest("GET", "/users/1", nil)
-	rr := httptest.NewRecorder()
-	vars := map[string]string{"userid": "1"}
-	req = mux.SetURLVars(req, vars)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var body page[models.User]
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Could not unmarshal response: %v", err)
+	}
+	if len(body.Items) != 1 || body.Items[0].UserID != 6 || !body.HasMore {
+		t.Errorf("unexpected page: %+v", body)
+	}
+}
+
+func TestGetUserByID(t *testing.T) {
+	users := repository.NewMemoryUserRepository()
+	router := newUserTestRouter(NewUserHandler(users, repository.NewMemoryAdRepository(), nil, nil))
+	seedUsers(t, users, models.User{UserID: 1, Username: "testuser"})
 
-	GetUserByID(rr, req)
+	rr := serve(router, "GET", "/users/1", nil)
 
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
+
+	rr = serve(router, "GET", "/users/2", nil)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
 }
 
 func TestUpdateUser(t *testing.T) {
-	mockDB, mock, _ := sqlmock.New()
-	db = mockDB
-	defer mockDB.Close()
+	users := repository.NewMemoryUserRepository()
+	router := newUserTestRouter(NewUserHandler(users, repository.NewMemoryAdRepository(), nil, nil))
+	seedUsers(t, users, models.User{UserID: 1, Username: "testuser"})
 
-	mock.ExpectQuery("SELECT userid FROM users WHERE userid = ?").WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"userid"}).AddRow(1))
-	mock.ExpectPrepare("UPDATE users").ExpectExec().WithArgs("updated ads", "updateduser", "1").WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectQuery("SELECT (.+) FROM users WHERE userid = ?").WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"userid", "ads", "username"}).AddRow(1, "updated ads", "updateduser"))
+	rr := serve(router, "PUT", "/users/1", models.User{Username: "updateduser"})
 
-	user := User{Ads: "updated ads", Username: "updateduser"}
-	body, _ := json.Marshal(user)
-	req, _ := http.NewRequest("PUT", "/users/1", bytes.NewBuffer(body))
-	rr := httptest.NewRecorder()
-	vars := map[string]string{"userid": "1"}
-	req = mux.SetURLVars(req, vars)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
 
-	UpdateUser(rr, req)
+	var user models.User
+	if err := json.Unmarshal(rr.Body.Bytes(), &user); err != nil {
+		t.Fatalf("Could not unmarshal response: %v", err)
+	}
+	if user.UserID != 1 || user.Username != "updateduser" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+}
+
+func TestGetAdsByUserID(t *testing.T) {
+	users := repository.NewMemoryUserRepository()
+	ads := repository.NewMemoryAdRepository()
+	router := newUserTestRouter(NewUserHandler(users, ads, nil, nil))
+	seedUsers(t, users, models.User{UserID: 1, Username: "testuser"}, models.User{UserID: 2, Username: "other"})
+	seedAds(t, ads,
+		models.Ad{UserID: 1, Photos: models.LegacyPhotos("a.jpg"), Price: 90000},
+		models.Ad{UserID: 2, Photos: models.LegacyPhotos("b.jpg"), Price: 50000},
+	)
+
+	rr := serve(router, "GET", "/users/1/ads", nil)
 
 	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var result []models.Ad
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Could not unmarshal response: %v", err)
+	}
+	if len(result) != 1 || result[0].ID != 1 || result[0].Price != 90000 {
+		t.Errorf("unexpected ads: %+v", result)
+	}
+
+	t.Run("Unknown User", func(t *testing.T) {
+		rr := serve(router, "GET", "/users/3/ads", nil)
+
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+		}
+	})
+}
+
+func TestDeleteUser(t *testing.T) {
+	tg := telegramtest.NewServer()
+	defer tg.Close()
+
+	users := repository.NewMemoryUserRepository()
+	ads := repository.NewMemoryAdRepository()
+	adHandler, worker := newPublishingAdHandler(tg, ads)
+	router := newUserTestRouter(NewUserHandler(users, ads, adHandler.transitions, adHandler.publisher))
+	adRouter := newAdTestRouter(adHandler)
+
+	seedUsers(t, users, models.User{UserID: 1, Username: "owner"}, models.User{UserID: 2, Username: "other"})
+	seedAds(t, ads,
+		publishableAd(1, "https://example.com/1.jpg,https://example.com/2.jpg"),
+		publishableAd(1, "https://example.com/3.jpg,https://example.com/4.jpg"),
+		publishableAd(2, "https://example.com/5.jpg,https://example.com/6.jpg"),
+	)
+	assert.Equal(t, http.StatusAccepted, serve(adRouter, "POST", "/ads/1/post", nil).Code)
+	worker.ProcessNext(context.Background())
+
+	rr := serve(router, "DELETE", "/users/1", nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	for {
+		processed, err := worker.ProcessNext(context.Background())
+		assert.NoError(t, err)
+		if !processed {
+			break
+		}
 	}
+
+	assert.Empty(t, tg.Messages(testChannelID))
+	for _, id := range []int{1, 2} {
+		ad, err := ads.Get(context.Background(), id)
+		assert.NoError(t, err)
+		assert.Equal(t, models.AdDeleted, ad.State)
+	}
+	other, _ := ads.Get(context.Background(), 3)
+	assert.Equal(t, models.AdDraft, other.State)
+
+	assert.Equal(t, http.StatusNotFound, serve(router, "GET", "/users/1", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(router, "GET", "/users/1/ads", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(router, "DELETE", "/users/1", nil).Code)
+
+	var listed []models.User
+	json.Unmarshal(serve(router, "GET", "/users", nil).Body.Bytes(), &listed)
+	assert.Equal(t, []models.User{{UserID: 2, Username: "other", Version: 1}}, listed)
+
+	t.Run("Hard Delete", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve(router, "DELETE", "/users/2?hard=true", nil).Code)
+
+		rr := serveWithHeader(router, "DELETE", "/users/2?hard=true", nil, http.Header{AdminTokenHeader: {testAdminToken}})
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+
+		_, err := ads.Get(context.Background(), 3)
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+		assert.Equal(t, http.StatusNotFound, serve(router, "GET", "/users/2", nil).Code)
+	})
+
+	t.Run("Stale User Keeps Ads", func(t *testing.T) {
+		seedUsers(t, users, models.User{UserID: 3, Username: "racer"})
+		ad := seedAds(t, ads, publishableAd(3, "https://example.com/7.jpg,https://example.com/8.jpg"))[0]
+		racing := newUserTestRouter(NewUserHandler(racingUserRepository{users}, ads, adHandler.transitions, adHandler.publisher))
+
+		rr := serveWithHeader(racing, "DELETE", "/users/3", nil, http.Header{"If-Match": {`"1"`}})
+		assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+		stored, err := ads.Get(context.Background(), ad.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.AdDraft, stored.State)
+	})
+}
+
+func TestUserConditionalRequests(t *testing.T) {
+	users := repository.NewMemoryUserRepository()
+	router := newUserTestRouter(NewUserHandler(users, repository.NewMemoryAdRepository(), nil, nil))
+	assert.NoError(t, users.Create(context.Background(), &models.User{UserID: 1, Username: "testuser"}))
+
+	rr := serve(router, "GET", "/users/1", nil)
+	assert.Equal(t, `"1"`, rr.Header().Get("ETag"))
+	assert.Equal(t, http.StatusNotModified, serveWithHeader(router, "GET", "/users/1", nil, http.Header{"If-None-Match": {`"1"`}}).Code)
+
+	rr = serveWithHeader(router, "PUT", "/users/1", models.User{Username: "renamed"}, http.Header{"If-Match": {`"1"`}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+
+	rr = serveWithHeader(router, "PUT", "/users/1", models.User{Username: "clobbered"}, http.Header{"If-Match": {`"1"`}})
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	assert.Equal(t, http.StatusPreconditionFailed, serveWithHeader(router, "DELETE", "/users/1", nil, http.Header{"If-Match": {`"1"`}}).Code)
+
+	user, _ := users.Get(context.Background(), 1)
+	assert.Equal(t, "renamed", user.Username)
 }
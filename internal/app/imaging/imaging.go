@@ -0,0 +1,181 @@
+// Package imaging normalises uploaded photos. Photos are turned upright
+// according to their EXIF orientation and re-encoded as JPEG, which drops
+// all metadata, including the GPS position phones record. Resized
+// variants are produced for the channel and for listings.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+const (
+	// LargeSize bounds the longest side of the large variant; Telegram
+	// does not show photos bigger than this.
+	LargeSize = 2560
+	// ThumbnailSize bounds the longest side of thumbnails.
+	ThumbnailSize = 320
+	// MaxDimensions is Telegram's limit for the sum of a photo's width and
+	// height; the normalised original is scaled down to fit it.
+	MaxDimensions = 10000
+	// MaxPixels refuses images that would take too much memory to decode.
+	MaxPixels = 50_000_000
+
+	Quality = 85
+)
+
+var ErrTooLarge = errors.New("image too large")
+
+// Variants are the JPEG encodings produced for a photo. Width and Height
+// are the dimensions of Original.
+type Variants struct {
+	Original  []byte
+	Large     []byte
+	Thumbnail []byte
+	Width     int
+	Height    int
+}
+
+// Process decodes a JPEG or PNG photo and produces its variants.
+func Process(r io.Reader) (Variants, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Variants{}, err
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Variants{}, fmt.Errorf("error decoding image: %w", err)
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return Variants{}, fmt.Errorf("%w: %dx%d", ErrTooLarge, cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Variants{}, fmt.Errorf("error decoding image: %w", err)
+	}
+
+	original := orient(toRGBA(img), Orientation(data))
+	if w, h := size(original); w+h > MaxDimensions {
+		original = fit(original, max(w, h)*MaxDimensions/(w+h))
+	}
+
+	var v Variants
+	v.Width, v.Height = size(original)
+	if v.Original, err = encode(original); err != nil {
+		return Variants{}, err
+	}
+	if w, h := size(original); max(w, h) <= LargeSize {
+		v.Large = v.Original
+	} else if v.Large, err = encode(fit(original, LargeSize)); err != nil {
+		return Variants{}, err
+	}
+	if v.Thumbnail, err = encode(fit(original, ThumbnailSize)); err != nil {
+		return Variants{}, err
+	}
+	return v, nil
+}
+
+func encode(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: Quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func size(img *image.RGBA) (int, int) {
+	return img.Rect.Dx(), img.Rect.Dy()
+}
+
+// toRGBA copies img onto a white canvas, since JPEG has no transparency.
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Over)
+	return dst
+}
+
+// orient applies an EXIF orientation (1-8), returning the upright image.
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	w, h := size(src)
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90° counter-clockwise, turn clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° clockwise, turn counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
+
+// fit scales src down so its longest side is at most maxSide, averaging
+// the source pixels that make up each destination pixel. Smaller images
+// are returned as they are.
+func fit(src *image.RGBA, maxSide int) *image.RGBA {
+	w, h := size(src)
+	if max(w, h) <= maxSide {
+		return src
+	}
+	dw, dh := maxSide, max(1, h*maxSide/w)
+	if h > w {
+		dw, dh = max(1, w*maxSide/h), maxSide
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for dy := 0; dy < dh; dy++ {
+		y0, y1 := dy*h/dh, max((dy+1)*h/dh, dy*h/dh+1)
+		for dx := 0; dx < dw; dx++ {
+			x0, x1 := dx*w/dw, max((dx+1)*w/dw, dx*w/dw+1)
+
+			var sum [4]int
+			for y := y0; y < y1; y++ {
+				row := src.Pix[src.PixOffset(x0, y):src.PixOffset(x1, y)]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			p := dst.Pix[dst.PixOffset(dx, dy):]
+			for c := 0; c < 4; c++ {
+				p[c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
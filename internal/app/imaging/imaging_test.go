@@ -0,0 +1,121 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// halves returns a w×h image whose left half is red and right half blue.
+func halves(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// withExif inserts an EXIF segment holding orientation and a GPS note
+// after the SOI marker of a JPEG.
+func withExif(t *testing.T, jpg []byte, orientation uint16) []byte {
+	t.Helper()
+	var tiff bytes.Buffer
+	tiff.WriteString("MM")
+	binary.Write(&tiff, binary.BigEndian, uint16(42))
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	binary.Write(&tiff, binary.BigEndian, uint16(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{orientationTag, 3})
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{orientation, 0})
+	binary.Write(&tiff, binary.BigEndian, uint32(0))
+	tiff.WriteString("GPS 25.0800N 55.1400E")
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	var out bytes.Buffer
+	out.Write(jpg[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(segment)+2))
+	out.Write(segment)
+	out.Write(jpg[2:])
+	return out.Bytes()
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, nil))
+	return buf.Bytes()
+}
+
+func decode(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	return img
+}
+
+func TestOrientation(t *testing.T) {
+	jpg := encodeJPEG(t, halves(4, 2))
+	assert.Equal(t, 1, Orientation(jpg))
+	assert.Equal(t, 6, Orientation(withExif(t, jpg, 6)))
+	assert.Equal(t, 1, Orientation(withExif(t, jpg, 42)))
+	assert.Equal(t, 1, Orientation([]byte("\x89PNG")))
+	assert.Equal(t, 1, Orientation(withExif(t, jpg, 6)[:20]))
+}
+
+func TestProcess(t *testing.T) {
+	t.Run("Orients And Strips Metadata", func(t *testing.T) {
+		data := withExif(t, encodeJPEG(t, halves(40, 20)), 6)
+
+		v, err := Process(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.NotContains(t, string(v.Original), "Exif")
+		assert.NotContains(t, string(v.Original), "GPS")
+
+		img := decode(t, v.Original)
+		assert.Equal(t, image.Rect(0, 0, 20, 40), img.Bounds())
+		assert.Equal(t, 20, v.Width)
+		assert.Equal(t, 40, v.Height)
+		// Turned clockwise, the red left half ends up on top.
+		r, _, b, _ := img.At(10, 5).RGBA()
+		assert.Greater(t, r, b)
+		r, _, b, _ = img.At(10, 35).RGBA()
+		assert.Greater(t, b, r)
+	})
+
+	t.Run("Resizes", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, halves(3000, 1000)))
+
+		v, err := Process(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 3000, 1000), decode(t, v.Original).Bounds())
+		assert.Equal(t, image.Rect(0, 0, 2560, 853), decode(t, v.Large).Bounds())
+		assert.Equal(t, image.Rect(0, 0, ThumbnailSize, 106), decode(t, v.Thumbnail).Bounds())
+	})
+
+	t.Run("Small Photo", func(t *testing.T) {
+		v, err := Process(bytes.NewReader(encodeJPEG(t, halves(100, 50))))
+		require.NoError(t, err)
+		assert.Equal(t, v.Original, v.Large)
+		assert.Equal(t, image.Rect(0, 0, 100, 50), decode(t, v.Thumbnail).Bounds())
+	})
+
+	t.Run("Not An Image", func(t *testing.T) {
+		_, err := Process(bytes.NewReader([]byte("plain text")))
+		assert.Error(t, err)
+	})
+}
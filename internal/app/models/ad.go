@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+type Ad struct {
+	ID            int     `json:"id"`
+	UserID        int     `json:"user_id"`
+	Username      string  `json:"username"`
+	Photos        Photos  `json:"photos"`
+	Rooms         string  `json:"rooms"`
+	Price         int     `json:"price"`
+	Type          string  `json:"type"`
+	Area          int     `json:"area"`
+	Building      string  `json:"building"`
+	District      string  `json:"district"`
+	Text          string  `json:"text"`
+	CreatedAt     string  `json:"created_at"`
+	IsPosted      int     `json:"is_posted"`
+	ChatMessageId int     `json:"chat_message_id"`
+	State         AdState `json:"state"`
+	// Version is incremented on every write and serves as the ETag.
+	Version int `json:"version"`
+	// ListedAt is when the ad's post first went out after it was
+	// published, or when it was last bumped. The listing expires a TTL
+	// after it.
+	ListedAt *time.Time `json:"listed_at,omitempty"`
+
+	// Messages are the posted albums, ordered by channel and position.
+	// ChatMessageId is kept as the id of the first one.
+	Messages []TelegramMessage `json:"messages,omitempty"`
+}
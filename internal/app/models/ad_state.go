@@ -0,0 +1,66 @@
+package models
+
+import "time"
+
+// AdState is the lifecycle state of an ad. Ads move between states only
+// along the edges in adTransitions.
+type AdState string
+
+const (
+	AdDraft         AdState = "draft"
+	AdPendingReview AdState = "pending_review"
+	AdPublished     AdState = "published"
+	AdRented        AdState = "rented"
+	AdArchived      AdState = "archived"
+	AdExpired       AdState = "expired"
+	AdDeleted       AdState = "deleted"
+)
+
+var adTransitions = map[AdState][]AdState{
+	AdDraft:         {AdPendingReview, AdPublished, AdArchived, AdDeleted},
+	AdPendingReview: {AdDraft, AdPublished, AdArchived, AdDeleted},
+	AdPublished:     {AdRented, AdArchived, AdExpired, AdDeleted},
+	AdRented:        {AdPublished, AdArchived, AdDeleted},
+	AdArchived:      {AdDraft, AdDeleted},
+	AdExpired:       {AdPublished, AdArchived, AdDeleted},
+	AdDeleted:       {},
+}
+
+func (s AdState) Valid() bool {
+	_, ok := adTransitions[s]
+	return ok
+}
+
+// CanTransitionTo reports whether an ad in state s may move to state to.
+func (s AdState) CanTransitionTo(to AdState) bool {
+	for _, next := range adTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Publishable reports whether an ad in state s may be sent to the channel.
+// Rented ads are not: they stay up marked as rented where they were
+// posted, but are not posted anywhere new.
+func (s AdState) Publishable() bool {
+	switch s {
+	case AdDraft, AdPendingReview, AdPublished:
+		return true
+	}
+	return false
+}
+
+// AdTransition is one entry of an ad's state history.
+type AdTransition struct {
+	ID        int       `json:"id"`
+	AdID      int       `json:"ad_id"`
+	From      AdState   `json:"from"`
+	To        AdState   `json:"to"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// Version, when not zero, is the version of the ad the transition
+	// applies to. It is not recorded.
+	Version int `json:"-"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdStatePublishable(t *testing.T) {
+	for state, want := range map[AdState]bool{
+		AdDraft:         true,
+		AdPendingReview: true,
+		AdPublished:     true,
+		AdRented:        false,
+		AdArchived:      false,
+		AdExpired:       false,
+		AdDeleted:       false,
+		"unknown":       false,
+	} {
+		assert.Equal(t, want, state.Publishable(), state)
+	}
+}
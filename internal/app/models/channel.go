@@ -0,0 +1,41 @@
+package models
+
+import "strings"
+
+// Channel is a Telegram channel ads are published to. ID is the chat id
+// the Bot API is called with, e.g. "@dubai_rentals" or "-1001234567890".
+//
+// An ad is routed to every channel that is not Disabled and whose rules it
+// matches. A rule left empty matches every ad: Districts and Types list
+// the accepted values, compared case-insensitively, and MinPrice and
+// MaxPrice bound the price inclusively.
+type Channel struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Districts []string `json:"districts"`
+	Types     []string `json:"types"`
+	MinPrice  *int     `json:"min_price,omitempty"`
+	MaxPrice  *int     `json:"max_price,omitempty"`
+	Disabled  bool     `json:"disabled"`
+}
+
+// Matches reports whether ad is routed to the channel.
+func (c Channel) Matches(ad Ad) bool {
+	return !c.Disabled &&
+		matchesAny(c.Districts, ad.District) &&
+		matchesAny(c.Types, ad.Type) &&
+		(c.MinPrice == nil || ad.Price >= *c.MinPrice) &&
+		(c.MaxPrice == nil || ad.Price <= *c.MaxPrice)
+}
+
+func matchesAny(accepted []string, value string) bool {
+	if len(accepted) == 0 {
+		return true
+	}
+	for _, a := range accepted {
+		if strings.EqualFold(a, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelMatches(t *testing.T) {
+	minPrice, maxPrice := 50000, 100000
+	ad := Ad{District: "Dubai Marina", Type: "apartment", Price: 100000}
+
+	assert.True(t, Channel{}.Matches(ad), "empty rules match every ad")
+	assert.True(t, Channel{Districts: []string{"JLT", "dubai marina"}, Types: []string{"Apartment"}}.Matches(ad))
+	assert.True(t, Channel{MinPrice: &minPrice, MaxPrice: &maxPrice}.Matches(ad), "price bounds are inclusive")
+	assert.False(t, Channel{Districts: []string{"JLT"}}.Matches(ad))
+	assert.False(t, Channel{Types: []string{"villa"}}.Matches(ad))
+	assert.False(t, Channel{MaxPrice: &minPrice}.Matches(ad))
+	assert.False(t, Channel{Disabled: true}.Matches(ad))
+}
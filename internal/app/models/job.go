@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+type JobKind string
+
+const (
+	JobPublish     JobKind = "publish"
+	JobEditCaption JobKind = "edit_caption"
+	JobDelete      JobKind = "delete"
+	// JobSync brings a posted album up to date after the ad was edited.
+	JobSync JobKind = "sync"
+	// JobProcessPhotos normalises an ad's uploaded photos.
+	JobProcessPhotos JobKind = "process_photos"
+	// JobBump reposts a published ad at the top of a channel.
+	JobBump JobKind = "bump"
+)
+
+type JobStatus string
+
+const (
+	JobPending   JobStatus = "pending"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	// JobCancelled marks a queued publish job taken off the queue.
+	JobCancelled JobStatus = "cancelled"
+)
+
+// Job is an outbox entry: a unit of background work for an ad, such as a
+// Telegram call, that the worker performs, retrying until it succeeds or
+// gives up. ChannelID restricts a publish job to one channel; without it
+// the ad is posted to every channel it is routed to.
+//
+// Queued publish jobs run at NextAttemptAt, which the scheduler sets to
+// the channel's next free slot, never before the PublishAt asked for.
+type Job struct {
+	ID            int        `json:"id"`
+	AdID          int        `json:"ad_id"`
+	Kind          JobKind    `json:"kind"`
+	ChannelID     string     `json:"channel_id,omitempty"`
+	Status        JobStatus  `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	PublishAt     *time.Time `json:"publish_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+}
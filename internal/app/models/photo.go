@@ -0,0 +1,141 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Photo is one photo of an ad. Large and Thumbnail are the variants the
+// image pipeline produced; photos that were not processed, e.g. ones
+// hosted elsewhere or uploads still in the queue, have neither.
+type Photo struct {
+	ID        int    `json:"id,omitempty"`
+	URL       string `json:"url"`
+	Position  int    `json:"position"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Caption   string `json:"caption,omitempty"`
+	Large     string `json:"large_url,omitempty"`
+	Thumbnail string `json:"thumbnail_url,omitempty"`
+}
+
+// Photos are the photos of an ad in album order.
+type Photos []Photo
+
+// LegacyPhotos parses the comma-separated URL list photos used to be
+// given as.
+func LegacyPhotos(s string) Photos {
+	if strings.TrimSpace(s) == "" {
+		return Photos{}
+	}
+	var photos Photos
+	for _, url := range strings.Split(s, ",") {
+		photos = append(photos, Photo{URL: strings.TrimSpace(url)})
+	}
+	return photos.Normalize()
+}
+
+// UnmarshalJSON accepts a list of photo objects or of URLs. The
+// deprecated comma-separated string is still accepted as well.
+func (p *Photos) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		*p = nil
+		return nil
+	case len(data) > 0 && data[0] == '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*p = LegacyPhotos(s)
+		return nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("photos must be a list of photos: %w", err)
+	}
+	photos := make(Photos, len(items))
+	for i, item := range items {
+		if len(item) > 0 && item[0] == '"' {
+			if err := json.Unmarshal(item, &photos[i].URL); err != nil {
+				return err
+			}
+			continue
+		}
+		// Like the body around it, photos may not carry unknown fields.
+		dec := json.NewDecoder(bytes.NewReader(item))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&photos[i]); err != nil {
+			return err
+		}
+	}
+	*p = photos.Normalize()
+	return nil
+}
+
+// Normalize orders the photos by position, keeping the given order among
+// equal positions, and renumbers them from 0.
+func (p Photos) Normalize() Photos {
+	sorted := append(Photos{}, p...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Position < sorted[j].Position })
+	for i := range sorted {
+		sorted[i].Position = i
+	}
+	return sorted
+}
+
+// URLs returns the URL of each photo.
+func (p Photos) URLs() []string {
+	urls := make([]string, len(p))
+	for i, photo := range p {
+		urls[i] = photo.URL
+	}
+	return urls
+}
+
+// Merge returns the photo list an update to incoming leads to. Photos
+// are matched to the existing ones by id or, lacking one, by URL; matched
+// photos keep their id, and their variants and dimensions unless the URL
+// changed. Unmatched incoming photos have no id.
+func (p Photos) Merge(incoming Photos) Photos {
+	byID := map[int]Photo{}
+	byURL := map[string][]Photo{}
+	for _, photo := range p {
+		byID[photo.ID] = photo
+		byURL[photo.URL] = append(byURL[photo.URL], photo)
+	}
+	used := map[int]bool{}
+
+	merged := make(Photos, len(incoming))
+	for i, photo := range incoming.Normalize() {
+		existing, ok := byID[photo.ID]
+		if photo.ID == 0 || !ok || used[photo.ID] {
+			ok = false
+			for _, candidate := range byURL[photo.URL] {
+				if !used[candidate.ID] {
+					existing, ok = candidate, true
+					break
+				}
+			}
+		}
+
+		photo.ID = 0
+		if ok {
+			used[existing.ID] = true
+			photo.ID = existing.ID
+			if existing.URL == photo.URL {
+				if photo.Width == 0 && photo.Height == 0 {
+					photo.Width, photo.Height = existing.Width, existing.Height
+				}
+				photo.Large, photo.Thumbnail = existing.Large, existing.Thumbnail
+			}
+		}
+		merged[i] = photo
+	}
+	return merged
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPhotosUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want Photos
+	}{
+		{"Legacy String", `"a.jpg, b.jpg"`, Photos{{URL: "a.jpg"}, {URL: "b.jpg", Position: 1}}},
+		{"Empty Legacy String", `""`, Photos{}},
+		{"URLs", `["a.jpg", "b.jpg"]`, Photos{{URL: "a.jpg"}, {URL: "b.jpg", Position: 1}}},
+		{"Objects By Position", `[{"url":"b.jpg","position":5,"caption":"Kitchen"},{"url":"a.jpg","position":2,"width":800,"height":600}]`,
+			Photos{{URL: "a.jpg", Width: 800, Height: 600}, {URL: "b.jpg", Position: 1, Caption: "Kitchen"}}},
+		{"Null", `null`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var photos Photos
+			assert.NoError(t, json.Unmarshal([]byte(tt.json), &photos))
+			assert.Equal(t, tt.want, photos)
+		})
+	}
+
+	var photos Photos
+	assert.Error(t, json.Unmarshal([]byte(`{"url":"a.jpg"}`), &photos))
+}
+
+func TestPhotosMerge(t *testing.T) {
+	existing := Photos{
+		{ID: 1, URL: "a.jpg", Width: 640, Height: 480, Large: "a-large.jpg", Thumbnail: "a-thumb.jpg"},
+		{ID: 2, URL: "b.jpg", Position: 1},
+	}
+
+	merged := existing.Merge(Photos{
+		{URL: "c.jpg"},
+		{URL: "a.jpg", Position: 1, Caption: "Kitchen"},
+		{ID: 2, URL: "d.jpg", Position: 2, Width: 100, Height: 100},
+	})
+	assert.Equal(t, Photos{
+		{URL: "c.jpg"},
+		{ID: 1, URL: "a.jpg", Position: 1, Width: 640, Height: 480, Caption: "Kitchen", Large: "a-large.jpg", Thumbnail: "a-thumb.jpg"},
+		{ID: 2, URL: "d.jpg", Position: 2, Width: 100, Height: 100},
+	}, merged)
+
+	// A photo listed twice matches the existing one only once.
+	merged = existing.Merge(Photos{{URL: "a.jpg"}, {URL: "a.jpg", Position: 1}})
+	assert.Equal(t, 1, merged[0].ID)
+	assert.Equal(t, 0, merged[1].ID)
+}
@@ -0,0 +1,19 @@
+package models
+
+// TelegramMessage is one message of an ad's album in a channel. Position
+// is the photo's index in the album; the caption lives on position 0.
+// PhotoURL is the URL of the ad photo the message shows and Caption the
+// caption last sent, empty when unknown.
+//
+// A Reply message is not part of the album but carries the ad's full text
+// in reply to it, when the caption had to be cut short. It follows the
+// album's messages and Caption holds the text last sent.
+type TelegramMessage struct {
+	ChannelID string `json:"channel_id"`
+	MessageID int    `json:"message_id"`
+	Position  int    `json:"position"`
+	FileID    string `json:"file_id,omitempty"`
+	PhotoURL  string `json:"photo_url,omitempty"`
+	Caption   string `json:"caption,omitempty"`
+	Reply     bool   `json:"reply,omitempty"`
+}
@@ -0,0 +1,7 @@
+package models
+
+type User struct {
+	UserID   int    `json:"userid"`
+	Username string `json:"username"`
+	Version  int    `json:"version"`
+}
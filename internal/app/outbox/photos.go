@@ -0,0 +1,139 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+
+	"github.com/1karp/ads_api/internal/app/imaging"
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/repository"
+)
+
+// processPhotos normalises the ad's uploaded photos that were not
+// processed yet. The ad is checked again after each pass so photos
+// uploaded while the job was running are not left behind. Each photo is
+// processed on its own: one that cannot be is removed from the ad, and
+// the job fails naming it once the others are done.
+func (w *Worker) processPhotos(ctx context.Context, job models.Job) error {
+	if w.media == nil {
+		return permanent(fmt.Errorf("photo storage not configured"))
+	}
+	var rejected []string
+	for {
+		ad, err := w.loadAd(ctx, job)
+		if err != nil {
+			return err
+		}
+		pending := w.unprocessedPhotos(ad)
+		if len(pending) == 0 {
+			break
+		}
+		for _, upload := range pending {
+			err := w.processPhoto(ctx, ad.ID, upload.URL)
+			if err == nil {
+				continue
+			}
+			if retryable(err) {
+				return err
+			}
+			slog.Warn("Photo rejected", "ad_id", ad.ID, "url", upload.URL, "error", err)
+			if err := w.rejectPhoto(ctx, ad.ID, upload); err != nil {
+				return err
+			}
+			rejected = append(rejected, upload.URL)
+		}
+	}
+	if len(rejected) > 0 {
+		return permanent(fmt.Errorf("photos could not be processed and were removed: %s", strings.Join(rejected, ", ")))
+	}
+	return w.jobs.Complete(ctx, job.ID, w.now())
+}
+
+// unprocessedPhotos returns the ad's photos kept in our storage that have
+// no variants yet. Photos hosted elsewhere are left alone.
+func (w *Worker) unprocessedPhotos(ad models.Ad) []models.Photo {
+	var pending []models.Photo
+	for _, photo := range ad.Photos {
+		if _, ok := w.media.Key(photo.URL); ok && photo.Large == "" {
+			pending = append(pending, photo)
+		}
+	}
+	return pending
+}
+
+// rejectPhoto removes a photo that cannot be processed from the ad and
+// deletes the upload, which is never served or posted as it is.
+func (w *Worker) rejectPhoto(ctx context.Context, adID int, photo models.Photo) error {
+	err := w.ads.DeletePhoto(ctx, adID, photo.ID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+	key, _ := w.media.Key(photo.URL)
+	w.deleteObject(ctx, key)
+	return nil
+}
+
+// processPhoto stores the variants of one upload next to it, e.g.
+// ads/1/3f9a.png becomes ads/1/3f9a/original.jpg, and replaces the upload
+// on the ad. The upload itself is deleted since it may carry metadata.
+func (w *Worker) processPhoto(ctx context.Context, adID int, upload string) error {
+	key, _ := w.media.Key(upload)
+	r, _, err := w.media.Backend.Open(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error opening photo %s: %w", key, err)
+	}
+	variants, err := imaging.Process(r)
+	r.Close()
+	if err != nil {
+		return permanent(fmt.Errorf("error processing photo %s: %w", key, err))
+	}
+
+	base := strings.TrimSuffix(key, path.Ext(key))
+	keys := map[string][]byte{
+		base + "/original.jpg":  variants.Original,
+		base + "/thumbnail.jpg": variants.Thumbnail,
+	}
+	photo := models.Photo{
+		URL:       w.media.URL(base + "/original.jpg"),
+		Width:     variants.Width,
+		Height:    variants.Height,
+		Large:     w.media.URL(base + "/original.jpg"),
+		Thumbnail: w.media.URL(base + "/thumbnail.jpg"),
+	}
+	if !bytes.Equal(variants.Large, variants.Original) {
+		keys[base+"/large.jpg"] = variants.Large
+		photo.Large = w.media.URL(base + "/large.jpg")
+	}
+	for k, data := range keys {
+		if err := w.media.Backend.Put(ctx, k, bytes.NewReader(data), "image/jpeg"); err != nil {
+			return err
+		}
+	}
+
+	err = w.ads.ReplacePhoto(ctx, adID, upload, photo)
+	if errors.Is(err, repository.ErrConflict) {
+		// The photo was removed from the ad in the meantime.
+		for k := range keys {
+			w.deleteObject(ctx, k)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	w.deleteObject(ctx, key)
+
+	slog.Info("Photo processed", "ad_id", adID, "key", key)
+	return nil
+}
+
+func (w *Worker) deleteObject(ctx context.Context, key string) {
+	if err := w.media.Backend.Delete(ctx, key); err != nil {
+		slog.Error("Error deleting photo", "key", key, "error", err)
+	}
+}
@@ -0,0 +1,337 @@
+// Package outbox drains the outbox_jobs table, performing the Telegram
+// calls and photo processing that handlers enqueue.
+//
+// Delivery is at-least-once: a job is only marked done after Telegram
+// accepted the call, in the same transaction that records the result on
+// the ad. If the process dies between the two, the job's lease expires and
+// it runs again.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/publisher"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/schedule"
+	"github.com/1karp/ads_api/internal/app/storage"
+	"github.com/1karp/ads_api/internal/app/telegram"
+)
+
+type Config struct {
+	PollInterval time.Duration
+	Lease        time.Duration
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		PollInterval: 2 * time.Second,
+		Lease:        2 * time.Minute,
+		MaxAttempts:  8,
+		BaseBackoff:  5 * time.Second,
+		MaxBackoff:   time.Hour,
+	}
+}
+
+type Worker struct {
+	jobs      repository.JobRepository
+	ads       repository.AdRepository
+	publisher *publisher.Publisher
+	media     *storage.Media
+	cfg       Config
+	// now reads the clock in UTC, the zone the job and ad timestamps are
+	// stored in.
+	now func() time.Time
+
+	// Scheduler paces the publish jobs of each channel. Without it they
+	// run as soon as they are due.
+	Scheduler *schedule.Scheduler
+}
+
+func NewWorker(jobs repository.JobRepository, ads repository.AdRepository, pub *publisher.Publisher, media *storage.Media, cfg Config) *Worker {
+	return &Worker{jobs: jobs, ads: ads, publisher: pub, media: media, cfg: cfg, now: func() time.Time { return time.Now().UTC() }}
+}
+
+// Run processes due jobs until ctx is cancelled, polling when idle.
+func (w *Worker) Run(ctx context.Context) {
+	slog.Info("Outbox worker started", "poll_interval", w.cfg.PollInterval)
+	for {
+		processed, err := w.ProcessNext(ctx)
+		if err != nil {
+			slog.Error("Error processing outbox job", "error", err)
+		}
+		if processed && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Info("Outbox worker stopped")
+			return
+		case <-time.After(w.cfg.PollInterval):
+		}
+	}
+}
+
+// ProcessNext claims and runs one due job. It reports whether a job was
+// claimed.
+func (w *Worker) ProcessNext(ctx context.Context) (bool, error) {
+	job, ok, err := w.jobs.Claim(ctx, w.now(), w.cfg.Lease)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	logger := slog.With("job_id", job.ID, "ad_id", job.AdID, "kind", job.Kind, "attempt", job.Attempts)
+
+	runErr := w.run(ctx, job)
+	if runErr == nil {
+		logger.Info("Outbox job succeeded")
+		return true, nil
+	}
+
+	var held heldError
+	if errors.As(runErr, &held) {
+		logger.Info("Outbox job held back", "until", held.until)
+		return true, w.jobs.Defer(ctx, job.ID, held.until, w.now())
+	}
+
+	if !retryable(runErr) || job.Attempts >= w.cfg.MaxAttempts {
+		logger.Error("Outbox job failed", "error", runErr)
+		return true, w.jobs.Fail(ctx, job.ID, runErr.Error(), w.now())
+	}
+
+	delay := w.backoff(job.Attempts, runErr)
+	logger.Warn("Outbox job will be retried", "error", runErr, "retry_in", delay)
+	now := w.now()
+	return true, w.jobs.Retry(ctx, job.ID, now.Add(delay), runErr.Error(), now)
+}
+
+func (w *Worker) run(ctx context.Context, job models.Job) error {
+	switch job.Kind {
+	case models.JobPublish:
+		return w.publish(ctx, job)
+	case models.JobEditCaption:
+		return w.editCaption(ctx, job)
+	case models.JobDelete:
+		return w.delete(ctx, job)
+	case models.JobSync:
+		return w.sync(ctx, job)
+	case models.JobBump:
+		return w.bump(ctx, job)
+	case models.JobProcessPhotos:
+		return w.processPhotos(ctx, job)
+	default:
+		return permanent(fmt.Errorf("unknown job kind %q", job.Kind))
+	}
+}
+
+func (w *Worker) loadAd(ctx context.Context, job models.Job) (models.Ad, error) {
+	ad, err := w.ads.Get(ctx, job.AdID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ad, permanent(err)
+	}
+	return ad, err
+}
+
+// publish posts the ad to the job's channel, or to every channel whose
+// rules match it when the job names none. Each channel is recorded as soon
+// as it was posted to, so a retry only posts to the channels left.
+func (w *Worker) publish(ctx context.Context, job models.Job) error {
+	ad, err := w.loadAd(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	targets := []string{job.ChannelID}
+	if job.ChannelID == "" {
+		if targets, err = w.publisher.Targets(ctx, ad); err != nil {
+			return err
+		}
+	}
+	posted := w.publisher.PostedChannels(ad)
+	var pending []string
+	for _, channelID := range targets {
+		if !slices.Contains(posted, channelID) {
+			pending = append(pending, channelID)
+		}
+	}
+
+	// A previous attempt may have posted and recorded the ad already.
+	if len(pending) == 0 && (len(targets) > 0 || ad.IsPosted == 1) {
+		return w.jobs.Complete(ctx, job.ID, w.now())
+	}
+	// The ad may have been withdrawn while the job was queued.
+	if !ad.State.Publishable() {
+		return permanent(fmt.Errorf("ad is %s", ad.State))
+	}
+	if len(pending) == 0 {
+		return permanent(errors.New("no channel matches the ad"))
+	}
+	if job.ChannelID != "" && w.Scheduler != nil {
+		until, err := w.Scheduler.Hold(ctx, job.ChannelID, w.now())
+		if err != nil {
+			return err
+		}
+		if until.After(w.now()) {
+			return heldError{until}
+		}
+	}
+
+	for i, channelID := range pending {
+		messages, err := w.publisher.Publish(ctx, ad, channelID)
+		if err != nil {
+			return err
+		}
+		if i == len(pending)-1 {
+			return w.jobs.CompletePublish(ctx, job.ID, ad.ID, messages, w.now())
+		}
+		if err := w.ads.SetMessages(ctx, ad.ID, messages); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// editCaption re-renders the caption of a posted ad, e.g. after it was
+// marked rented. Nothing is posted for ads that are not in the channel.
+func (w *Worker) editCaption(ctx context.Context, job models.Job) error {
+	ad, err := w.loadAd(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	if ad.IsPosted != 1 {
+		return w.jobs.Complete(ctx, job.ID, w.now())
+	}
+	messages, err := w.publisher.EditCaption(ctx, ad)
+	if err != nil {
+		return err
+	}
+	return w.jobs.CompletePublish(ctx, job.ID, ad.ID, messages, w.now())
+}
+
+// sync brings the posted albums of an ad up to date after it was edited.
+// Messages already replaced are recorded even when a later step fails, so
+// the retry does not repeat them.
+func (w *Worker) sync(ctx context.Context, job models.Job) error {
+	ad, err := w.loadAd(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	if ad.IsPosted != 1 {
+		return w.jobs.Complete(ctx, job.ID, w.now())
+	}
+	result, err := w.publisher.Sync(ctx, ad)
+	if err != nil {
+		if result.Messages != nil {
+			if recordErr := w.ads.SetMessages(ctx, ad.ID, result.Messages); recordErr != nil {
+				slog.Error("Error recording Telegram messages", "ad_id", ad.ID, "error", recordErr)
+			}
+		}
+		return err
+	}
+	return w.jobs.CompletePublish(ctx, job.ID, ad.ID, result.Messages, w.now())
+}
+
+// bump reposts a published ad at the top of the job's channel and
+// restarts its listing. Nothing is posted for ads no longer in the
+// channel.
+func (w *Worker) bump(ctx context.Context, job models.Job) error {
+	ad, err := w.loadAd(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(w.publisher.PostedChannels(ad), job.ChannelID) {
+		return w.jobs.Complete(ctx, job.ID, w.now())
+	}
+	// The ad may have been rented or withdrawn while the job was queued.
+	if ad.State != models.AdPublished {
+		return permanent(fmt.Errorf("ad is %s", ad.State))
+	}
+	messages, err := w.publisher.Bump(ctx, ad, job.ChannelID)
+	if messages == nil {
+		return err
+	}
+	// The new album is out; retrying would post it a second time.
+	if err != nil {
+		slog.Error("Error deleting the album a bump replaced", "ad_id", ad.ID, "channel_id", job.ChannelID, "error", err)
+	}
+	return w.jobs.CompleteBump(ctx, job.ID, ad.ID, messages, w.now())
+}
+
+func (w *Worker) delete(ctx context.Context, job models.Job) error {
+	ad, err := w.loadAd(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	if ad.IsPosted != 1 {
+		return w.jobs.Complete(ctx, job.ID, w.now())
+	}
+	if err := w.publisher.Delete(ctx, ad); err != nil {
+		return err
+	}
+	return w.jobs.CompleteUnpublish(ctx, job.ID, ad.ID, w.now())
+}
+
+// backoff doubles the delay with every attempt, capped at MaxBackoff, and
+// never retries sooner than Telegram's retry_after.
+func (w *Worker) backoff(attempts int, err error) time.Duration {
+	delay := w.cfg.BaseBackoff
+	for i := 1; i < attempts && delay < w.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > w.cfg.MaxBackoff {
+		delay = w.cfg.MaxBackoff
+	}
+
+	var tgErr *telegram.Error
+	if errors.As(err, &tgErr) && tgErr.RetryAfter > delay {
+		delay = tgErr.RetryAfter
+	}
+	return delay
+}
+
+// heldError defers a job the channel's pacing does not allow yet.
+type heldError struct {
+	until time.Time
+}
+
+func (e heldError) Error() string { return "held until " + e.until.Format(time.RFC3339) }
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	return permanentError{err}
+}
+
+// retryable reports whether a failed job should be attempted again. Bot
+// API 4xx responses other than 429 will not succeed on retry, and neither
+// will a photo missing from storage.
+func retryable(err error) bool {
+	var perm permanentError
+	if errors.As(err, &perm) || errors.Is(err, storage.ErrNotFound) {
+		return false
+	}
+	var tgErr *telegram.Error
+	if errors.As(err, &tgErr) {
+		return tgErr.Code == http.StatusTooManyRequests || tgErr.StatusCode >= 500 || tgErr.StatusCode == 0
+	}
+	return true
+}
@@ -0,0 +1,330 @@
+package outbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/publisher"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/schedule"
+	"github.com/1karp/ads_api/internal/app/telegram/telegramtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testChannelID = "@test_channel"
+
+type testEnv struct {
+	tg     *telegramtest.Server
+	ads    *repository.MemoryAdRepository
+	jobs   *repository.MemoryJobRepository
+	worker *Worker
+	now    time.Time
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	tg := telegramtest.NewServer()
+	t.Cleanup(tg.Close)
+
+	env := &testEnv{
+		tg:  tg,
+		ads: repository.NewMemoryAdRepository(),
+		now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	env.jobs = repository.NewMemoryJobRepository(env.ads)
+	env.worker = NewWorker(env.jobs, env.ads, publisher.New(tg.Client(), testChannelID, nil, nil), nil, DefaultConfig())
+	env.worker.now = func() time.Time { return env.now }
+	return env
+}
+
+// enqueue creates an ad and a publish job due at the env's current time.
+func (env *testEnv) enqueue(t *testing.T) models.Job {
+	t.Helper()
+	ad := models.Ad{UserID: 1, Username: "landlord", Photos: models.LegacyPhotos("https://example.com/1.jpg,https://example.com/2.jpg"), Price: 50000}
+	require.NoError(t, env.ads.Create(context.Background(), &ad))
+	job := models.Job{AdID: ad.ID, Kind: models.JobPublish, NextAttemptAt: env.now}
+	require.NoError(t, env.jobs.Enqueue(context.Background(), &job, env.now))
+	return job
+}
+
+func (env *testEnv) job(t *testing.T, adID int) models.Job {
+	t.Helper()
+	jobs, err := env.jobs.ListByAd(context.Background(), adID)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	return jobs[0]
+}
+
+func TestProcessNextPublishes(t *testing.T) {
+	env := newTestEnv(t)
+	job := env.enqueue(t)
+
+	processed, err := env.worker.ProcessNext(context.Background())
+	assert.True(t, processed)
+	assert.NoError(t, err)
+
+	ad, err := env.ads.Get(context.Background(), job.AdID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, ad.IsPosted)
+	assert.Equal(t, 100, ad.ChatMessageId)
+	assert.Equal(t, models.JobSucceeded, env.job(t, job.AdID).Status)
+
+	processed, err = env.worker.ProcessNext(context.Background())
+	assert.False(t, processed)
+	assert.NoError(t, err)
+}
+
+func TestProcessNextStartsListing(t *testing.T) {
+	env := newTestEnv(t)
+	ad := models.Ad{UserID: 1, Photos: models.LegacyPhotos("https://example.com/1.jpg,https://example.com/2.jpg"), State: models.AdPublished}
+	require.NoError(t, env.ads.Create(context.Background(), &ad))
+	publish := models.Job{AdID: ad.ID, Kind: models.JobPublish, NextAttemptAt: env.now.Add(time.Hour)}
+	require.NoError(t, env.jobs.Enqueue(context.Background(), &publish, env.now))
+
+	processed, err := env.worker.ProcessNext(context.Background())
+	require.NoError(t, err)
+	assert.False(t, processed)
+	stored, err := env.ads.Get(context.Background(), ad.ID)
+	require.NoError(t, err)
+	assert.Nil(t, stored.ListedAt, "an ad is not listed before its post goes out")
+
+	env.now = env.now.Add(time.Hour)
+	_, err = env.worker.ProcessNext(context.Background())
+	require.NoError(t, err)
+	stored, err = env.ads.Get(context.Background(), ad.ID)
+	require.NoError(t, err)
+	if assert.NotNil(t, stored.ListedAt) {
+		assert.Equal(t, env.now, *stored.ListedAt)
+	}
+
+	listedAt := env.now
+	env.now = env.now.Add(time.Hour)
+	edit := models.Job{AdID: ad.ID, Kind: models.JobEditCaption, NextAttemptAt: env.now}
+	require.NoError(t, env.jobs.Enqueue(context.Background(), &edit, env.now))
+	_, err = env.worker.ProcessNext(context.Background())
+	require.NoError(t, err)
+	stored, err = env.ads.Get(context.Background(), ad.ID)
+	require.NoError(t, err)
+	assert.Equal(t, listedAt, *stored.ListedAt, "editing the captions keeps the listing")
+}
+
+func TestProcessNextHonoursRetryAfter(t *testing.T) {
+	env := newTestEnv(t)
+	job := env.enqueue(t)
+	env.tg.FailNext("sendMediaGroup", telegramtest.TooManyRequests(120))
+
+	_, err := env.worker.ProcessNext(context.Background())
+	assert.NoError(t, err)
+
+	stored := env.job(t, job.AdID)
+	assert.Equal(t, models.JobPending, stored.Status)
+	assert.Equal(t, 1, stored.Attempts)
+	assert.Equal(t, env.now.Add(120*time.Second), stored.NextAttemptAt)
+	assert.Contains(t, stored.LastError, "Too Many Requests")
+
+	// Not due yet.
+	env.now = env.now.Add(time.Minute)
+	processed, _ := env.worker.ProcessNext(context.Background())
+	assert.False(t, processed)
+	assert.Len(t, env.tg.CallsTo("sendMediaGroup"), 1)
+
+	env.now = env.now.Add(time.Minute)
+	processed, err = env.worker.ProcessNext(context.Background())
+	assert.True(t, processed)
+	assert.NoError(t, err)
+	assert.Equal(t, models.JobSucceeded, env.job(t, job.AdID).Status)
+	assert.Len(t, env.tg.CallsTo("sendMediaGroup"), 2)
+}
+
+func TestProcessNextBacksOffExponentially(t *testing.T) {
+	env := newTestEnv(t)
+	job := env.enqueue(t)
+	env.tg.FailNext("sendMediaGroup", telegramtest.ServerError(), telegramtest.ServerError(), telegramtest.ServerError())
+
+	for _, want := range []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second} {
+		processed, err := env.worker.ProcessNext(context.Background())
+		require.True(t, processed)
+		require.NoError(t, err)
+
+		stored := env.job(t, job.AdID)
+		assert.Equal(t, models.JobPending, stored.Status)
+		assert.Equal(t, env.now.Add(want), stored.NextAttemptAt)
+		env.now = stored.NextAttemptAt
+	}
+}
+
+func TestProcessNextFails(t *testing.T) {
+	t.Run("Bad Request", func(t *testing.T) {
+		env := newTestEnv(t)
+		job := env.enqueue(t)
+		env.tg.FailNext("sendMediaGroup", telegramtest.Failure{Status: 400, Description: "Bad Request: wrong file identifier"})
+
+		_, err := env.worker.ProcessNext(context.Background())
+		assert.NoError(t, err)
+
+		stored := env.job(t, job.AdID)
+		assert.Equal(t, models.JobFailed, stored.Status)
+		assert.Equal(t, "telegram sendMediaGroup failed: Bad Request: wrong file identifier (code: 400)", stored.LastError)
+	})
+
+	t.Run("Max Attempts", func(t *testing.T) {
+		env := newTestEnv(t)
+		env.worker.cfg.MaxAttempts = 2
+		job := env.enqueue(t)
+		env.tg.FailNext("sendMediaGroup", telegramtest.ServerError(), telegramtest.ServerError())
+
+		env.worker.ProcessNext(context.Background())
+		env.now = env.now.Add(time.Minute)
+		env.worker.ProcessNext(context.Background())
+
+		stored := env.job(t, job.AdID)
+		assert.Equal(t, models.JobFailed, stored.Status)
+		assert.Equal(t, 2, stored.Attempts)
+	})
+}
+
+func TestProcessNextSkipsPostedAd(t *testing.T) {
+	env := newTestEnv(t)
+	job := env.enqueue(t)
+	require.NoError(t, env.ads.SetMessages(context.Background(), job.AdID, []models.TelegramMessage{{ChannelID: testChannelID, MessageID: 42}}))
+
+	_, err := env.worker.ProcessNext(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, models.JobSucceeded, env.job(t, job.AdID).Status)
+	assert.Empty(t, env.tg.Calls())
+}
+
+func TestProcessNextRoutesToChannels(t *testing.T) {
+	env := newTestEnv(t)
+	maxPrice := 60000
+	env.worker.publisher.Channels = repository.NewMemoryChannelRepository(
+		models.Channel{ID: "@all_rentals"},
+		models.Channel{ID: "@cheap_rentals", MaxPrice: &maxPrice},
+		models.Channel{ID: "@dubai_villas", Types: []string{"villa"}},
+		models.Channel{ID: "@paused_rentals", Disabled: true},
+	)
+	job := env.enqueue(t)
+	// A previous attempt posted to the first channel before failing.
+	require.NoError(t, env.ads.SetMessages(context.Background(), job.AdID, []models.TelegramMessage{{ChannelID: "@all_rentals", MessageID: 42}}))
+
+	_, err := env.worker.ProcessNext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, models.JobSucceeded, env.job(t, job.AdID).Status)
+
+	ad, err := env.ads.Get(context.Background(), job.AdID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"@all_rentals", "@cheap_rentals"}, env.worker.publisher.PostedChannels(ad))
+	assert.Len(t, ad.Messages, 3)
+	assert.Equal(t, 42, ad.ChatMessageId)
+	if calls := env.tg.CallsTo("sendMediaGroup"); assert.Len(t, calls, 1) {
+		assert.Equal(t, "@cheap_rentals", calls[0].Params["chat_id"])
+	}
+}
+
+func TestProcessNextPublishesToJobChannel(t *testing.T) {
+	env := newTestEnv(t)
+	env.worker.publisher.Channels = repository.NewMemoryChannelRepository(models.Channel{ID: "@dubai_villas", Types: []string{"villa"}})
+	ad := models.Ad{UserID: 1, Username: "landlord", Photos: models.LegacyPhotos("https://example.com/1.jpg,https://example.com/2.jpg"), Type: "apartment"}
+	require.NoError(t, env.ads.Create(context.Background(), &ad))
+	job := models.Job{AdID: ad.ID, Kind: models.JobPublish, ChannelID: "@dubai_villas", NextAttemptAt: env.now}
+	require.NoError(t, env.jobs.Enqueue(context.Background(), &job, env.now))
+
+	_, err := env.worker.ProcessNext(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, env.tg.Messages("@dubai_villas"), 2, "the job's channel is posted to whatever its rules")
+}
+
+func TestProcessNextPacesChannel(t *testing.T) {
+	env := newTestEnv(t)
+	env.worker.Scheduler = schedule.New(env.jobs, schedule.Pacing{Interval: 10 * time.Minute})
+	enqueue := func() models.Job {
+		ad := models.Ad{UserID: 1, Photos: models.LegacyPhotos("https://example.com/1.jpg,https://example.com/2.jpg")}
+		require.NoError(t, env.ads.Create(context.Background(), &ad))
+		job := models.Job{AdID: ad.ID, Kind: models.JobPublish, ChannelID: testChannelID, NextAttemptAt: env.now}
+		require.NoError(t, env.jobs.Enqueue(context.Background(), &job, env.now))
+		return job
+	}
+	first, second := enqueue(), enqueue()
+
+	_, err := env.worker.ProcessNext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, models.JobSucceeded, env.job(t, first.AdID).Status)
+
+	_, err = env.worker.ProcessNext(context.Background())
+	require.NoError(t, err)
+	held := env.job(t, second.AdID)
+	assert.Equal(t, models.JobPending, held.Status, "the channel posted too recently")
+	assert.Equal(t, 0, held.Attempts, "holding a job back does not count as an attempt")
+	assert.Equal(t, env.now.Add(10*time.Minute), held.NextAttemptAt)
+	assert.Len(t, env.tg.CallsTo("sendMediaGroup"), 1)
+
+	env.now = env.now.Add(10 * time.Minute)
+	_, err = env.worker.ProcessNext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, models.JobSucceeded, env.job(t, second.AdID).Status)
+}
+
+func TestProcessNextBumps(t *testing.T) {
+	bump := func(t *testing.T, env *testEnv, state models.AdState) models.Job {
+		t.Helper()
+		ad := models.Ad{UserID: 1, Photos: models.LegacyPhotos("https://example.com/1.jpg,https://example.com/2.jpg"), State: state}
+		require.NoError(t, env.ads.Create(context.Background(), &ad))
+		require.NoError(t, env.ads.SetMessages(context.Background(), ad.ID, []models.TelegramMessage{{ChannelID: testChannelID, MessageID: 42}, {ChannelID: testChannelID, MessageID: 43, Position: 1}}))
+		job := models.Job{AdID: ad.ID, Kind: models.JobBump, ChannelID: testChannelID, NextAttemptAt: env.now}
+		require.NoError(t, env.jobs.Enqueue(context.Background(), &job, env.now))
+		return job
+	}
+
+	t.Run("Old Album Not Deleted", func(t *testing.T) {
+		env := newTestEnv(t)
+		job := bump(t, env, models.AdPublished)
+		env.tg.FailNext("deleteMessage", telegramtest.Failure{Status: 400, Description: "Bad Request: message can't be deleted for everyone"})
+
+		_, err := env.worker.ProcessNext(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, models.JobSucceeded, env.job(t, job.AdID).Status, "the new album is out, so the bump is not repeated")
+		ad, err := env.ads.Get(context.Background(), job.AdID)
+		require.NoError(t, err)
+		assert.Equal(t, 100, ad.ChatMessageId)
+		if assert.NotNil(t, ad.ListedAt) {
+			assert.Equal(t, env.now, *ad.ListedAt)
+		}
+	})
+
+	t.Run("Rented Meanwhile", func(t *testing.T) {
+		env := newTestEnv(t)
+		job := bump(t, env, models.AdRented)
+
+		_, err := env.worker.ProcessNext(context.Background())
+		require.NoError(t, err)
+		stored := env.job(t, job.AdID)
+		assert.Equal(t, models.JobFailed, stored.Status)
+		assert.Equal(t, "ad is rented", stored.LastError)
+		assert.Empty(t, env.tg.Calls())
+	})
+}
+
+func TestClaimReclaimsExpiredLease(t *testing.T) {
+	env := newTestEnv(t)
+	job := env.enqueue(t)
+
+	// A worker claims the job and dies before finishing it.
+	_, ok, err := env.jobs.Claim(context.Background(), env.now, env.worker.cfg.Lease)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	processed, _ := env.worker.ProcessNext(context.Background())
+	assert.False(t, processed)
+
+	env.now = env.now.Add(env.worker.cfg.Lease + time.Second)
+	processed, err = env.worker.ProcessNext(context.Background())
+	assert.True(t, processed)
+	assert.NoError(t, err)
+
+	stored := env.job(t, job.AdID)
+	assert.Equal(t, models.JobSucceeded, stored.Status)
+	assert.Equal(t, 2, stored.Attempts)
+}
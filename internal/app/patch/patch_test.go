@@ -0,0 +1,75 @@
+package patch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":{"b":"c","d":"e"}}`, `{"a":{"d":null,"f":1}}`, `{"a":{"b":"c","f":1}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+	}
+	for _, tt := range tests {
+		got, err := Merge([]byte(tt.doc), []byte(tt.patch))
+		if assert.NoError(t, err, tt.patch) {
+			assert.JSONEq(t, tt.want, string(got), tt.patch)
+		}
+	}
+
+	_, err := Merge([]byte(`{}`), []byte(`{"a":`))
+	assert.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestApply(t *testing.T) {
+	doc := `{"price":1000,"photos":[{"url":"a"},{"url":"b"}],"a/b":{"~":1}}`
+	tests := []struct {
+		patch, want string
+	}{
+		{`[{"op":"replace","path":"/price","value":2000}]`, `{"price":2000,"photos":[{"url":"a"},{"url":"b"}],"a/b":{"~":1}}`},
+		{`[{"op":"add","path":"/photos/1","value":{"url":"c"}}]`, `{"price":1000,"photos":[{"url":"a"},{"url":"c"},{"url":"b"}],"a/b":{"~":1}}`},
+		{`[{"op":"add","path":"/photos/-","value":{"url":"c"}}]`, `{"price":1000,"photos":[{"url":"a"},{"url":"b"},{"url":"c"}],"a/b":{"~":1}}`},
+		{`[{"op":"remove","path":"/photos/0"}]`, `{"price":1000,"photos":[{"url":"b"}],"a/b":{"~":1}}`},
+		{`[{"op":"move","from":"/photos/1","path":"/photos/0"}]`, `{"price":1000,"photos":[{"url":"b"},{"url":"a"}],"a/b":{"~":1}}`},
+		{`[{"op":"copy","from":"/photos/0/url","path":"/text"}]`, `{"price":1000,"text":"a","photos":[{"url":"a"},{"url":"b"}],"a/b":{"~":1}}`},
+		{`[{"op":"test","path":"/a~1b/~0","value":1},{"op":"remove","path":"/a~1b"}]`, `{"price":1000,"photos":[{"url":"a"},{"url":"b"}]}`},
+	}
+	for _, tt := range tests {
+		got, err := Apply([]byte(doc), []byte(tt.patch))
+		if assert.NoError(t, err, tt.patch) {
+			assert.JSONEq(t, tt.want, string(got), tt.patch)
+		}
+	}
+
+	for _, failing := range []string{
+		`[{"op":"test","path":"/price","value":1}]`,
+		`[{"op":"replace","path":"/missing","value":1}]`,
+		`[{"op":"remove","path":"/photos/2"}]`,
+		`[{"op":"add","path":"/photos/01","value":1}]`,
+		`[{"op":"move","from":"/photos","path":"/photos/0"}]`,
+		`[{"op":"remove","path":""}]`,
+	} {
+		_, err := Apply([]byte(doc), []byte(failing))
+		if assert.Error(t, err, failing) {
+			assert.NotErrorIs(t, err, ErrMalformed, failing)
+		}
+	}
+
+	for _, malformed := range []string{
+		`{"op":"add"}`,
+		`[{"op":"add","path":"/price"}]`,
+		`[{"op":"increment","path":"/price"}]`,
+		`[{"op":"add","path":"price","value":1}]`,
+	} {
+		_, err := Apply([]byte(doc), []byte(malformed))
+		assert.ErrorIs(t, err, ErrMalformed, malformed)
+	}
+}
@@ -0,0 +1,362 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"slices"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/render"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/storage"
+	"github.com/1karp/ads_api/internal/app/telegram"
+)
+
+// Publisher renders ads and sends them to the Telegram channels they are
+// routed to.
+type Publisher struct {
+	telegram  *telegram.Client
+	channelID string
+	media     *storage.Media
+	captions  *render.Renderer
+
+	// FullTextReply sends the whole text of an ad whose caption had to be
+	// cut short as a reply to its album.
+	FullTextReply bool
+
+	// Channels holds the channels ads are routed to. Without it, or while
+	// it has none, every ad goes to the default channel.
+	Channels repository.ChannelRepository
+}
+
+// New returns a publisher posting to the default channel channelID, which
+// also stands for messages recorded without a channel. Photos stored in
+// media are uploaded to Telegram rather than passed by URL; media may be nil.
+// Captions are rendered by captions, or with the built-in template when it
+// is nil.
+func New(tg *telegram.Client, channelID string, media *storage.Media, captions *render.Renderer) *Publisher {
+	return &Publisher{telegram: tg, channelID: channelID, media: media, captions: captions}
+}
+
+// ChannelID returns the default channel.
+func (p *Publisher) ChannelID() string {
+	return p.channelID
+}
+
+// Targets returns the channels ad is routed to: every channel whose rules
+// it matches or, when no channels are configured, the default channel.
+func (p *Publisher) Targets(ctx context.Context, ad models.Ad) ([]string, error) {
+	var channels []models.Channel
+	if p.Channels != nil {
+		var err error
+		if channels, err = p.Channels.List(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if len(channels) == 0 {
+		if p.channelID == "" {
+			return nil, nil
+		}
+		return []string{p.channelID}, nil
+	}
+
+	var targets []string
+	for _, c := range channels {
+		if c.Matches(ad) {
+			targets = append(targets, c.ID)
+		}
+	}
+	return targets, nil
+}
+
+// KnownChannel reports whether ads may be posted to channelID by hand:
+// it is the default channel or a configured one, whatever its rules.
+func (p *Publisher) KnownChannel(ctx context.Context, channelID string) (bool, error) {
+	if channelID == p.channelID {
+		return true, nil
+	}
+	if p.Channels == nil {
+		return false, nil
+	}
+	_, err := p.Channels.Get(ctx, channelID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// PostedChannels returns the channels the ad is posted to, in the order
+// of its messages.
+func (p *Publisher) PostedChannels(ad models.Ad) []string {
+	var channels []string
+	for _, m := range p.postedMessages(ad) {
+		if !slices.Contains(channels, m.ChannelID) {
+			channels = append(channels, m.ChannelID)
+		}
+	}
+	return channels
+}
+
+// Caption renders the caption of ad for channelID. Rented and expired ads
+// stay in the channel with a marker so subscribers know they are gone.
+func (p *Publisher) Caption(channelID string, ad models.Ad) (string, error) {
+	return p.captions.Caption(channelID, ad)
+}
+
+// Render renders the caption of ad for channelID, reporting whether the
+// ad's text had to be cut short to fit.
+func (p *Publisher) Render(channelID string, ad models.Ad) (render.Rendered, error) {
+	return p.captions.Render(channelID, ad)
+}
+
+// Reply returns the text of the full text reply the ad's album gets in
+// channelID, or "" when it gets none.
+func (p *Publisher) Reply(channelID string, ad models.Ad) (string, error) {
+	if !p.FullTextReply {
+		return "", nil
+	}
+	rendered, err := p.Render(channelID, ad)
+	if err != nil || !rendered.Truncated {
+		return "", err
+	}
+	return render.FullText(ad), nil
+}
+
+// Publish sends the ad to channelID as a media group and returns the sent
+// messages in album order.
+func (p *Publisher) Publish(ctx context.Context, ad models.Ad, channelID string) ([]models.TelegramMessage, error) {
+	if channelID == "" {
+		return nil, fmt.Errorf("no channel to publish to; set TELEGRAM_CHANNEL_ID or configure channels")
+	}
+
+	var files uploads
+	defer files.Close()
+	media := make([]telegram.InputMediaPhoto, len(ad.Photos))
+	for i, photo := range ad.Photos {
+		var err error
+		if media[i], err = p.inputMedia(ctx, ad, channelID, i, photo, &files); err != nil {
+			return nil, err
+		}
+	}
+
+	messages, err := p.telegram.SendMediaGroup(ctx, telegram.SendMediaGroupParams{ChatID: channelID, Media: media, Files: files.files})
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("empty sendMediaGroup result")
+	}
+
+	posted := make([]models.TelegramMessage, len(messages))
+	for i, m := range messages {
+		posted[i] = models.TelegramMessage{ChannelID: channelID, MessageID: m.MessageID, Position: i, FileID: largestPhoto(m.Photo), PhotoURL: ad.Photos[i].URL}
+	}
+	posted[0].Caption = media[0].Caption
+
+	// The album is out; failing the job now would post it again, so a
+	// reply that cannot be sent is left to the next sync.
+	if text, err := p.Reply(channelID, ad); err != nil {
+		slog.Error("Error rendering full text reply", "ad_id", ad.ID, "error", err)
+	} else if text != "" {
+		if reply, err := p.sendReply(ctx, text, posted); err != nil {
+			slog.Error("Error sending full text reply", "ad_id", ad.ID, "error", err)
+		} else {
+			posted = append(posted, reply)
+		}
+	}
+
+	slog.Info("Ad successfully posted to Telegram", "ad_id", ad.ID, "channel_id", channelID)
+	return posted, nil
+}
+
+// sendReply sends text in reply to the album's first message and returns
+// the message to record after the album.
+func (p *Publisher) sendReply(ctx context.Context, text string, album []models.TelegramMessage) (models.TelegramMessage, error) {
+	m, err := p.telegram.SendMessage(ctx, telegram.SendMessageParams{
+		ChatID:           album[0].ChannelID,
+		Text:             text,
+		ParseMode:        telegram.ParseModeHTML,
+		ReplyToMessageID: album[0].MessageID,
+	})
+	if err != nil {
+		return models.TelegramMessage{}, err
+	}
+	return models.TelegramMessage{ChannelID: album[0].ChannelID, MessageID: m.MessageID, Position: len(album), Caption: text, Reply: true}, nil
+}
+
+// inputMedia builds the album item for the photo at position; the first
+// one carries the caption for channelID. Uploaded photos are added to
+// files.
+func (p *Publisher) inputMedia(ctx context.Context, ad models.Ad, channelID string, position int, photo models.Photo, files *uploads) (telegram.InputMediaPhoto, error) {
+	ref, err := p.photoRef(ctx, channelPhoto(photo), fmt.Sprintf("photo%d", position), files)
+	if err != nil {
+		return telegram.InputMediaPhoto{}, err
+	}
+	media := telegram.NewInputMediaPhoto(ref)
+	if position == 0 {
+		if media.Caption, err = p.Caption(channelID, ad); err != nil {
+			return telegram.InputMediaPhoto{}, err
+		}
+		media.ParseMode = telegram.ParseModeHTML
+	}
+	return media, nil
+}
+
+// channelPhoto returns the variant of photo to post: the large one when the
+// photo was processed, since Telegram shows nothing bigger.
+func channelPhoto(photo models.Photo) string {
+	if photo.Large != "" {
+		return photo.Large
+	}
+	return photo.URL
+}
+
+// photoRef returns how Telegram should obtain photo. Photos kept in our
+// media storage are attached to the request as name, since Telegram may
+// not be able to reach the URLs we serve them under; other URLs are
+// passed through for Telegram to fetch. Uploads that were not processed
+// yet are refused, so the job is retried once they are.
+func (p *Publisher) photoRef(ctx context.Context, photo, name string, files *uploads) (string, error) {
+	key, ok := p.media.Key(photo)
+	if !ok {
+		return photo, nil
+	}
+	if storage.IsUpload(key) {
+		return "", fmt.Errorf("photo %s is not processed yet", key)
+	}
+	r, _, err := p.media.Backend.Open(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("error opening photo %s: %w", key, err)
+	}
+	files.files = append(files.files, telegram.InputFile{Name: name, Filename: path.Base(key), Reader: r})
+	files.closers = append(files.closers, r)
+	return telegram.Attach(name), nil
+}
+
+// uploads collects the files attached to one Bot API request.
+type uploads struct {
+	files   []telegram.InputFile
+	closers []io.Closer
+}
+
+func (u *uploads) Close() {
+	for _, c := range u.closers {
+		c.Close()
+	}
+}
+
+// largestPhoto returns the file_id of the biggest size Telegram stored,
+// which can be reused to send the same photo again without uploading it.
+func largestPhoto(sizes []telegram.PhotoSize) string {
+	fileID, best := "", 0
+	for _, size := range sizes {
+		if area := size.Width * size.Height; area >= best {
+			fileID, best = size.FileID, area
+		}
+	}
+	return fileID
+}
+
+// postedMessages returns the ad's albums with the channel filled in. Ads
+// posted before albums were recorded fall back to the first message id.
+func (p *Publisher) postedMessages(ad models.Ad) []models.TelegramMessage {
+	messages := ad.Messages
+	if len(messages) == 0 && ad.ChatMessageId != 0 {
+		messages = []models.TelegramMessage{{MessageID: ad.ChatMessageId}}
+	}
+	result := make([]models.TelegramMessage, len(messages))
+	for i, m := range messages {
+		if m.ChannelID == "" {
+			m.ChannelID = p.channelID
+		}
+		result[i] = m
+	}
+	return result
+}
+
+// byChannel groups messages by channel, keeping their order.
+func byChannel(messages []models.TelegramMessage) [][]models.TelegramMessage {
+	var groups [][]models.TelegramMessage
+	for i := 0; i < len(messages); {
+		j := i + 1
+		for j < len(messages) && messages[j].ChannelID == messages[i].ChannelID {
+			j++
+		}
+		groups = append(groups, messages[i:j])
+		i = j
+	}
+	return groups
+}
+
+// splitReply separates the album from the full text reply that may
+// follow it.
+func splitReply(messages []models.TelegramMessage) ([]models.TelegramMessage, *models.TelegramMessage) {
+	if n := len(messages); n > 0 && messages[n-1].Reply {
+		return messages[:n-1], &messages[n-1]
+	}
+	return messages, nil
+}
+
+// EditCaption re-renders the caption of an already posted ad in every
+// channel and returns the albums with the captions recorded. A caption
+// Telegram already shows is not an error.
+func (p *Publisher) EditCaption(ctx context.Context, ad models.Ad) ([]models.TelegramMessage, error) {
+	messages := p.postedMessages(ad)
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("ad %d has no posted messages", ad.ID)
+	}
+
+	for _, channel := range byChannel(messages) {
+		album, _ := splitReply(channel)
+		if len(album) == 0 {
+			continue
+		}
+		if err := p.editCaption(ctx, ad, album); err != nil {
+			return nil, err
+		}
+	}
+
+	slog.Info("Telegram message successfully edited", "ad_id", ad.ID)
+	return messages, nil
+}
+
+// editCaption sets the caption of the album's first message and records
+// it there.
+func (p *Publisher) editCaption(ctx context.Context, ad models.Ad, messages []models.TelegramMessage) error {
+	caption, err := p.Caption(messages[0].ChannelID, ad)
+	if err != nil {
+		return err
+	}
+	err = p.telegram.EditMessageCaption(ctx, telegram.EditMessageCaptionParams{
+		ChatID:    messages[0].ChannelID,
+		MessageID: messages[0].MessageID,
+		Caption:   caption,
+		ParseMode: telegram.ParseModeHTML,
+	})
+	if err != nil && !telegram.IsMessageNotModified(err) {
+		return err
+	}
+	messages[0].Caption = caption
+	return nil
+}
+
+// Delete removes every message of the ad's albums, and their replies,
+// from every channel. Messages already gone are skipped.
+func (p *Publisher) Delete(ctx context.Context, ad models.Ad) error {
+	for _, m := range p.postedMessages(ad) {
+		err := p.telegram.DeleteMessage(ctx, telegram.DeleteMessageParams{
+			ChatID:    m.ChannelID,
+			MessageID: m.MessageID,
+		})
+		if err != nil && !telegram.IsMessageNotFound(err) {
+			return err
+		}
+	}
+
+	slog.Info("Ad removed from Telegram", "ad_id", ad.ID)
+	return nil
+}
@@ -0,0 +1,241 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/telegram"
+)
+
+// Operations reported by Sync.
+const (
+	OpNone        = "none"
+	OpEditCaption = "edit_caption"
+	OpEditMedia   = "edit_media"
+	OpRepost      = "repost"
+	OpEditReply   = "edit_reply"
+)
+
+// SyncResult describes how Sync brought the channel posts up to date.
+// Operation and Slots summarise Channels: the most far-reaching operation
+// performed in any channel and every photo slot replaced. Messages are the
+// albums as they now stand and must be recorded by the caller.
+type SyncResult struct {
+	Operation string                   `json:"operation"`
+	Slots     []int                    `json:"slots,omitempty"`
+	Channels  []ChannelSyncResult      `json:"channels,omitempty"`
+	Messages  []models.TelegramMessage `json:"messages"`
+}
+
+// ChannelSyncResult describes how Sync updated the post in one channel.
+type ChannelSyncResult struct {
+	ChannelID string `json:"channel_id"`
+	Operation string `json:"operation"`
+	Slots     []int  `json:"slots,omitempty"`
+}
+
+// operationRank orders operations by how much of a post they change.
+var operationRank = map[string]int{OpNone: 0, OpEditReply: 1, OpEditCaption: 2, OpEditMedia: 3, OpRepost: 4}
+
+// add records the outcome of syncing one channel.
+func (r *SyncResult) add(channel ChannelSyncResult, messages []models.TelegramMessage) {
+	r.Channels = append(r.Channels, channel)
+	r.Messages = append(r.Messages, messages...)
+	if operationRank[channel.Operation] > operationRank[r.Operation] {
+		r.Operation = channel.Operation
+	}
+	for _, slot := range channel.Slots {
+		if !slices.Contains(r.Slots, slot) {
+			r.Slots = append(r.Slots, slot)
+		}
+	}
+	slices.Sort(r.Slots)
+}
+
+// Sync updates the posted albums of ad in every channel to match its
+// current photos and caption. Photos that changed in place are swapped
+// with editMessageMedia; when the number of photos changed the album is
+// reposted, because Telegram cannot add messages to or remove them from
+// an album. The caption is only edited when it differs from the one last
+// sent, and the full text reply is sent, edited or removed to match it.
+//
+// When a channel fails, the result holds the messages of the channels
+// synced so far, including what changed in the failing one.
+func (p *Publisher) Sync(ctx context.Context, ad models.Ad) (SyncResult, error) {
+	posted := p.postedMessages(ad)
+	if len(posted) == 0 {
+		return SyncResult{}, fmt.Errorf("ad %d has no posted messages", ad.ID)
+	}
+
+	result := SyncResult{Operation: OpNone}
+	for _, messages := range byChannel(posted) {
+		channel, synced, err := p.syncChannel(ctx, ad, messages)
+		result.add(channel, synced)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// syncChannel brings the ad's post in one channel up to date and returns
+// the channel's messages as they now stand.
+func (p *Publisher) syncChannel(ctx context.Context, ad models.Ad, posted []models.TelegramMessage) (ChannelSyncResult, []models.TelegramMessage, error) {
+	channelID := posted[0].ChannelID
+	result := ChannelSyncResult{ChannelID: channelID, Operation: OpNone}
+	album, reply := splitReply(posted)
+	if len(album) == 0 || len(ad.Photos) != len(album) {
+		messages, err := p.repost(ctx, ad, channelID, posted)
+		if messages != nil {
+			result.Operation = OpRepost
+		}
+		return result, messages, err
+	}
+
+	caption, err := p.Caption(channelID, ad)
+	if err != nil {
+		return result, posted, err
+	}
+
+	// album shares posted's messages, so updating it updates posted.
+	for i, photo := range ad.Photos {
+		if album[i].PhotoURL == photo.URL {
+			continue
+		}
+		if err := p.editMedia(ctx, ad, album[i], photo); err != nil {
+			return result, posted, err
+		}
+		album[i].PhotoURL = photo.URL
+		if i == 0 {
+			album[0].Caption = caption
+		}
+		result.Operation = OpEditMedia
+		result.Slots = append(result.Slots, i)
+	}
+
+	if album[0].Caption != caption {
+		if err := p.editCaption(ctx, ad, album); err != nil {
+			return result, posted, err
+		}
+		if result.Operation == OpNone {
+			result.Operation = OpEditCaption
+		}
+	}
+
+	messages, err := p.syncReply(ctx, ad, album, reply, &result)
+	if err != nil {
+		return result, posted, err
+	}
+
+	slog.Info("Telegram album synced", "ad_id", ad.ID, "channel_id", channelID, "operation", result.Operation, "slots", result.Slots)
+	return result, messages, nil
+}
+
+// syncReply sends, edits or removes the full text reply to album as the
+// ad now requires, and returns the channel's messages with it.
+func (p *Publisher) syncReply(ctx context.Context, ad models.Ad, album []models.TelegramMessage, reply *models.TelegramMessage, result *ChannelSyncResult) ([]models.TelegramMessage, error) {
+	messages := album
+	if reply != nil {
+		messages = append(album, *reply)
+	}
+	text, err := p.Reply(album[0].ChannelID, ad)
+	if err != nil {
+		return messages, err
+	}
+
+	switch {
+	case reply == nil && text == "":
+		return messages, nil
+	case reply == nil:
+		m, err := p.sendReply(ctx, text, album)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(album, m)
+	case text == "":
+		err := p.telegram.DeleteMessage(ctx, telegram.DeleteMessageParams{ChatID: reply.ChannelID, MessageID: reply.MessageID})
+		if err != nil && !telegram.IsMessageNotFound(err) {
+			return messages, err
+		}
+		messages = album
+	case reply.Caption == text:
+		return messages, nil
+	default:
+		err := p.telegram.EditMessageText(ctx, telegram.EditMessageTextParams{
+			ChatID:    reply.ChannelID,
+			MessageID: reply.MessageID,
+			Text:      text,
+			ParseMode: telegram.ParseModeHTML,
+		})
+		if err != nil && !telegram.IsMessageNotModified(err) {
+			return messages, err
+		}
+		messages[len(messages)-1].Caption = text
+	}
+
+	if result.Operation == OpNone {
+		result.Operation = OpEditReply
+	}
+	return messages, nil
+}
+
+// editMedia replaces the photo of one album message. Replacing it with
+// the same photo is not an error.
+func (p *Publisher) editMedia(ctx context.Context, ad models.Ad, m models.TelegramMessage, photo models.Photo) error {
+	var files uploads
+	defer files.Close()
+	media, err := p.inputMedia(ctx, ad, m.ChannelID, m.Position, photo, &files)
+	if err != nil {
+		return err
+	}
+	err = p.telegram.EditMessageMedia(ctx, telegram.EditMessageMediaParams{
+		ChatID:    m.ChannelID,
+		MessageID: m.MessageID,
+		Media:     media,
+		Files:     files.files,
+	})
+	if err != nil && !telegram.IsMessageNotModified(err) {
+		return err
+	}
+	return nil
+}
+
+// Bump reposts the ad's album in channelID so it shows at the top of the
+// channel again, and returns the new messages. The old album is deleted
+// once the new one is out; if that fails the new messages are still
+// returned with the error.
+func (p *Publisher) Bump(ctx context.Context, ad models.Ad, channelID string) ([]models.TelegramMessage, error) {
+	var old []models.TelegramMessage
+	for _, m := range p.postedMessages(ad) {
+		if m.ChannelID == channelID {
+			old = append(old, m)
+		}
+	}
+	if len(old) == 0 {
+		return nil, fmt.Errorf("ad %d is not posted to %s", ad.ID, channelID)
+	}
+	return p.repost(ctx, ad, channelID, old)
+}
+
+// repost sends the album to channelID anew and then removes the old one,
+// so a failure never leaves the ad without a post. If removing the old
+// album fails the new messages are still returned with the error.
+func (p *Publisher) repost(ctx context.Context, ad models.Ad, channelID string, old []models.TelegramMessage) ([]models.TelegramMessage, error) {
+	messages, err := p.Publish(ctx, ad, channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range old {
+		err := p.telegram.DeleteMessage(ctx, telegram.DeleteMessageParams{ChatID: m.ChannelID, MessageID: m.MessageID})
+		if err != nil && !telegram.IsMessageNotFound(err) {
+			return messages, err
+		}
+	}
+
+	slog.Info("Telegram album reposted", "ad_id", ad.ID, "channel_id", channelID, "messages", len(messages))
+	return messages, nil
+}
@@ -0,0 +1,378 @@
+// Package render renders the Telegram captions of ads from text/template
+// templates. Captions are sent with parse_mode HTML, so every ad field is
+// escaped before it reaches a template; templates themselves may use the
+// tags Telegram supports, such as <b> or <a href>.
+//
+// Templates are read from a directory: <channel id>.tmpl renders captions
+// for that channel and default.tmpl for all others. Without either, the
+// built-in template is used. The directory is re-read by Reload and Watch,
+// so templates can be changed without a restart.
+//
+// Telegram limits captions to MaxCaption characters, counted in UTF-16
+// code units after the HTML is parsed. A caption over the limit is fitted
+// by cutting the ad's text short.
+package render
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/1karp/ads_api/internal/app/models"
+)
+
+// DefaultName is the template used for channels without their own.
+const DefaultName = "default"
+
+// Builtin is the caption template used when no default.tmpl is given.
+const Builtin = `{{if .Rented}}<b>RENTED</b>
+
+{{else if .Expired}}<b>NO LONGER AVAILABLE</b>
+
+{{end}}#{{.DistrictTag}}, #under_{{.PriceTag}}
+
+Rooms: {{.Rooms}}
+Price: {{.Price}} AED/Year
+Type: {{.Type}}
+Area: {{.Area}} sqm
+Building: {{.Building}}
+District: {{.District}}
+
+{{.Text}}
+
+Contact: @{{.Username}}`
+
+const ext = ".tmpl"
+
+const (
+	// MaxCaption is Telegram's limit for media captions.
+	MaxCaption = 1024
+	// MaxMessage is Telegram's limit for the text of a message.
+	MaxMessage = 4096
+)
+
+// Ellipsis ends a text that was cut short.
+const Ellipsis = "…"
+
+// ErrCaptionTooLong is returned when a caption exceeds MaxCaption even
+// without the ad's text.
+var ErrCaptionTooLong = errors.New("caption too long")
+
+var builtin = template.Must(template.New(DefaultName).Option("missingkey=error").Parse(Builtin))
+
+// Data is what caption templates are executed with. String fields are
+// already escaped for Telegram HTML.
+type Data struct {
+	ID       int
+	Rooms    string
+	Price    int
+	Type     string
+	Area     int
+	Building string
+	District string
+	Text     string
+	Username string
+	State    string
+	Rented   bool
+	Expired  bool
+
+	// DistrictTag is the district as a hashtag, without the #.
+	DistrictTag string
+	// PriceTag is the price rounded up to the next 10000, for the
+	// #under_ hashtag.
+	PriceTag int
+}
+
+// NewData returns the template data for ad.
+func NewData(ad models.Ad) Data {
+	return Data{
+		ID:          ad.ID,
+		Rooms:       Escape(ad.Rooms),
+		Price:       ad.Price,
+		Type:        Escape(ad.Type),
+		Area:        ad.Area,
+		Building:    Escape(ad.Building),
+		District:    Escape(ad.District),
+		Text:        Escape(ad.Text),
+		Username:    Escape(ad.Username),
+		State:       string(ad.State),
+		Rented:      ad.State == models.AdRented,
+		Expired:     ad.State == models.AdExpired,
+		DistrictTag: Escape(strings.ReplaceAll(ad.District, " ", "_")),
+		PriceTag:    ((ad.Price-1)/10000 + 1) * 10000,
+	}
+}
+
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+// Escape escapes the characters Telegram HTML gives a meaning to, quotes
+// included so values are safe inside attributes such as a link's href.
+func Escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// Renderer renders captions from the templates in a directory. It is
+// safe for concurrent use; Reload swaps the templates atomically.
+type Renderer struct {
+	dir string
+
+	mu        sync.RWMutex
+	templates map[string]*template.Template
+	stamp     string
+}
+
+// New returns a renderer reading templates from dir. An empty dir renders
+// every caption with the built-in template. A template that fails to
+// parse is an error.
+func New(dir string) (*Renderer, error) {
+	r := &Renderer{dir: dir, templates: map[string]*template.Template{}}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Rendered is a caption together with how it was fitted.
+type Rendered struct {
+	Caption string
+	// Length is the caption's length as Telegram counts it.
+	Length int
+	// Truncated reports whether the ad's text was cut short to fit.
+	Truncated bool
+}
+
+// Caption renders the caption of ad for channelID. A nil renderer uses
+// the built-in template.
+func (r *Renderer) Caption(channelID string, ad models.Ad) (string, error) {
+	rendered, err := r.Render(channelID, ad)
+	return rendered.Caption, err
+}
+
+// Render renders the caption of ad for channelID, cutting the ad's text
+// short with an Ellipsis when the caption would exceed MaxCaption.
+func (r *Renderer) Render(channelID string, ad models.Ad) (Rendered, error) {
+	t := r.template(channelID)
+	data := NewData(ad)
+	caption, err := execute(t, data)
+	if err != nil {
+		return Rendered{}, fmt.Errorf("render caption of ad %d: %w", ad.ID, err)
+	}
+	length := Length(caption)
+
+	// The text may appear more than once in a template, so shrink it by
+	// the overflow until the caption fits.
+	budget := utf16Len(ad.Text)
+	for length > MaxCaption {
+		if budget == 0 {
+			return Rendered{}, fmt.Errorf("caption of ad %d is %d characters: %w", ad.ID, length, ErrCaptionTooLong)
+		}
+		budget = max(budget-(length-MaxCaption), 0)
+		data.Text = Escape(Truncate(ad.Text, budget))
+		if caption, err = execute(t, data); err != nil {
+			return Rendered{}, fmt.Errorf("render caption of ad %d: %w", ad.ID, err)
+		}
+		length = Length(caption)
+	}
+	return Rendered{Caption: caption, Length: length, Truncated: budget < utf16Len(ad.Text)}, nil
+}
+
+func execute(t *template.Template, data Data) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// FullText returns the ad's text as a message of its own, escaped and cut
+// short at MaxMessage.
+func FullText(ad models.Ad) string {
+	return Escape(Truncate(strings.TrimSpace(ad.Text), MaxMessage))
+}
+
+var tags = regexp.MustCompile(`<[^>]*>`)
+
+// Length returns the length of a Telegram HTML text as Telegram counts
+// it: in UTF-16 code units, without tags and with entities decoded.
+func Length(s string) int {
+	return utf16Len(html.UnescapeString(tags.ReplaceAllString(s, "")))
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// Truncate cuts s to at most n UTF-16 code units including the trailing
+// Ellipsis, preferring to cut between words. s is returned unchanged when
+// it fits.
+func Truncate(s string, n int) string {
+	if utf16Len(s) <= n {
+		return s
+	}
+	if n < utf16Len(Ellipsis) {
+		return ""
+	}
+
+	n -= utf16Len(Ellipsis)
+	end, used := 0, 0
+	for i, r := range s {
+		w := utf16Len(string(r))
+		if used+w > n {
+			break
+		}
+		used += w
+		end = i + utf8.RuneLen(r)
+	}
+	cut := s[:end]
+	// Don't cut a word in half unless that would drop most of the text.
+	if i := strings.LastIndexFunc(cut, unicode.IsSpace); i > len(cut)/2 && !isSpaceAt(s, end) {
+		cut = cut[:i]
+	}
+	cut = strings.TrimRightFunc(cut, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(",;:-", r)
+	})
+	return cut + Ellipsis
+}
+
+func isSpaceAt(s string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return unicode.IsSpace(r)
+}
+
+func (r *Renderer) template(channelID string) *template.Template {
+	if r == nil {
+		return builtin
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if t, ok := r.templates[channelID]; ok && channelID != "" {
+		return t
+	}
+	if t, ok := r.templates[DefaultName]; ok {
+		return t
+	}
+	return builtin
+}
+
+// Reload re-reads the template directory. When a template fails to parse,
+// the templates in use are kept and the error is returned.
+func (r *Renderer) Reload() error {
+	if r.dir == "" {
+		return nil
+	}
+	files, stamp, err := r.scan()
+	if err != nil {
+		return err
+	}
+
+	templates := make(map[string]*template.Template, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(r.dir, file))
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(file, ext)
+		t, err := template.New(name).Option("missingkey=error").Parse(string(data))
+		if err != nil {
+			return err
+		}
+		// Catch references to fields Data does not have now rather than
+		// when an ad is posted.
+		if err := t.Execute(&bytes.Buffer{}, NewData(models.Ad{})); err != nil {
+			return fmt.Errorf("template %s: %w", file, err)
+		}
+		templates[name] = t
+	}
+
+	r.mu.Lock()
+	r.templates = templates
+	r.stamp = stamp
+	r.mu.Unlock()
+	slog.Info("Caption templates loaded", "dir", r.dir, "templates", len(templates))
+	return nil
+}
+
+// scan lists the template files of the directory together with a stamp
+// that changes whenever one of them is added, removed or modified.
+func (r *Renderer) scan() ([]string, string, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, "", err
+	}
+	var files []string
+	var stamp strings.Builder
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ext) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, "", err
+		}
+		files = append(files, e.Name())
+		fmt.Fprintf(&stamp, "%s:%d:%d;", e.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	sort.Strings(files)
+	return files, stamp.String(), nil
+}
+
+// Watch reloads the templates whenever the directory changes, checking
+// every interval until ctx is cancelled. Templates that fail to load are
+// logged and the previous ones stay in use.
+func (r *Renderer) Watch(ctx context.Context, interval time.Duration) {
+	if r.dir == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		_, stamp, err := r.scan()
+		if err != nil {
+			slog.Error("Error scanning caption templates", "dir", r.dir, "error", err)
+			continue
+		}
+		r.mu.RLock()
+		changed := stamp != r.stamp
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := r.Reload(); err != nil {
+			slog.Error("Error reloading caption templates", "dir", r.dir, "error", err)
+			// Don't retry until the files change again.
+			r.mu.Lock()
+			r.stamp = stamp
+			r.mu.Unlock()
+		}
+	}
+}
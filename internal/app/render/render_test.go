@@ -0,0 +1,183 @@
+package render
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTemplate(t *testing.T, dir, name, text string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(text), 0o644))
+}
+
+func TestCaptionBuiltin(t *testing.T) {
+	ad := models.Ad{
+		ID: 1, Username: "landlord", Rooms: "2", Price: 95000, Type: "apartment", Area: 80,
+		Building: "Tower <A> & B", District: "Dubai Marina", Text: "2<3 bedrooms",
+	}
+
+	var r *Renderer
+	caption, err := r.Caption("@channel", ad)
+	require.NoError(t, err)
+	assert.Equal(t, "#Dubai_Marina, #under_100000\n\n"+
+		"Rooms: 2\nPrice: 95000 AED/Year\nType: apartment\nArea: 80 sqm\n"+
+		"Building: Tower &lt;A&gt; &amp; B\nDistrict: Dubai Marina\n\n"+
+		"2&lt;3 bedrooms\n\nContact: @landlord", caption)
+
+	ad.State = models.AdRented
+	caption, err = r.Caption("@channel", ad)
+	require.NoError(t, err)
+	assert.Contains(t, caption, "<b>RENTED</b>\n\n#Dubai_Marina")
+
+	ad.State = models.AdExpired
+	caption, err = r.Caption("@channel", ad)
+	require.NoError(t, err)
+	assert.Contains(t, caption, "<b>NO LONGER AVAILABLE</b>\n\n#Dubai_Marina")
+}
+
+func TestCaptionPerChannel(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, dir, "default.tmpl", "<b>{{.District}}</b> {{.Price}}\n")
+	writeTemplate(t, dir, "@ru_channel.tmpl", "Цена: {{.Price}}")
+	writeTemplate(t, dir, "notes.txt", "{{.Missing}}")
+
+	r, err := New(dir)
+	require.NoError(t, err)
+
+	ad := models.Ad{ID: 1, Price: 60000, District: "A&B"}
+	caption, err := r.Caption("@channel", ad)
+	require.NoError(t, err)
+	assert.Equal(t, "<b>A&amp;B</b> 60000", caption)
+
+	caption, err = r.Caption("@ru_channel", ad)
+	require.NoError(t, err)
+	assert.Equal(t, "Цена: 60000", caption)
+}
+
+func TestNewInvalidTemplate(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, dir, "default.tmpl", "{{.Price")
+	_, err := New(dir)
+	assert.Error(t, err)
+
+	writeTemplate(t, dir, "default.tmpl", "{{.Missing}}")
+	_, err = New(dir)
+	assert.Error(t, err, "unknown fields are caught when loading")
+
+	_, err = New(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, dir, "default.tmpl", "v1 {{.Price}}")
+	r, err := New(dir)
+	require.NoError(t, err)
+
+	ad := models.Ad{Price: 1}
+	writeTemplate(t, dir, "default.tmpl", "{{.Price")
+	assert.Error(t, r.Reload())
+	caption, _ := r.Caption("", ad)
+	assert.Equal(t, "v1 1", caption, "a broken template keeps the previous one")
+
+	writeTemplate(t, dir, "default.tmpl", "v2 {{.Price}}")
+	require.NoError(t, r.Reload())
+	caption, _ = r.Caption("", ad)
+	assert.Equal(t, "v2 1", caption)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "default.tmpl")))
+	require.NoError(t, r.Reload())
+	caption, _ = r.Caption("", ad)
+	assert.Contains(t, caption, "Price: 1 AED/Year", "without templates the built-in one is used")
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, dir, "default.tmpl", "v1")
+	r, err := New(dir)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	writeTemplate(t, dir, "@channel.tmpl", "v2")
+	assert.Eventually(t, func() bool {
+		caption, _ := r.Caption("@channel", models.Ad{})
+		return caption == "v2"
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestEscape(t *testing.T) {
+	assert.Equal(t, "Tower &lt;A&gt; &amp; &quot;B&quot;", Escape(`Tower <A> & "B"`))
+	assert.Equal(t, 13, Length(Escape(`Tower "B" & C`)), "escaping does not change the length")
+}
+
+func TestLength(t *testing.T) {
+	assert.Equal(t, 5, Length("<b>a&amp;b</b> c"))
+	assert.Equal(t, 2, Length("&lt;3"))
+	assert.Equal(t, 3, Length("дом"))
+	assert.Equal(t, 2, Length("🏠"), "characters outside the BMP count twice")
+}
+
+func TestTruncate(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		n    int
+		want string
+	}{
+		{"fits", 4, "fits"},
+		{"a spacious flat", 12, "a spacious…"},
+		{"a spacious, bright flat", 16, "a spacious…"},
+		{"Bedroomsandbathrooms", 8, "Bedroom…"},
+		{"🏠🏠🏠", 5, "🏠🏠…"},
+		{"🏠🏠🏠", 4, "🏠…"},
+		{"abc", 0, ""},
+	} {
+		got := Truncate(tc.text, tc.n)
+		assert.Equal(t, tc.want, got, tc.text)
+		assert.LessOrEqual(t, utf16Len(got), tc.n, tc.text)
+	}
+}
+
+func TestRenderTruncates(t *testing.T) {
+	ad := models.Ad{ID: 1, Price: 60000, District: "JLT", Username: "landlord", Text: strings.Repeat("2<3 rooms 🏠 ", 200)}
+
+	var r *Renderer
+	rendered, err := r.Render("", ad)
+	require.NoError(t, err)
+	assert.True(t, rendered.Truncated)
+	assert.Equal(t, Length(rendered.Caption), rendered.Length)
+	assert.LessOrEqual(t, rendered.Length, MaxCaption)
+	assert.Greater(t, rendered.Length, MaxCaption-20, "as much text as fits is kept")
+	assert.True(t, strings.HasSuffix(rendered.Caption, " rooms…\n\nContact: @landlord"), "the text is cut between words")
+
+	ad.Text = "short"
+	rendered, err = r.Render("", ad)
+	require.NoError(t, err)
+	assert.False(t, rendered.Truncated)
+
+	t.Run("Too Long Without Text", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTemplate(t, dir, "default.tmpl", strings.Repeat("x", MaxCaption+1)+"{{.Text}}")
+		r, err := New(dir)
+		require.NoError(t, err)
+		_, err = r.Render("", ad)
+		assert.ErrorIs(t, err, ErrCaptionTooLong)
+	})
+}
+
+func TestFullText(t *testing.T) {
+	assert.Equal(t, "a &amp; b", FullText(models.Ad{Text: " a & b\n"}))
+	full := FullText(models.Ad{Text: strings.Repeat("word ", 1000)})
+	assert.LessOrEqual(t, Length(full), MaxMessage)
+	assert.True(t, strings.HasSuffix(full, "word…"))
+}
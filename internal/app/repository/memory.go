@@ -0,0 +1,523 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+)
+
+// memoryTimeFormat is fixed-width so created_at values sort lexically.
+const memoryTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// MemoryAdRepository is an in-process AdRepository for tests and local
+// development. It is safe for concurrent use.
+type MemoryAdRepository struct {
+	mu          sync.Mutex
+	ads         map[int]models.Ad
+	nextID      int
+	nextPhotoID int
+}
+
+func NewMemoryAdRepository() *MemoryAdRepository {
+	return &MemoryAdRepository{ads: map[int]models.Ad{}, nextID: 1, nextPhotoID: 1}
+}
+
+// savePhotos returns a copy of photos, as returned by Photos.Merge, with
+// ids given to the new ones. The caller must hold r.mu.
+func (r *MemoryAdRepository) savePhotos(photos models.Photos) models.Photos {
+	saved := append(models.Photos{}, photos...)
+	for i := range saved {
+		if saved[i].ID == 0 {
+			saved[i].ID = r.nextPhotoID
+			r.nextPhotoID++
+		}
+	}
+	return saved
+}
+
+func (r *MemoryAdRepository) Create(ctx context.Context, ad *models.Ad) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ad.ID = r.nextID
+	r.nextID++
+	if ad.State == "" {
+		ad.State = models.AdDraft
+	}
+	ad.Messages = nil
+	ad.Photos = r.savePhotos(models.Photos{}.Merge(ad.Photos))
+	ad.CreatedAt = time.Now().UTC().Format(memoryTimeFormat)
+	ad.Version = 1
+	r.ads[ad.ID] = *ad
+	return nil
+}
+
+func (r *MemoryAdRepository) Get(ctx context.Context, id int) (models.Ad, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ad, ok := r.ads[id]
+	if !ok {
+		return models.Ad{}, ErrNotFound
+	}
+	return ad, nil
+}
+
+func (r *MemoryAdRepository) List(ctx context.Context, filter AdFilter, opts ListOptions) ([]models.Ad, error) {
+	spec, ok := adSorts[opts.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sort %q", opts.Sort)
+	}
+
+	r.mu.Lock()
+	var ads []models.Ad
+	for _, ad := range r.ads {
+		if matchesAdFilter(filter, ad) {
+			ads = append(ads, ad)
+		}
+	}
+	r.mu.Unlock()
+
+	return paginateMemory(ads, spec, opts, func(ad models.Ad) Keyset {
+		return Keyset{Value: spec.key(ad), ID: ad.ID}
+	}), nil
+}
+
+func (r *MemoryAdRepository) Update(ctx context.Context, ad *models.Ad) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.ads[ad.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if ad.Version != 0 && ad.Version != existing.Version {
+		return ErrStale
+	}
+	ad.Version = existing.Version + 1
+	ad.CreatedAt = existing.CreatedAt
+	ad.State = existing.State
+	ad.IsPosted, ad.ChatMessageId = existing.IsPosted, existing.ChatMessageId
+	ad.ListedAt = existing.ListedAt
+	ad.Messages = existing.Messages
+	ad.Photos = r.savePhotos(existing.Photos.Merge(ad.Photos))
+	r.ads[ad.ID] = *ad
+	return nil
+}
+
+// SetMessages records the posted albums of an ad in the channels of
+// messages. No messages mark the ad as no longer posted anywhere.
+func (r *MemoryAdRepository) SetMessages(ctx context.Context, id int, messages []models.TelegramMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ad, ok := r.ads[id]
+	if !ok {
+		return ErrNotFound
+	}
+	var kept []models.TelegramMessage
+	if len(messages) > 0 {
+		channels := channelsOf(messages)
+		for _, m := range ad.Messages {
+			if !slices.Contains(channels, m.ChannelID) {
+				kept = append(kept, m)
+			}
+		}
+	}
+	ad.IsPosted, ad.ChatMessageId, ad.Messages = 0, 0, nil
+	if len(messages) > 0 {
+		ad.Messages = append(kept, messages...)
+		sort.SliceStable(ad.Messages, func(i, j int) bool {
+			if ad.Messages[i].ChannelID != ad.Messages[j].ChannelID {
+				return ad.Messages[i].ChannelID < ad.Messages[j].ChannelID
+			}
+			return ad.Messages[i].Position < ad.Messages[j].Position
+		})
+		ad.IsPosted = 1
+		ad.ChatMessageId = ad.Messages[0].MessageID
+	}
+	ad.Version++
+	r.ads[id] = ad
+	return nil
+}
+
+func (r *MemoryAdRepository) ReplacePhoto(ctx context.Context, adID int, upload string, photo models.Photo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ad, ok := r.ads[adID]
+	if !ok {
+		return ErrConflict
+	}
+	ad.Photos = append(models.Photos{}, ad.Photos...)
+	replaced := false
+	for i, p := range ad.Photos {
+		if p.URL == upload {
+			photo.ID, photo.Position, photo.Caption = p.ID, p.Position, p.Caption
+			ad.Photos[i], replaced = photo, true
+		}
+	}
+	if !replaced {
+		return ErrConflict
+	}
+
+	ad.Messages = append([]models.TelegramMessage(nil), ad.Messages...)
+	for i, m := range ad.Messages {
+		if m.PhotoURL == upload {
+			ad.Messages[i].PhotoURL = photo.URL
+		}
+	}
+	ad.Version++
+	r.ads[adID] = ad
+	return nil
+}
+
+func (r *MemoryAdRepository) ReorderPhotos(ctx context.Context, adID int, ids []int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ad, ok := r.ads[adID]
+	if !ok {
+		return ErrNotFound
+	}
+	byID := map[int]models.Photo{}
+	for _, p := range ad.Photos {
+		byID[p.ID] = p
+	}
+	if len(ids) != len(byID) {
+		return ErrConflict
+	}
+	photos := make(models.Photos, len(ids))
+	for i, id := range ids {
+		p, ok := byID[id]
+		if !ok {
+			return ErrConflict
+		}
+		delete(byID, id)
+		p.Position = i
+		photos[i] = p
+	}
+	ad.Photos = photos
+	ad.Version++
+	r.ads[adID] = ad
+	return nil
+}
+
+func (r *MemoryAdRepository) DeletePhoto(ctx context.Context, adID int, photoID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ad, ok := r.ads[adID]
+	if !ok {
+		return ErrNotFound
+	}
+	photos := models.Photos{}
+	for _, p := range ad.Photos {
+		if p.ID != photoID {
+			photos = append(photos, p)
+		}
+	}
+	if len(photos) == len(ad.Photos) {
+		return ErrNotFound
+	}
+	ad.Photos = photos.Normalize()
+	ad.Version++
+	r.ads[adID] = ad
+	return nil
+}
+
+// setState moves the ad from one state to another, returning ErrConflict
+// when it is no longer in from and ErrStale when it is no longer at a
+// version that is not zero. Moving it to published clears its listing
+// until its post goes out again.
+func (r *MemoryAdRepository) setState(id int, from, to models.AdState, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ad, ok := r.ads[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if ad.State != from {
+		return ErrConflict
+	}
+	if version != 0 && version != ad.Version {
+		return ErrStale
+	}
+	ad.State = to
+	if to == models.AdPublished {
+		ad.ListedAt = nil
+	}
+	ad.Version++
+	r.ads[id] = ad
+	return nil
+}
+
+// startListing lists a published ad at t unless it is listed already.
+func (r *MemoryAdRepository) startListing(id int, t time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ad, ok := r.ads[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if ad.State == models.AdPublished && ad.ListedAt == nil {
+		ad.ListedAt = &t
+		r.ads[id] = ad
+	}
+	return nil
+}
+
+// setListedAt restarts the listing of an ad at t.
+func (r *MemoryAdRepository) setListedAt(id int, t time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ad, ok := r.ads[id]
+	if !ok {
+		return ErrNotFound
+	}
+	ad.ListedAt = &t
+	r.ads[id] = ad
+	return nil
+}
+
+func (r *MemoryAdRepository) Delete(ctx context.Context, id int, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ad, ok := r.ads[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if version != 0 && version != ad.Version {
+		return ErrStale
+	}
+	delete(r.ads, id)
+	return nil
+}
+
+func matchesAdFilter(f AdFilter, ad models.Ad) bool {
+	if !f.IncludeDeleted && ad.State == models.AdDeleted {
+		return false
+	}
+	if f.UserID != nil && ad.UserID != *f.UserID {
+		return false
+	}
+	if f.MinPrice != nil && ad.Price < *f.MinPrice {
+		return false
+	}
+	if f.MaxPrice != nil && ad.Price > *f.MaxPrice {
+		return false
+	}
+	if len(f.Rooms) > 0 && !contains(f.Rooms, ad.Rooms) {
+		return false
+	}
+	if len(f.Types) > 0 && !contains(f.Types, ad.Type) {
+		return false
+	}
+	if f.District != "" && !strings.EqualFold(f.District, ad.District) {
+		return false
+	}
+	if f.MinArea != nil && ad.Area < *f.MinArea {
+		return false
+	}
+	if f.MaxArea != nil && ad.Area > *f.MaxArea {
+		return false
+	}
+	if len(f.States) > 0 && !contains(f.States, string(ad.State)) {
+		return false
+	}
+	if f.IsPosted != nil && (ad.IsPosted != 0) != *f.IsPosted {
+		return false
+	}
+	if f.CreatedAfter != nil {
+		createdAt, err := time.Parse(memoryTimeFormat, ad.CreatedAt)
+		if err != nil || !createdAt.After(*f.CreatedAfter) {
+			return false
+		}
+	}
+	if f.ListedBefore != nil && (ad.ListedAt == nil || !ad.ListedAt.Before(*f.ListedBefore)) {
+		return false
+	}
+	return true
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// compareKeysets orders keysets the way Postgres orders (column, id) rows.
+func compareKeysets(spec sortSpec, a, b Keyset) int {
+	if spec.column != "" {
+		if spec.numeric {
+			av, _ := strconv.Atoi(a.Value)
+			bv, _ := strconv.Atoi(b.Value)
+			if av != bv {
+				if av < bv {
+					return -1
+				}
+				return 1
+			}
+		} else if c := strings.Compare(a.Value, b.Value); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case a.ID < b.ID:
+		return -1
+	case a.ID > b.ID:
+		return 1
+	}
+	return 0
+}
+
+// paginateMemory sorts items and applies the keyset and limit of opts.
+func paginateMemory[T any](items []T, spec sortSpec, opts ListOptions, keyset func(T) Keyset) []T {
+	cmp := func(a, b Keyset) int {
+		c := compareKeysets(spec, a, b)
+		if spec.desc {
+			return -c
+		}
+		return c
+	}
+
+	sort.Slice(items, func(i, j int) bool { return cmp(keyset(items[i]), keyset(items[j])) < 0 })
+
+	var result []T
+	for _, item := range items {
+		if opts.After != nil && cmp(keyset(item), *opts.After) <= 0 {
+			continue
+		}
+		result = append(result, item)
+		if opts.Limit > 0 && len(result) == opts.Limit {
+			break
+		}
+	}
+	return result
+}
+
+// MemoryUserRepository is an in-process UserRepository for tests and local
+// development. It is safe for concurrent use.
+type MemoryUserRepository struct {
+	mu      sync.Mutex
+	users   map[int]models.User
+	deleted map[int]bool
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: map[int]models.User{}, deleted: map[int]bool{}}
+}
+
+func (r *MemoryUserRepository) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[user.UserID]; ok {
+		return fmt.Errorf("user %d already exists", user.UserID)
+	}
+	user.Version = 1
+	r.users[user.UserID] = *user
+	return nil
+}
+
+func (r *MemoryUserRepository) Get(ctx context.Context, userID int) (models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userID]
+	if !ok || r.deleted[userID] {
+		return models.User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (r *MemoryUserRepository) GetByUsername(ctx context.Context, username string) (models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if user.Username == username && !r.deleted[user.UserID] {
+			return user, nil
+		}
+	}
+	return models.User{}, ErrNotFound
+}
+
+func (r *MemoryUserRepository) List(ctx context.Context, opts ListOptions) ([]models.User, error) {
+	spec, ok := userSorts[opts.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sort %q", opts.Sort)
+	}
+
+	r.mu.Lock()
+	users := make([]models.User, 0, len(r.users))
+	for _, user := range r.users {
+		if !r.deleted[user.UserID] {
+			users = append(users, user)
+		}
+	}
+	r.mu.Unlock()
+
+	return paginateMemory(users, spec, opts, UserKeyset), nil
+}
+
+func (r *MemoryUserRepository) Update(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.users[user.UserID]
+	if !ok || r.deleted[user.UserID] {
+		return ErrNotFound
+	}
+	if user.Version != 0 && user.Version != existing.Version {
+		return ErrStale
+	}
+	user.Version = existing.Version + 1
+	r.users[user.UserID] = *user
+	return nil
+}
+
+func (r *MemoryUserRepository) SoftDelete(ctx context.Context, userID int, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userID]
+	if !ok || r.deleted[userID] {
+		return ErrNotFound
+	}
+	if version != 0 && version != user.Version {
+		return ErrStale
+	}
+	r.deleted[userID] = true
+	return nil
+}
+
+func (r *MemoryUserRepository) Delete(ctx context.Context, userID int, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	if version != 0 && version != user.Version {
+		return ErrStale
+	}
+	delete(r.users, userID)
+	delete(r.deleted, userID)
+	return nil
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/1karp/ads_api/internal/app/models"
+)
+
+// MemoryChannelRepository is an in-process ChannelRepository. It is safe
+// for concurrent use.
+type MemoryChannelRepository struct {
+	mu       sync.Mutex
+	channels map[string]models.Channel
+}
+
+func NewMemoryChannelRepository(channels ...models.Channel) *MemoryChannelRepository {
+	r := &MemoryChannelRepository{channels: map[string]models.Channel{}}
+	for _, c := range channels {
+		r.channels[c.ID] = c
+	}
+	return r
+}
+
+func (r *MemoryChannelRepository) List(ctx context.Context) ([]models.Channel, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	channels := make([]models.Channel, 0, len(r.channels))
+	for _, c := range r.channels {
+		channels = append(channels, c)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].ID < channels[j].ID })
+	return channels, nil
+}
+
+func (r *MemoryChannelRepository) Get(ctx context.Context, id string) (models.Channel, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.channels[id]
+	if !ok {
+		return models.Channel{}, ErrNotFound
+	}
+	return c, nil
+}
+
+func (r *MemoryChannelRepository) Save(ctx context.Context, c models.Channel) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, exists := r.channels[c.ID]
+	r.channels[c.ID] = c
+	return !exists, nil
+}
+
+func (r *MemoryChannelRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.channels[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.channels, id)
+	return nil
+}
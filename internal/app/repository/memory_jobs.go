@@ -0,0 +1,271 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+)
+
+// MemoryJobRepository is an in-process JobRepository. It shares state with
+// a MemoryAdRepository so completing a job updates the ad as the Postgres
+// transaction would.
+type MemoryJobRepository struct {
+	mu          sync.Mutex
+	ads         *MemoryAdRepository
+	jobs        map[int]*models.Job
+	lockedUntil map[int]time.Time
+	nextID      int
+}
+
+func NewMemoryJobRepository(ads *MemoryAdRepository) *MemoryJobRepository {
+	return &MemoryJobRepository{
+		ads:         ads,
+		jobs:        map[int]*models.Job{},
+		lockedUntil: map[int]time.Time{},
+		nextID:      1,
+	}
+}
+
+func isActive(job *models.Job) bool {
+	return job.Status == models.JobPending || job.Status == models.JobRunning
+}
+
+func (r *MemoryJobRepository) Enqueue(ctx context.Context, job *models.Job, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.ads.Get(ctx, job.AdID); err != nil {
+		return err
+	}
+	for _, existing := range r.jobs {
+		if existing.AdID == job.AdID && existing.Kind == job.Kind && existing.ChannelID == job.ChannelID && isActive(existing) {
+			return ErrConflict
+		}
+	}
+
+	job.ID = r.nextID
+	r.nextID++
+	if job.Status == "" {
+		job.Status = models.JobPending
+	}
+	if job.NextAttemptAt.IsZero() {
+		job.NextAttemptAt = now
+	}
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	stored := *job
+	r.jobs[job.ID] = &stored
+	return nil
+}
+
+func (r *MemoryJobRepository) Claim(ctx context.Context, now time.Time, lease time.Duration) (models.Job, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var due []*models.Job
+	for _, job := range r.jobs {
+		pendingDue := job.Status == models.JobPending && !job.NextAttemptAt.After(now)
+		leaseExpired := job.Status == models.JobRunning && r.lockedUntil[job.ID].Before(now)
+		if pendingDue || leaseExpired {
+			due = append(due, job)
+		}
+	}
+	if len(due) == 0 {
+		return models.Job{}, false, nil
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		}
+		return due[i].ID < due[j].ID
+	})
+
+	job := due[0]
+	job.Status = models.JobRunning
+	job.Attempts++
+	job.UpdatedAt = now
+	r.lockedUntil[job.ID] = now.Add(lease)
+	return *job, true, nil
+}
+
+func (r *MemoryJobRepository) finish(jobID int, status models.JobStatus, lastErr string, now time.Time) error {
+	job, ok := r.jobs[jobID]
+	if !ok {
+		return ErrNotFound
+	}
+	job.Status = status
+	job.LastError = lastErr
+	job.UpdatedAt = now
+	job.CompletedAt = &now
+	delete(r.lockedUntil, jobID)
+	return nil
+}
+
+func (r *MemoryJobRepository) CompletePublish(ctx context.Context, jobID int, adID int, messages []models.TelegramMessage, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.jobs[jobID]; !ok {
+		return ErrNotFound
+	}
+	if err := r.ads.SetMessages(ctx, adID, messages); err != nil {
+		return err
+	}
+	if err := r.ads.startListing(adID, now); err != nil {
+		return err
+	}
+	return r.finish(jobID, models.JobSucceeded, "", now)
+}
+
+func (r *MemoryJobRepository) CompleteUnpublish(ctx context.Context, jobID int, adID int, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.jobs[jobID]; !ok {
+		return ErrNotFound
+	}
+	if err := r.ads.SetMessages(ctx, adID, nil); err != nil {
+		return err
+	}
+	return r.finish(jobID, models.JobSucceeded, "", now)
+}
+
+func (r *MemoryJobRepository) CompleteBump(ctx context.Context, jobID int, adID int, messages []models.TelegramMessage, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.jobs[jobID]; !ok {
+		return ErrNotFound
+	}
+	if err := r.ads.SetMessages(ctx, adID, messages); err != nil {
+		return err
+	}
+	if err := r.ads.setListedAt(adID, now); err != nil {
+		return err
+	}
+	return r.finish(jobID, models.JobSucceeded, "", now)
+}
+
+func (r *MemoryJobRepository) Complete(ctx context.Context, jobID int, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.finish(jobID, models.JobSucceeded, "", now)
+}
+
+func (r *MemoryJobRepository) Retry(ctx context.Context, jobID int, nextAttemptAt time.Time, lastErr string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.jobs[jobID]
+	if !ok {
+		return ErrNotFound
+	}
+	job.Status = models.JobPending
+	job.NextAttemptAt = nextAttemptAt
+	job.LastError = lastErr
+	job.UpdatedAt = now
+	delete(r.lockedUntil, jobID)
+	return nil
+}
+
+func (r *MemoryJobRepository) Fail(ctx context.Context, jobID int, lastErr string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.finish(jobID, models.JobFailed, lastErr, now)
+}
+
+func (r *MemoryJobRepository) Defer(ctx context.Context, jobID int, at time.Time, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.jobs[jobID]
+	if !ok {
+		return ErrNotFound
+	}
+	job.Status = models.JobPending
+	job.Attempts--
+	job.NextAttemptAt = at
+	job.UpdatedAt = now
+	delete(r.lockedUntil, jobID)
+	return nil
+}
+
+func (r *MemoryJobRepository) ListByAd(ctx context.Context, adID int) ([]models.Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	jobs := []models.Job{}
+	for _, job := range r.jobs {
+		if job.AdID == adID {
+			jobs = append(jobs, *job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs, nil
+}
+
+func isQueued(job *models.Job) bool {
+	return job.Kind == models.JobPublish && job.Status == models.JobPending
+}
+
+func (r *MemoryJobRepository) ListQueue(ctx context.Context, channelID string) ([]models.Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	jobs := []models.Job{}
+	for _, job := range r.jobs {
+		if isQueued(job) && (channelID == "" || job.ChannelID == channelID) {
+			jobs = append(jobs, *job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].NextAttemptAt.Equal(jobs[j].NextAttemptAt) {
+			return jobs[i].NextAttemptAt.Before(jobs[j].NextAttemptAt)
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+	return jobs, nil
+}
+
+func (r *MemoryJobRepository) Reschedule(ctx context.Context, times map[int]time.Time, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id := range times {
+		if job, ok := r.jobs[id]; !ok || !isQueued(job) {
+			return ErrConflict
+		}
+	}
+	for id, at := range times {
+		r.jobs[id].NextAttemptAt = at
+		r.jobs[id].UpdatedAt = now
+	}
+	return nil
+}
+
+func (r *MemoryJobRepository) Cancel(ctx context.Context, jobID int, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if job, ok := r.jobs[jobID]; !ok || !isQueued(job) {
+		return ErrNotFound
+	}
+	return r.finish(jobID, models.JobCancelled, "", now)
+}
+
+func (r *MemoryJobRepository) LastPublished(ctx context.Context, channelID string) (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var last time.Time
+	for _, job := range r.jobs {
+		if job.Kind == models.JobPublish && job.Status == models.JobSucceeded && job.ChannelID == channelID && job.CompletedAt.After(last) {
+			last = *job.CompletedAt
+		}
+	}
+	return last, nil
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+)
+
+// MemoryTransitionRepository is an in-process TransitionRepository backed
+// by the memory ad and job repositories.
+type MemoryTransitionRepository struct {
+	mu          sync.Mutex
+	ads         *MemoryAdRepository
+	jobs        *MemoryJobRepository
+	transitions []models.AdTransition
+}
+
+func NewMemoryTransitionRepository(ads *MemoryAdRepository, jobs *MemoryJobRepository) *MemoryTransitionRepository {
+	return &MemoryTransitionRepository{ads: ads, jobs: jobs}
+}
+
+func (r *MemoryTransitionRepository) Transition(ctx context.Context, t *models.AdTransition, effects []models.Job, now time.Time) ([]models.Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.ads.setState(t.AdID, t.From, t.To, t.Version); err == ErrNotFound {
+		return nil, ErrConflict
+	} else if err != nil {
+		return nil, err
+	}
+
+	t.ID = len(r.transitions) + 1
+	t.CreatedAt = now
+	r.transitions = append(r.transitions, *t)
+
+	enqueued := []models.Job{}
+	for _, effect := range effects {
+		job := models.Job{AdID: t.AdID, Kind: effect.Kind, ChannelID: effect.ChannelID, NextAttemptAt: effect.NextAttemptAt, PublishAt: effect.PublishAt}
+		err := r.jobs.Enqueue(ctx, &job, now)
+		if errors.Is(err, ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		enqueued = append(enqueued, job)
+	}
+	return enqueued, nil
+}
+
+func (r *MemoryTransitionRepository) ListTransitions(ctx context.Context, adID int) ([]models.AdTransition, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	transitions := []models.AdTransition{}
+	for _, t := range r.transitions {
+		if t.AdID == adID {
+			transitions = append(transitions, t)
+		}
+	}
+	return transitions, nil
+}
@@ -0,0 +1,410 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/lib/pq"
+)
+
+const adColumns = "id, user_id, COALESCE(username, ''), COALESCE(rooms, ''), COALESCE(price, 0), COALESCE(type, ''), COALESCE(area, 0), COALESCE(building, ''), COALESCE(district, ''), COALESCE(text, ''), created_at, COALESCE(is_posted, FALSE), COALESCE(chat_message_id, 0), state, version, listed_at"
+
+type PostgresAdRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresAdRepository(db *sql.DB) *PostgresAdRepository {
+	return &PostgresAdRepository{db: db}
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAd(row rowScanner) (models.Ad, error) {
+	var ad models.Ad
+	var isPosted bool
+	var listedAt sql.NullTime
+	err := row.Scan(&ad.ID, &ad.UserID, &ad.Username, &ad.Rooms, &ad.Price, &ad.Type, &ad.Area, &ad.Building, &ad.District, &ad.Text, &ad.CreatedAt, &isPosted, &ad.ChatMessageId, &ad.State, &ad.Version, &listedAt)
+	if isPosted {
+		ad.IsPosted = 1
+	}
+	if listedAt.Valid {
+		ad.ListedAt = &listedAt.Time
+	}
+	return ad, err
+}
+
+func (r *PostgresAdRepository) Create(ctx context.Context, ad *models.Ad) error {
+	if ad.State == "" {
+		ad.State = models.AdDraft
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO ads (user_id, username, rooms, price, type, area, building, district, text, is_posted, chat_message_id, state) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id, created_at, version",
+		ad.UserID, ad.Username, ad.Rooms, ad.Price, ad.Type, ad.Area, ad.Building, ad.District, ad.Text, ad.IsPosted != 0, ad.ChatMessageId, ad.State,
+	).Scan(&ad.ID, &ad.CreatedAt, &ad.Version)
+	if err != nil {
+		return err
+	}
+	photos, err := savePhotos(ctx, tx, ad.ID, models.Photos{}.Merge(ad.Photos))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	ad.Photos = photos
+	return nil
+}
+
+func (r *PostgresAdRepository) Get(ctx context.Context, id int) (models.Ad, error) {
+	ad, err := scanAd(r.db.QueryRowContext(ctx, "SELECT "+adColumns+" FROM ads WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return ad, ErrNotFound
+	}
+	if err != nil {
+		return ad, err
+	}
+	ads := []models.Ad{ad}
+	err = r.attachChildren(ctx, ads)
+	return ads[0], err
+}
+
+// attachChildren loads the photos and posted albums of ads.
+func (r *PostgresAdRepository) attachChildren(ctx context.Context, ads []models.Ad) error {
+	if err := r.attachPhotos(ctx, ads); err != nil {
+		return err
+	}
+	return r.attachMessages(ctx, ads)
+}
+
+// attachMessages loads the posted albums of ads with one query.
+func (r *PostgresAdRepository) attachMessages(ctx context.Context, ads []models.Ad) error {
+	if len(ads) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(ads))
+	index := make(map[int]int, len(ads))
+	for i, ad := range ads {
+		ids[i] = int64(ad.ID)
+		index[ad.ID] = i
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT ad_id, channel_id, message_id, position, COALESCE(media_file_id, ''), COALESCE(photo_url, ''), COALESCE(caption, ''), is_reply FROM telegram_messages WHERE ad_id = ANY($1) ORDER BY ad_id, channel_id, position",
+		pq.Array(ids),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var adID int
+		var m models.TelegramMessage
+		if err := rows.Scan(&adID, &m.ChannelID, &m.MessageID, &m.Position, &m.FileID, &m.PhotoURL, &m.Caption, &m.Reply); err != nil {
+			return err
+		}
+		i := index[adID]
+		ads[i].Messages = append(ads[i].Messages, m)
+	}
+	return rows.Err()
+}
+
+func (r *PostgresAdRepository) List(ctx context.Context, filter AdFilter, opts ListOptions) ([]models.Ad, error) {
+	spec, ok := adSorts[opts.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sort %q", opts.Sort)
+	}
+
+	conds, args := adFilterConditions(filter)
+	clause, args := listClause(conds, args, spec, opts, "id")
+	query := "SELECT " + adColumns + " FROM ads" + clause
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ads []models.Ad
+	for rows.Next() {
+		ad, err := scanAd(rows)
+		if err != nil {
+			return nil, err
+		}
+		ads = append(ads, ad)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ads, r.attachChildren(ctx, ads)
+}
+
+// Update replaces the editable fields of ad, including its photos. The
+// state is changed only through transitions and the Telegram post only
+// when its messages are recorded; the stored ones are returned in ad.
+func (r *PostgresAdRepository) Update(ctx context.Context, ad *models.Ad) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var isPosted bool
+	err = tx.QueryRowContext(ctx,
+		"UPDATE ads SET user_id = $1, username = $2, rooms = $3, price = $4, type = $5, area = $6, building = $7, district = $8, text = $9, version = version + 1 WHERE id = $10 AND ($11 = 0 OR version = $11) RETURNING state, version, COALESCE(is_posted, FALSE), COALESCE(chat_message_id, 0)",
+		ad.UserID, ad.Username, ad.Rooms, ad.Price, ad.Type, ad.Area, ad.Building, ad.District, ad.Text, ad.ID, ad.Version,
+	).Scan(&ad.State, &ad.Version, &isPosted, &ad.ChatMessageId)
+	if err == sql.ErrNoRows {
+		return staleOrNotFound(ctx, tx, "SELECT 1 FROM ads WHERE id = $1", ad.ID, ad.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	existing, err := lockPhotos(ctx, tx, ad.ID)
+	if err != nil {
+		return err
+	}
+	photos, err := savePhotos(ctx, tx, ad.ID, existing.Merge(ad.Photos))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	ad.IsPosted = 0
+	if isPosted {
+		ad.IsPosted = 1
+	}
+	ad.Photos = photos
+	return nil
+}
+
+func (r *PostgresAdRepository) SetMessages(ctx context.Context, id int, messages []models.TelegramMessage) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceMessages(ctx, tx, id, messages); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// replaceMessages records messages as the ad's albums in their channels,
+// marking the ad as posted, or as not posted anywhere when there are none.
+func replaceMessages(ctx context.Context, tx *sql.Tx, adID int, messages []models.TelegramMessage) error {
+	if len(messages) == 0 {
+		res, err := tx.ExecContext(ctx, "UPDATE ads SET is_posted = FALSE, chat_message_id = NULL, version = version + 1 WHERE id = $1", adID)
+		if err := checkAffected(res, err); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM telegram_messages WHERE ad_id = $1", adID)
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, "UPDATE ads SET is_posted = TRUE, version = version + 1 WHERE id = $1", adID)
+	if err := checkAffected(res, err); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM telegram_messages WHERE ad_id = $1 AND channel_id = ANY($2)", adID, pq.Array(channelsOf(messages))); err != nil {
+		return err
+	}
+	for _, m := range messages {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO telegram_messages (ad_id, channel_id, message_id, position, media_file_id, photo_url, caption, is_reply) VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), $8)",
+			adID, m.ChannelID, m.MessageID, m.Position, m.FileID, m.PhotoURL, m.Caption, m.Reply,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE ads SET chat_message_id = (SELECT message_id FROM telegram_messages WHERE ad_id = $1 ORDER BY channel_id, position LIMIT 1) WHERE id = $1",
+		adID,
+	)
+	return err
+}
+
+// channelsOf returns the distinct channels of messages, in order.
+func channelsOf(messages []models.TelegramMessage) []string {
+	var channels []string
+	for _, m := range messages {
+		if !slices.Contains(channels, m.ChannelID) {
+			channels = append(channels, m.ChannelID)
+		}
+	}
+	return channels
+}
+
+// AdoptLegacyMessages records channelID on the messages posted before
+// their channel was recorded, which all went to the then only channel.
+func (r *PostgresAdRepository) AdoptLegacyMessages(ctx context.Context, channelID string) (int64, error) {
+	res, err := r.db.ExecContext(ctx, "UPDATE telegram_messages SET channel_id = $1 WHERE channel_id = ''", channelID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *PostgresAdRepository) Delete(ctx context.Context, id int, version int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM ads WHERE id = $1 AND ($2 = 0 OR version = $2)", id, version)
+	if err := checkAffected(res, err); err == ErrNotFound {
+		return staleOrNotFound(ctx, tx, "SELECT 1 FROM ads WHERE id = $1", id, version)
+	} else if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// staleOrNotFound explains why a conditional update matched no row: the
+// row named by query is gone, or it has moved past version.
+func staleOrNotFound(ctx context.Context, tx *sql.Tx, query string, id, version int) error {
+	if version == 0 {
+		return ErrNotFound
+	}
+	var exists int
+	err := tx.QueryRowContext(ctx, query, id).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return ErrStale
+}
+
+// bumpVersion marks a write to the ad's photos or messages, which live
+// in tables of their own, as a new version of the ad.
+func bumpVersion(ctx context.Context, tx *sql.Tx, adID int) error {
+	_, err := tx.ExecContext(ctx, "UPDATE ads SET version = version + 1 WHERE id = $1", adID)
+	return err
+}
+
+func checkAffected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func adFilterConditions(f AdFilter) ([]string, []interface{}) {
+	var conds []string
+	var args []interface{}
+
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.UserID != nil {
+		add("user_id = $%d", *f.UserID)
+	}
+	if f.MinPrice != nil {
+		add("price >= $%d", *f.MinPrice)
+	}
+	if f.MaxPrice != nil {
+		add("price <= $%d", *f.MaxPrice)
+	}
+	if len(f.Rooms) > 0 {
+		add("rooms = ANY($%d)", pq.Array(f.Rooms))
+	}
+	if len(f.Types) > 0 {
+		add("type = ANY($%d)", pq.Array(f.Types))
+	}
+	if f.District != "" {
+		add("LOWER(district) = LOWER($%d)", f.District)
+	}
+	if f.MinArea != nil {
+		add("area >= $%d", *f.MinArea)
+	}
+	if f.MaxArea != nil {
+		add("area <= $%d", *f.MaxArea)
+	}
+	if len(f.States) > 0 {
+		add("state = ANY($%d)", pq.Array(f.States))
+	}
+	if f.IsPosted != nil {
+		add("is_posted = $%d", *f.IsPosted)
+	}
+	if f.CreatedAfter != nil {
+		add("created_at > $%d", *f.CreatedAfter)
+	}
+	if f.ListedBefore != nil {
+		add("listed_at < $%d", *f.ListedBefore)
+	}
+	if !f.IncludeDeleted {
+		conds = append(conds, "state <> 'deleted'")
+	}
+
+	return conds, args
+}
+
+// listClause renders the WHERE, ORDER BY and LIMIT clauses of a listing
+// query, appending the keyset and limit arguments to args.
+func listClause(conds []string, args []interface{}, spec sortSpec, opts ListOptions, idColumn string) (string, []interface{}) {
+	cmp, dir := ">", "ASC"
+	if spec.desc {
+		cmp, dir = "<", "DESC"
+	}
+
+	if opts.After != nil {
+		if spec.column == "" {
+			args = append(args, opts.After.ID)
+			conds = append(conds, fmt.Sprintf("%s %s $%d", idColumn, cmp, len(args)))
+		} else {
+			cast := "timestamp"
+			if spec.numeric {
+				cast = "integer"
+			}
+			args = append(args, opts.After.Value, opts.After.ID)
+			conds = append(conds, fmt.Sprintf("(%s, %s) %s ($%d::%s, $%d)", spec.column, idColumn, cmp, len(args)-1, cast, len(args)))
+		}
+	}
+
+	clause := ""
+	if len(conds) > 0 {
+		clause = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	if spec.column == "" {
+		clause += fmt.Sprintf(" ORDER BY %s %s", idColumn, dir)
+	} else {
+		clause += fmt.Sprintf(" ORDER BY %s %s, %s %s", spec.column, dir, idColumn, dir)
+	}
+
+	if opts.Limit > 0 {
+		args = append(args, opts.Limit)
+		clause += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	return clause, args
+}
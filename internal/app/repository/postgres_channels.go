@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/lib/pq"
+)
+
+const channelColumns = "id, name, districts, types, min_price, max_price, disabled"
+
+type PostgresChannelRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresChannelRepository(db *sql.DB) *PostgresChannelRepository {
+	return &PostgresChannelRepository{db: db}
+}
+
+func scanChannel(row rowScanner) (models.Channel, error) {
+	var c models.Channel
+	var minPrice, maxPrice sql.NullInt64
+	err := row.Scan(&c.ID, &c.Name, pq.Array(&c.Districts), pq.Array(&c.Types), &minPrice, &maxPrice, &c.Disabled)
+	c.MinPrice, c.MaxPrice = nullIntPtr(minPrice), nullIntPtr(maxPrice)
+	if c.Districts == nil {
+		c.Districts = []string{}
+	}
+	if c.Types == nil {
+		c.Types = []string{}
+	}
+	return c, err
+}
+
+func nullIntPtr(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	i := int(v.Int64)
+	return &i
+}
+
+func (r *PostgresChannelRepository) List(ctx context.Context) ([]models.Channel, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+channelColumns+" FROM channels ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channels := []models.Channel{}
+	for rows.Next() {
+		c, err := scanChannel(rows)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, c)
+	}
+	return channels, rows.Err()
+}
+
+func (r *PostgresChannelRepository) Get(ctx context.Context, id string) (models.Channel, error) {
+	c, err := scanChannel(r.db.QueryRowContext(ctx, "SELECT "+channelColumns+" FROM channels WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return c, ErrNotFound
+	}
+	return c, err
+}
+
+func (r *PostgresChannelRepository) Save(ctx context.Context, c models.Channel) (bool, error) {
+	// xmax is only set on rows that existed before the upsert.
+	var created bool
+	err := r.db.QueryRowContext(ctx, `
+		INSERT INTO channels (id, name, districts, types, min_price, max_price, disabled)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (id) DO UPDATE SET
+			name = EXCLUDED.name, districts = EXCLUDED.districts, types = EXCLUDED.types,
+			min_price = EXCLUDED.min_price, max_price = EXCLUDED.max_price, disabled = EXCLUDED.disabled
+		RETURNING xmax = 0`,
+		c.ID, c.Name, pq.Array(nonNil(c.Districts)), pq.Array(nonNil(c.Types)), c.MinPrice, c.MaxPrice, c.Disabled,
+	).Scan(&created)
+	return created, err
+}
+
+// nonNil stores missing lists as empty arrays rather than NULL.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func (r *PostgresChannelRepository) Delete(ctx context.Context, id string) error {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM channels WHERE id = $1", id)
+	return checkAffected(res, err)
+}
@@ -0,0 +1,227 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/lib/pq"
+)
+
+const jobColumns = "id, ad_id, kind, channel_id, status, attempts, next_attempt_at, publish_at, COALESCE(last_error, ''), created_at, updated_at, completed_at"
+
+type PostgresJobRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresJobRepository(db *sql.DB) *PostgresJobRepository {
+	return &PostgresJobRepository{db: db}
+}
+
+func scanJob(row rowScanner) (models.Job, error) {
+	var job models.Job
+	var publishAt, completedAt sql.NullTime
+	err := row.Scan(&job.ID, &job.AdID, &job.Kind, &job.ChannelID, &job.Status, &job.Attempts, &job.NextAttemptAt, &publishAt, &job.LastError, &job.CreatedAt, &job.UpdatedAt, &completedAt)
+	if publishAt.Valid {
+		job.PublishAt = &publishAt.Time
+	}
+	if completedAt.Valid {
+		job.CompletedAt = &completedAt.Time
+	}
+	return job, err
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func (r *PostgresJobRepository) Enqueue(ctx context.Context, job *models.Job, now time.Time) error {
+	if job.Status == "" {
+		job.Status = models.JobPending
+	}
+	row := r.db.QueryRowContext(ctx,
+		"INSERT INTO outbox_jobs (ad_id, kind, channel_id, status, next_attempt_at, publish_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING "+jobColumns,
+		job.AdID, job.Kind, job.ChannelID, job.Status, dueAt(job.NextAttemptAt, now), job.PublishAt,
+	)
+	created, err := scanJob(row)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+	*job = created
+	return nil
+}
+
+// dueAt is when a new job is first attempted: at, or now when unset.
+func dueAt(at, now time.Time) time.Time {
+	if at.IsZero() {
+		return now
+	}
+	return at
+}
+
+func (r *PostgresJobRepository) Claim(ctx context.Context, now time.Time, lease time.Duration) (models.Job, bool, error) {
+	row := r.db.QueryRowContext(ctx, `
+		UPDATE outbox_jobs SET status = 'running', attempts = attempts + 1, locked_until = $2, updated_at = $1
+		WHERE id = (
+			SELECT id FROM outbox_jobs
+			WHERE (status = 'pending' AND next_attempt_at <= $1)
+			   OR (status = 'running' AND locked_until < $1)
+			ORDER BY next_attempt_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns,
+		now, now.Add(lease),
+	)
+	job, err := scanJob(row)
+	if err == sql.ErrNoRows {
+		return job, false, nil
+	}
+	if err != nil {
+		return job, false, err
+	}
+	return job, true, nil
+}
+
+func (r *PostgresJobRepository) CompletePublish(ctx context.Context, jobID int, adID int, messages []models.TelegramMessage, now time.Time) error {
+	if len(messages) == 0 {
+		return fmt.Errorf("no messages to record for ad %d", adID)
+	}
+	return r.completeWithMessages(ctx, jobID, adID, messages, now, "UPDATE ads SET listed_at = $1 WHERE id = $2 AND state = 'published' AND listed_at IS NULL")
+}
+
+func (r *PostgresJobRepository) CompleteUnpublish(ctx context.Context, jobID int, adID int, now time.Time) error {
+	return r.completeWithMessages(ctx, jobID, adID, nil, now, "")
+}
+
+func (r *PostgresJobRepository) CompleteBump(ctx context.Context, jobID int, adID int, messages []models.TelegramMessage, now time.Time) error {
+	if len(messages) == 0 {
+		return fmt.Errorf("no messages to record for ad %d", adID)
+	}
+	return r.completeWithMessages(ctx, jobID, adID, messages, now, "UPDATE ads SET listed_at = $1 WHERE id = $2")
+}
+
+// completeWithMessages records messages and marks the job as succeeded.
+// listing, when not empty, is run in between with now and adID to update
+// when the ad was listed.
+func (r *PostgresJobRepository) completeWithMessages(ctx context.Context, jobID int, adID int, messages []models.TelegramMessage, now time.Time, listing string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceMessages(ctx, tx, adID, messages); err != nil {
+		return err
+	}
+	if listing != "" {
+		if _, err := tx.ExecContext(ctx, listing, now, adID); err != nil {
+			return err
+		}
+	}
+	if err := succeed(ctx, tx, jobID, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// succeed marks a job as succeeded within tx.
+func succeed(ctx context.Context, tx *sql.Tx, jobID int, now time.Time) error {
+	res, err := tx.ExecContext(ctx, "UPDATE outbox_jobs SET status = 'succeeded', locked_until = NULL, last_error = NULL, updated_at = $1, completed_at = $1 WHERE id = $2", now, jobID)
+	return checkAffected(res, err)
+}
+
+func (r *PostgresJobRepository) Complete(ctx context.Context, jobID int, now time.Time) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE outbox_jobs SET status = 'succeeded', locked_until = NULL, last_error = NULL, updated_at = $1, completed_at = $1 WHERE id = $2", now, jobID)
+	return checkAffected(res, err)
+}
+
+func (r *PostgresJobRepository) Retry(ctx context.Context, jobID int, nextAttemptAt time.Time, lastErr string, now time.Time) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE outbox_jobs SET status = 'pending', locked_until = NULL, next_attempt_at = $1, last_error = $2, updated_at = $3 WHERE id = $4", nextAttemptAt, lastErr, now, jobID)
+	return checkAffected(res, err)
+}
+
+func (r *PostgresJobRepository) Fail(ctx context.Context, jobID int, lastErr string, now time.Time) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE outbox_jobs SET status = 'failed', locked_until = NULL, last_error = $1, updated_at = $2, completed_at = $2 WHERE id = $3", lastErr, now, jobID)
+	return checkAffected(res, err)
+}
+
+func (r *PostgresJobRepository) Defer(ctx context.Context, jobID int, at time.Time, now time.Time) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE outbox_jobs SET status = 'pending', attempts = attempts - 1, locked_until = NULL, next_attempt_at = $1, updated_at = $2 WHERE id = $3", at, now, jobID)
+	return checkAffected(res, err)
+}
+
+func (r *PostgresJobRepository) ListByAd(ctx context.Context, adID int) ([]models.Job, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+jobColumns+" FROM outbox_jobs WHERE ad_id = $1 ORDER BY id", adID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []models.Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func (r *PostgresJobRepository) ListQueue(ctx context.Context, channelID string) ([]models.Job, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT "+jobColumns+" FROM outbox_jobs WHERE kind = 'publish' AND status = 'pending' AND ($1 = '' OR channel_id = $1) ORDER BY next_attempt_at, id",
+		channelID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []models.Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func (r *PostgresJobRepository) Reschedule(ctx context.Context, times map[int]time.Time, now time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for id, at := range times {
+		res, err := tx.ExecContext(ctx, "UPDATE outbox_jobs SET next_attempt_at = $1, updated_at = $2 WHERE id = $3 AND kind = 'publish' AND status = 'pending'", at, now, id)
+		if err := checkAffected(res, err); err == ErrNotFound {
+			return ErrConflict
+		} else if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresJobRepository) Cancel(ctx context.Context, jobID int, now time.Time) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE outbox_jobs SET status = 'cancelled', updated_at = $1, completed_at = $1 WHERE id = $2 AND kind = 'publish' AND status = 'pending'", now, jobID)
+	return checkAffected(res, err)
+}
+
+func (r *PostgresJobRepository) LastPublished(ctx context.Context, channelID string) (time.Time, error) {
+	var last sql.NullTime
+	err := r.db.QueryRowContext(ctx, "SELECT MAX(completed_at) FROM outbox_jobs WHERE kind = 'publish' AND status = 'succeeded' AND channel_id = $1", channelID).Scan(&last)
+	return last.Time, err
+}
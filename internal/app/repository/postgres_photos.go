@@ -0,0 +1,192 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/lib/pq"
+)
+
+const photoColumns = "id, position, url, COALESCE(width, 0), COALESCE(height, 0), COALESCE(caption, ''), COALESCE(large_url, ''), COALESCE(thumbnail_url, '')"
+
+func scanPhoto(row rowScanner, dest ...interface{}) (models.Photo, error) {
+	var p models.Photo
+	err := row.Scan(append(dest, &p.ID, &p.Position, &p.URL, &p.Width, &p.Height, &p.Caption, &p.Large, &p.Thumbnail)...)
+	return p, err
+}
+
+// attachPhotos loads the photos of ads with one query.
+func (r *PostgresAdRepository) attachPhotos(ctx context.Context, ads []models.Ad) error {
+	if len(ads) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(ads))
+	index := make(map[int]int, len(ads))
+	for i := range ads {
+		ids[i] = int64(ads[i].ID)
+		index[ads[i].ID] = i
+		ads[i].Photos = models.Photos{}
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT ad_id, "+photoColumns+" FROM ad_photos WHERE ad_id = ANY($1) ORDER BY ad_id, position",
+		pq.Array(ids),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var adID int
+		p, err := scanPhoto(rows, &adID)
+		if err != nil {
+			return err
+		}
+		i := index[adID]
+		ads[i].Photos = append(ads[i].Photos, p)
+	}
+	return rows.Err()
+}
+
+// lockPhotos returns the ad's photos, locking them until tx ends.
+func lockPhotos(ctx context.Context, tx *sql.Tx, adID int) (models.Photos, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT "+photoColumns+" FROM ad_photos WHERE ad_id = $1 ORDER BY position FOR UPDATE", adID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	photos := models.Photos{}
+	for rows.Next() {
+		p, err := scanPhoto(rows)
+		if err != nil {
+			return nil, err
+		}
+		photos = append(photos, p)
+	}
+	return photos, rows.Err()
+}
+
+// savePhotos makes photos, as returned by Photos.Merge, the ad's photos:
+// others are deleted, photos with an id updated and new ones inserted.
+// The photos are returned with their ids.
+func savePhotos(ctx context.Context, tx *sql.Tx, adID int, photos models.Photos) (models.Photos, error) {
+	keep := []int64{}
+	for _, p := range photos {
+		if p.ID != 0 {
+			keep = append(keep, int64(p.ID))
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM ad_photos WHERE ad_id = $1 AND NOT (id = ANY($2))", adID, pq.Array(keep)); err != nil {
+		return nil, err
+	}
+
+	saved := make(models.Photos, len(photos))
+	for i, p := range photos {
+		var err error
+		if p.ID != 0 {
+			_, err = tx.ExecContext(ctx,
+				"UPDATE ad_photos SET position = $1, url = $2, width = NULLIF($3, 0), height = NULLIF($4, 0), caption = NULLIF($5, ''), large_url = NULLIF($6, ''), thumbnail_url = NULLIF($7, '') WHERE id = $8",
+				p.Position, p.URL, p.Width, p.Height, p.Caption, p.Large, p.Thumbnail, p.ID,
+			)
+		} else {
+			err = tx.QueryRowContext(ctx,
+				"INSERT INTO ad_photos (ad_id, position, url, width, height, caption, large_url, thumbnail_url) VALUES ($1, $2, $3, NULLIF($4, 0), NULLIF($5, 0), NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, '')) RETURNING id",
+				adID, p.Position, p.URL, p.Width, p.Height, p.Caption, p.Large, p.Thumbnail,
+			).Scan(&p.ID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		saved[i] = p
+	}
+	return saved, nil
+}
+
+func (r *PostgresAdRepository) ReplacePhoto(ctx context.Context, adID int, upload string, photo models.Photo) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE ad_photos SET url = $3, width = NULLIF($4, 0), height = NULLIF($5, 0), large_url = NULLIF($6, ''), thumbnail_url = NULLIF($7, '') WHERE ad_id = $1 AND url = $2",
+		adID, upload, photo.URL, photo.Width, photo.Height, photo.Large, photo.Thumbnail,
+	)
+	if err := checkAffected(res, err); err == ErrNotFound {
+		return ErrConflict
+	} else if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE telegram_messages SET photo_url = $3 WHERE ad_id = $1 AND photo_url = $2", adID, upload, photo.URL); err != nil {
+		return err
+	}
+	if err := bumpVersion(ctx, tx, adID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresAdRepository) ReorderPhotos(ctx context.Context, adID int, ids []int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	order := make([]int64, len(ids))
+	for i, id := range ids {
+		order[i] = int64(id)
+	}
+	res, err := tx.ExecContext(ctx,
+		"UPDATE ad_photos p SET position = o.position - 1 FROM unnest($2::integer[]) WITH ORDINALITY AS o(id, position) WHERE p.id = o.id AND p.ad_id = $1",
+		adID, pq.Array(order),
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if int(n) != len(ids) {
+		return ErrConflict
+	}
+	if err := bumpVersion(ctx, tx, adID); err != nil {
+		return err
+	}
+
+	// The deferred position constraint fails here if ids left a photo
+	// out, e.g. one added concurrently.
+	if err := tx.Commit(); isUniqueViolation(err) {
+		return ErrConflict
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *PostgresAdRepository) DeletePhoto(ctx context.Context, adID int, photoID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var position int
+	err = tx.QueryRowContext(ctx, "DELETE FROM ad_photos WHERE id = $1 AND ad_id = $2 RETURNING position", photoID, adID).Scan(&position)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE ad_photos SET position = position - 1 WHERE ad_id = $1 AND position > $2", adID, position); err != nil {
+		return err
+	}
+	if err := bumpVersion(ctx, tx, adID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
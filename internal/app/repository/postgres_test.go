@@ -0,0 +1,568 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+var photoRowColumns = []string{"ad_id", "id", "position", "url", "width", "height", "caption", "large_url", "thumbnail_url"}
+
+var adRowColumns = []string{"id", "user_id", "username", "rooms", "price", "type", "area", "building", "district", "text", "created_at", "is_posted", "chat_message_id", "state", "version", "listed_at"}
+
+var jobRowColumns = []string{"id", "ad_id", "kind", "channel_id", "status", "attempts", "next_attempt_at", "publish_at", "last_error", "created_at", "updated_at", "completed_at"}
+
+func intPtr(v int) *int    { return &v }
+func boolPtr(v bool) *bool { return &v }
+
+func TestPostgresAdRepositoryCreate(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	repo := NewPostgresAdRepository(db)
+
+	ad := models.Ad{UserID: 1, Username: "testuser", Photos: models.LegacyPhotos("photo1.jpg"), Price: 1000, IsPosted: 1}
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO ads").
+		WithArgs(ad.UserID, ad.Username, ad.Rooms, ad.Price, ad.Type, ad.Area, ad.Building, ad.District, ad.Text, true, ad.ChatMessageId, models.AdDraft).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "version"}).AddRow(7, "2023-05-01T00:00:00Z", 1))
+	mock.ExpectExec("DELETE FROM ad_photos").WithArgs(7, pq.Array([]int64{})).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO ad_photos (.+) RETURNING id").WithArgs(7, 0, "photo1.jpg", 0, 0, "", "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	mock.ExpectCommit()
+
+	assert.NoError(t, repo.Create(context.Background(), &ad))
+	assert.Equal(t, 7, ad.ID)
+	assert.Equal(t, 1, ad.Version)
+	assert.Equal(t, models.Photos{{ID: 11, URL: "photo1.jpg"}}, ad.Photos)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresAdRepositoryGet(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	repo := NewPostgresAdRepository(db)
+
+	mock.ExpectQuery("SELECT (.+) FROM ads WHERE id = ?").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(adRowColumns).
+			AddRow(1, 1, "testuser", "2", 1000, "apartment", 50, "modern", "downtown", "Nice", "2023-05-01", true, 42, "published", 3, time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)))
+	mock.ExpectQuery("SELECT (.+) FROM ad_photos WHERE ad_id = ANY").
+		WillReturnRows(sqlmock.NewRows(photoRowColumns).
+			AddRow(1, 11, 0, "photo1.jpg", 1280, 960, "Living room", "photo1-large.jpg", "photo1-thumb.jpg"))
+	mock.ExpectQuery("SELECT (.+) FROM telegram_messages WHERE ad_id = ANY").
+		WillReturnRows(sqlmock.NewRows([]string{"ad_id", "channel_id", "message_id", "position", "media_file_id", "photo_url", "caption", "is_reply"}).
+			AddRow(1, "@channel", 42, 0, "file-42", "https://example.com/1.jpg", "Nice", false).
+			AddRow(1, "@channel", 43, 1, "file-43", "https://example.com/2.jpg", "", false).
+			AddRow(1, "@channel", 44, 2, "", "", "Full text", true))
+
+	ad, err := repo.Get(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, ad.IsPosted)
+	assert.Equal(t, 42, ad.ChatMessageId)
+	assert.Equal(t, models.AdPublished, ad.State)
+	assert.Equal(t, 3, ad.Version)
+	if assert.NotNil(t, ad.ListedAt) {
+		assert.Equal(t, time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC), *ad.ListedAt)
+	}
+	assert.Equal(t, []models.TelegramMessage{
+		{ChannelID: "@channel", MessageID: 42, Position: 0, FileID: "file-42", PhotoURL: "https://example.com/1.jpg", Caption: "Nice"},
+		{ChannelID: "@channel", MessageID: 43, Position: 1, FileID: "file-43", PhotoURL: "https://example.com/2.jpg"},
+		{ChannelID: "@channel", MessageID: 44, Position: 2, Caption: "Full text", Reply: true},
+	}, ad.Messages)
+	assert.Equal(t, models.Photos{{ID: 11, URL: "photo1.jpg", Width: 1280, Height: 960, Caption: "Living room", Large: "photo1-large.jpg", Thumbnail: "photo1-thumb.jpg"}}, ad.Photos)
+
+	mock.ExpectQuery("SELECT (.+) FROM ads WHERE id = ?").WithArgs(2).WillReturnError(sql.ErrNoRows)
+	_, err = repo.Get(context.Background(), 2)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresAdRepositoryList(t *testing.T) {
+	t.Run("Filters Combined With AND", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		repo := NewPostgresAdRepository(db)
+
+		mock.ExpectQuery(`SELECT (.+) FROM ads WHERE user_id = \$1 AND price >= \$2 AND price <= \$3 AND LOWER\(district\) = LOWER\(\$4\) AND area >= \$5 AND is_posted = \$6 AND state <> 'deleted' ORDER BY created_at ASC, id ASC`).
+			WithArgs(1, 50000, 100000, "Marina", 60, false).
+			WillReturnRows(sqlmock.NewRows(adRowColumns))
+
+		filter := AdFilter{UserID: intPtr(1), MinPrice: intPtr(50000), MaxPrice: intPtr(100000), District: "Marina", MinArea: intPtr(60), IsPosted: boolPtr(false)}
+		_, err := repo.List(context.Background(), filter, ListOptions{Sort: "created_at"})
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Keyset Page", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		repo := NewPostgresAdRepository(db)
+
+		mock.ExpectQuery(`SELECT (.+) FROM ads WHERE created_at > \$1 AND state <> 'deleted' AND \(COALESCE\(price, 0\), id\) < \(\$2::integer, \$3\) ORDER BY COALESCE\(price, 0\) DESC, id DESC LIMIT \$4`).
+			WithArgs(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), "90000", 2, 3).
+			WillReturnRows(sqlmock.NewRows(adRowColumns).
+				AddRow(1, 1, "testuser", "2", 50000, "apartment", 50, "modern", "downtown", "Nice", "2024-05-01", false, 0, "draft", 1, nil))
+		mock.ExpectQuery("SELECT (.+) FROM ad_photos").
+			WillReturnRows(sqlmock.NewRows(photoRowColumns))
+		mock.ExpectQuery("SELECT (.+) FROM telegram_messages").
+			WillReturnRows(sqlmock.NewRows([]string{"ad_id", "channel_id", "message_id", "position", "media_file_id", "photo_url", "caption", "is_reply"}))
+
+		created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		ads, err := repo.List(context.Background(), AdFilter{CreatedAfter: &created}, ListOptions{Sort: "-price", Limit: 3, After: &Keyset{Value: "90000", ID: 2}})
+		assert.NoError(t, err)
+		assert.Len(t, ads, 1)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Keyset After Null Price", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		repo := NewPostgresAdRepository(db)
+
+		// An ad without a price is listed with price 0, so its keyset
+		// continues from 0 in the order ads are returned in.
+		mock.ExpectQuery(`SELECT (.+) FROM ads WHERE state <> 'deleted' AND \(COALESCE\(price, 0\), id\) > \(\$1::integer, \$2\) ORDER BY COALESCE\(price, 0\) ASC, id ASC LIMIT \$3`).
+			WithArgs("0", 4, 2).
+			WillReturnRows(sqlmock.NewRows(adRowColumns))
+
+		_, err := repo.List(context.Background(), AdFilter{}, ListOptions{Sort: "price", Limit: 2, After: &Keyset{Value: "0", ID: 4}})
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Listed Before", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		repo := NewPostgresAdRepository(db)
+
+		cutoff := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+		mock.ExpectQuery(`SELECT (.+) FROM ads WHERE state = ANY\(\$1\) AND listed_at < \$2 AND state <> 'deleted' ORDER BY created_at ASC, id ASC`).
+			WithArgs(pq.Array([]string{"published"}), cutoff).
+			WillReturnRows(sqlmock.NewRows(adRowColumns))
+
+		_, err := repo.List(context.Background(), AdFilter{States: []string{"published"}, ListedBefore: &cutoff}, ListOptions{Sort: "created_at"})
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Database Error", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		repo := NewPostgresAdRepository(db)
+
+		mock.ExpectQuery("SELECT (.+) FROM ads").WillReturnError(fmt.Errorf("database error"))
+
+		_, err := repo.List(context.Background(), AdFilter{}, ListOptions{Sort: "created_at"})
+		assert.Error(t, err)
+	})
+}
+
+func TestPostgresAdRepositoryUpdate(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	repo := NewPostgresAdRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE ads SET (.+) RETURNING state, version").WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	err := repo.Update(context.Background(), &models.Ad{ID: 999, UserID: 1})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	t.Run("Stale Version", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`UPDATE ads SET (.+) text = \$9, version = version \+ 1 WHERE id = \$10 AND \(\$11 = 0 OR version = \$11\)`).
+			WithArgs(1, "", "", 0, "", 0, "", "", "", 1, 4).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery("SELECT 1 FROM ads WHERE id = ").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow(1))
+		mock.ExpectRollback()
+
+		assert.ErrorIs(t, repo.Update(context.Background(), &models.Ad{ID: 1, UserID: 1, Version: 4}), ErrStale)
+	})
+
+	// The processed photo keeps its id and variants; b.jpg is new.
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE ads SET (.+) RETURNING state, version").WillReturnRows(sqlmock.NewRows([]string{"state", "version", "is_posted", "chat_message_id"}).AddRow("rented", 5, true, 42))
+	mock.ExpectQuery("SELECT (.+) FROM ad_photos WHERE ad_id = (.+) FOR UPDATE").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(photoRowColumns[1:]).
+			AddRow(11, 0, "a.jpg", 640, 480, "", "a-large.jpg", "a-thumb.jpg").
+			AddRow(12, 1, "c.jpg", 0, 0, "", "", ""))
+	mock.ExpectExec("DELETE FROM ad_photos").WithArgs(1, pq.Array([]int64{11})).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO ad_photos (.+) RETURNING id").WithArgs(1, 0, "b.jpg", 0, 0, "", "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(13))
+	mock.ExpectExec("UPDATE ad_photos SET").WithArgs(1, "a.jpg", 640, 480, "Kitchen", "a-large.jpg", "a-thumb.jpg", 11).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// The post is only recorded by the outbox; the caller's copy is stale.
+	ad := models.Ad{ID: 1, UserID: 1, State: models.AdDraft, Photos: models.Photos{{URL: "b.jpg"}, {URL: "a.jpg", Position: 1, Caption: "Kitchen"}}}
+	assert.NoError(t, repo.Update(context.Background(), &ad))
+	assert.Equal(t, models.AdRented, ad.State)
+	assert.Equal(t, 1, ad.IsPosted)
+	assert.Equal(t, 42, ad.ChatMessageId)
+	assert.Equal(t, 5, ad.Version)
+	assert.Equal(t, models.Photos{
+		{ID: 13, URL: "b.jpg", Position: 0},
+		{ID: 11, URL: "a.jpg", Position: 1, Width: 640, Height: 480, Caption: "Kitchen", Large: "a-large.jpg", Thumbnail: "a-thumb.jpg"},
+	}, ad.Photos)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresAdRepositoryReplacePhoto(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	repo := NewPostgresAdRepository(db)
+
+	photo := models.Photo{URL: "/media/ads/1/a/original.jpg", Width: 640, Height: 480, Large: "/media/ads/1/a/original.jpg", Thumbnail: "/media/ads/1/a/thumb.jpg"}
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE ad_photos SET (.+) WHERE ad_id = \\$1 AND url = \\$2").
+		WithArgs(1, "/media/ads/1/a.png", photo.URL, 640, 480, photo.Large, photo.Thumbnail).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE telegram_messages SET photo_url").WithArgs(1, "/media/ads/1/a.png", photo.URL).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE ads SET version = version \\+ 1").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, repo.ReplacePhoto(context.Background(), 1, "/media/ads/1/a.png", photo))
+
+	t.Run("Photo Removed", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE ad_photos SET").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		assert.ErrorIs(t, repo.ReplacePhoto(context.Background(), 1, "/media/ads/1/a.png", photo), ErrConflict)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresAdRepositoryReorderPhotos(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	repo := NewPostgresAdRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE ad_photos p SET position (.+) WITH ORDINALITY").WithArgs(1, pq.Array([]int64{12, 11})).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE ads SET version = version \\+ 1").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, repo.ReorderPhotos(context.Background(), 1, []int{12, 11}))
+
+	t.Run("Unknown Photo", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE ad_photos p SET position").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectRollback()
+		assert.ErrorIs(t, repo.ReorderPhotos(context.Background(), 1, []int{12, 99}), ErrConflict)
+	})
+
+	t.Run("Photo Left Out", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE ad_photos p SET position").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE ads SET version").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit().WillReturnError(&pq.Error{Code: "23505"})
+		assert.ErrorIs(t, repo.ReorderPhotos(context.Background(), 1, []int{12}), ErrConflict)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresAdRepositoryDeletePhoto(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	repo := NewPostgresAdRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM ad_photos (.+) RETURNING position").WithArgs(11, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"position"}).AddRow(1))
+	mock.ExpectExec("UPDATE ad_photos SET position = position - 1").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE ads SET version = version \\+ 1").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, repo.DeletePhoto(context.Background(), 1, 11))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM ad_photos").WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+	assert.ErrorIs(t, repo.DeletePhoto(context.Background(), 1, 11), ErrNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresUserRepositoryList(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	repo := NewPostgresUserRepository(db)
+
+	mock.ExpectQuery(`SELECT (.+) FROM users WHERE deleted_at IS NULL AND userid > \$1 ORDER BY userid ASC LIMIT \$2`).
+		WithArgs(5, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"userid", "username", "version"}).AddRow(6, "user6", 1).AddRow(7, "user7", 2))
+
+	users, err := repo.List(context.Background(), ListOptions{Sort: "userid", Limit: 2, After: &Keyset{ID: 5}})
+	assert.NoError(t, err)
+	assert.Equal(t, []models.User{{UserID: 6, Username: "user6", Version: 1}, {UserID: 7, Username: "user7", Version: 2}}, users)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresUserRepositoryUpdate(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	repo := NewPostgresUserRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE users SET username = \$1, version = version \+ 1 (.+) RETURNING version`).WithArgs("renamed", 6, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+	mock.ExpectCommit()
+
+	user := models.User{UserID: 6, Username: "renamed", Version: 2}
+	assert.NoError(t, repo.Update(context.Background(), &user))
+	assert.Equal(t, 3, user.Version)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE users SET").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT 1 FROM users").WithArgs(6).WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow(1))
+	mock.ExpectRollback()
+	assert.ErrorIs(t, repo.Update(context.Background(), &models.User{UserID: 6, Version: 2}), ErrStale)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE users SET").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT 1 FROM users").WithArgs(8).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+	assert.ErrorIs(t, repo.Update(context.Background(), &models.User{UserID: 8, Version: 2}), ErrNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresUserRepositoryDelete(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	repo := NewPostgresUserRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET deleted_at (.+) AND \\(\\$2 = 0 OR version = \\$2\\)").WithArgs(6, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, repo.SoftDelete(context.Background(), 6, 2))
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM users").WithArgs(6, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT 1 FROM users").WithArgs(6).WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow(1))
+	mock.ExpectRollback()
+	assert.ErrorIs(t, repo.Delete(context.Background(), 6, 2), ErrStale)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM users").WithArgs(8, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	assert.ErrorIs(t, repo.Delete(context.Background(), 8, 0), ErrNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresJobRepositoryEnqueue(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	repo := NewPostgresJobRepository(db)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("INSERT INTO outbox_jobs").
+		WithArgs(3, models.JobPublish, "", models.JobPending, now, nil).
+		WillReturnError(&pq.Error{Code: "23505"})
+
+	job := models.Job{AdID: 3, Kind: models.JobPublish}
+	assert.ErrorIs(t, repo.Enqueue(context.Background(), &job, now), ErrConflict)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresJobRepositoryQueue(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	repo := NewPostgresJobRepository(db)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	publishAt := now.Add(time.Hour)
+	mock.ExpectQuery("SELECT (.+) FROM outbox_jobs WHERE kind = 'publish' AND status = 'pending'").WithArgs("@channel").
+		WillReturnRows(sqlmock.NewRows(jobRowColumns).AddRow(4, 3, "publish", "@channel", "pending", 0, publishAt, publishAt, "", now, now, nil))
+	jobs, err := repo.ListQueue(context.Background(), "@channel")
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 1) {
+		assert.Equal(t, &publishAt, jobs[0].PublishAt)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE outbox_jobs SET next_attempt_at").WithArgs(publishAt, now, 4).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	assert.ErrorIs(t, repo.Reschedule(context.Background(), map[int]time.Time{4: publishAt}, now), ErrConflict)
+
+	mock.ExpectExec("UPDATE outbox_jobs SET status = 'cancelled'").WithArgs(now, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, repo.Cancel(context.Background(), 4, now))
+
+	mock.ExpectQuery("SELECT MAX\\(completed_at\\)").WithArgs("@channel").WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(nil))
+	last, err := repo.LastPublished(context.Background(), "@channel")
+	assert.NoError(t, err)
+	assert.True(t, last.IsZero())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresJobRepositoryClaim(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	repo := NewPostgresJobRepository(db)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("UPDATE outbox_jobs SET status = 'running'(.+)FOR UPDATE SKIP LOCKED").
+		WithArgs(now, now.Add(time.Minute)).
+		WillReturnError(sql.ErrNoRows)
+
+	_, ok, err := repo.Claim(context.Background(), now, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresJobRepositoryRetry(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	repo := NewPostgresJobRepository(db)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectExec("UPDATE outbox_jobs SET status = 'pending', locked_until = NULL, next_attempt_at = \\$1, last_error = \\$2, updated_at = \\$3").
+		WithArgs(now.Add(time.Minute), "timeout", now, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, repo.Retry(context.Background(), 7, now.Add(time.Minute), "timeout", now))
+
+	mock.ExpectExec("UPDATE outbox_jobs SET status = 'pending', attempts = attempts - 1").
+		WithArgs(now.Add(time.Hour), now, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, repo.Defer(context.Background(), 7, now.Add(time.Hour), now))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresJobRepositoryCompletePublish(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	repo := NewPostgresJobRepository(db)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE ads SET is_posted = TRUE").WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM telegram_messages (.+) ANY").WithArgs(3, pq.Array([]string{"@channel"})).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO telegram_messages").WithArgs(3, "@channel", 100, 0, "file-100", "https://example.com/1.jpg", "Caption", false).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO telegram_messages").WithArgs(3, "@channel", 101, 1, "file-101", "https://example.com/2.jpg", "", false).WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec("INSERT INTO telegram_messages").WithArgs(3, "@channel", 102, 2, "", "", "Full text", true).WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectExec("UPDATE ads SET chat_message_id = \\(SELECT").WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE ads SET listed_at = \\$1 WHERE id = \\$2 AND state = 'published' AND listed_at IS NULL").WithArgs(now, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE outbox_jobs SET status = 'succeeded'").WithArgs(now, 9).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	messages := []models.TelegramMessage{
+		{ChannelID: "@channel", MessageID: 100, Position: 0, FileID: "file-100", PhotoURL: "https://example.com/1.jpg", Caption: "Caption"},
+		{ChannelID: "@channel", MessageID: 101, Position: 1, FileID: "file-101", PhotoURL: "https://example.com/2.jpg"},
+		{ChannelID: "@channel", MessageID: 102, Position: 2, Caption: "Full text", Reply: true},
+	}
+	assert.NoError(t, repo.CompletePublish(context.Background(), 9, 3, messages, now))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresJobRepositoryCompleteBump(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	repo := NewPostgresJobRepository(db)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE ads SET is_posted = TRUE").WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM telegram_messages (.+) ANY").WithArgs(3, pq.Array([]string{"@channel"})).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO telegram_messages").WithArgs(3, "@channel", 200, 0, "file-200", "https://example.com/1.jpg", "Caption", false).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE ads SET chat_message_id = \\(SELECT").WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE ads SET listed_at").WithArgs(now, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE outbox_jobs SET status = 'succeeded'").WithArgs(now, 9).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	messages := []models.TelegramMessage{{ChannelID: "@channel", MessageID: 200, FileID: "file-200", PhotoURL: "https://example.com/1.jpg", Caption: "Caption"}}
+	assert.NoError(t, repo.CompleteBump(context.Background(), 9, 3, messages, now))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresTransitionRepositoryTransition(t *testing.T) {
+	t.Run("Records Transition And Effects", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		repo := NewPostgresTransitionRepository(db)
+
+		now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE ads SET state = (.+) AND state = ").WithArgs(models.AdRented, 3, models.AdPublished, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("INSERT INTO ad_transitions").WithArgs(3, models.AdPublished, models.AdRented, "").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(5, now))
+		mock.ExpectQuery("INSERT INTO outbox_jobs (.+) ON CONFLICT").WithArgs(3, models.JobEditCaption, "", now, nil).
+			WillReturnRows(sqlmock.NewRows(jobRowColumns).AddRow(9, 3, "edit_caption", "", "pending", 0, now, nil, "", now, now, nil))
+		mock.ExpectCommit()
+
+		tr := models.AdTransition{AdID: 3, From: models.AdPublished, To: models.AdRented}
+		jobs, err := repo.Transition(context.Background(), &tr, []models.Job{{AdID: 3, Kind: models.JobEditCaption}}, now)
+		assert.NoError(t, err)
+		assert.Equal(t, 5, tr.ID)
+		if assert.Len(t, jobs, 1) {
+			assert.Equal(t, 9, jobs[0].ID)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("State Changed", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		repo := NewPostgresTransitionRepository(db)
+
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE ads SET state").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		tr := models.AdTransition{AdID: 3, From: models.AdDraft, To: models.AdPublished}
+		_, err := repo.Transition(context.Background(), &tr, nil, time.Now())
+		assert.ErrorIs(t, err, ErrConflict)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Stale Version", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		repo := NewPostgresTransitionRepository(db)
+
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE ads SET state").WithArgs(models.AdDeleted, 3, models.AdPublished, 4).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT 1 FROM ads WHERE id = \\$1 AND state = \\$2").WithArgs(3, models.AdPublished).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(1))
+		mock.ExpectRollback()
+
+		tr := models.AdTransition{AdID: 3, From: models.AdPublished, To: models.AdDeleted, Version: 4}
+		_, err := repo.Transition(context.Background(), &tr, nil, time.Now())
+		assert.ErrorIs(t, err, ErrStale)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresChannelRepository(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	repo := NewPostgresChannelRepository(db)
+
+	mock.ExpectQuery("SELECT (.+) FROM channels ORDER BY id").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "districts", "types", "min_price", "max_price", "disabled"}).
+			AddRow("@marina_rentals", "Marina", "{\"Dubai Marina\",JLT}", "{}", nil, 100000, false))
+	channels, err := repo.List(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Channel{{
+		ID: "@marina_rentals", Name: "Marina", Districts: []string{"Dubai Marina", "JLT"}, Types: []string{}, MaxPrice: intPtr(100000),
+	}}, channels)
+
+	mock.ExpectQuery("INSERT INTO channels (.+) ON CONFLICT").
+		WithArgs("@villas", "", pq.Array([]string{}), pq.Array([]string{"villa"}), nil, nil, false).
+		WillReturnRows(sqlmock.NewRows([]string{"created"}).AddRow(true))
+	created, err := repo.Save(context.Background(), models.Channel{ID: "@villas", Types: []string{"villa"}})
+	assert.NoError(t, err)
+	assert.True(t, created)
+
+	mock.ExpectExec("DELETE FROM channels").WithArgs("@villas").WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, repo.Delete(context.Background(), "@villas"), ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
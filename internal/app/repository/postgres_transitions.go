@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+)
+
+type PostgresTransitionRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresTransitionRepository(db *sql.DB) *PostgresTransitionRepository {
+	return &PostgresTransitionRepository{db: db}
+}
+
+func (r *PostgresTransitionRepository) Transition(ctx context.Context, t *models.AdTransition, effects []models.Job, now time.Time) ([]models.Job, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE ads SET state = $1, version = version + 1,
+			listed_at = CASE WHEN $1 = 'published' THEN NULL ELSE listed_at END
+		WHERE id = $2 AND state = $3 AND ($4 = 0 OR version = $4)`, t.To, t.AdID, t.From, t.Version)
+	if err := checkAffected(res, err); err == ErrNotFound {
+		return nil, transitionConflict(ctx, tx, t)
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO ad_transitions (ad_id, from_state, to_state, note) VALUES ($1, $2, $3, NULLIF($4, '')) RETURNING id, created_at",
+		t.AdID, t.From, t.To, t.Note,
+	).Scan(&t.ID, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	enqueued := []models.Job{}
+	for _, effect := range effects {
+		row := tx.QueryRowContext(ctx, `
+			INSERT INTO outbox_jobs (ad_id, kind, channel_id, next_attempt_at, publish_at) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (ad_id, kind, channel_id) WHERE status IN ('pending', 'running') DO NOTHING
+			RETURNING `+jobColumns,
+			t.AdID, effect.Kind, effect.ChannelID, dueAt(effect.NextAttemptAt, now), effect.PublishAt,
+		)
+		job, err := scanJob(row)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		enqueued = append(enqueued, job)
+	}
+
+	return enqueued, tx.Commit()
+}
+
+// transitionConflict explains why a transition matched no row: the ad
+// left t.From, or it is still there but has moved past t.Version.
+func transitionConflict(ctx context.Context, tx *sql.Tx, t *models.AdTransition) error {
+	if t.Version == 0 {
+		return ErrConflict
+	}
+	var exists int
+	err := tx.QueryRowContext(ctx, "SELECT 1 FROM ads WHERE id = $1 AND state = $2", t.AdID, t.From).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+	return ErrStale
+}
+
+func (r *PostgresTransitionRepository) ListTransitions(ctx context.Context, adID int) ([]models.AdTransition, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, ad_id, from_state, to_state, COALESCE(note, ''), created_at FROM ad_transitions WHERE ad_id = $1 ORDER BY id", adID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transitions := []models.AdTransition{}
+	for rows.Next() {
+		var t models.AdTransition
+		if err := rows.Scan(&t.ID, &t.AdID, &t.From, &t.To, &t.Note, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		transitions = append(transitions, t)
+	}
+	return transitions, rows.Err()
+}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/1karp/ads_api/internal/app/models"
+)
+
+type PostgresUserRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresUserRepository(db *sql.DB) *PostgresUserRepository {
+	return &PostgresUserRepository{db: db}
+}
+
+func (r *PostgresUserRepository) Create(ctx context.Context, user *models.User) error {
+	return r.db.QueryRowContext(ctx, "INSERT INTO users (username, userid) VALUES ($1, $2) RETURNING userid, version", user.Username, user.UserID).Scan(&user.UserID, &user.Version)
+}
+
+func (r *PostgresUserRepository) Get(ctx context.Context, userID int) (models.User, error) {
+	var user models.User
+	err := r.db.QueryRowContext(ctx, "SELECT userid, COALESCE(username, ''), version FROM users WHERE userid = $1 AND deleted_at IS NULL", userID).Scan(&user.UserID, &user.Username, &user.Version)
+	if err == sql.ErrNoRows {
+		return user, ErrNotFound
+	}
+	return user, err
+}
+
+func (r *PostgresUserRepository) GetByUsername(ctx context.Context, username string) (models.User, error) {
+	var user models.User
+	err := r.db.QueryRowContext(ctx, "SELECT userid, COALESCE(username, ''), version FROM users WHERE username = $1 AND deleted_at IS NULL", username).Scan(&user.UserID, &user.Username, &user.Version)
+	if err == sql.ErrNoRows {
+		return user, ErrNotFound
+	}
+	return user, err
+}
+
+func (r *PostgresUserRepository) List(ctx context.Context, opts ListOptions) ([]models.User, error) {
+	spec, ok := userSorts[opts.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sort %q", opts.Sort)
+	}
+
+	clause, args := listClause([]string{"deleted_at IS NULL"}, nil, spec, opts, "userid")
+	query := "SELECT userid, COALESCE(username, ''), version FROM users" + clause
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.UserID, &user.Username, &user.Version); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (r *PostgresUserRepository) Update(ctx context.Context, user *models.User) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		"UPDATE users SET username = $1, version = version + 1 WHERE userid = $2 AND deleted_at IS NULL AND ($3 = 0 OR version = $3) RETURNING version",
+		user.Username, user.UserID, user.Version,
+	).Scan(&user.Version)
+	if err == sql.ErrNoRows {
+		return staleOrNotFound(ctx, tx, "SELECT 1 FROM users WHERE userid = $1 AND deleted_at IS NULL", user.UserID, user.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresUserRepository) SoftDelete(ctx context.Context, userID int, version int) error {
+	return r.conditionalDelete(ctx,
+		"UPDATE users SET deleted_at = CURRENT_TIMESTAMP, version = version + 1 WHERE userid = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2)",
+		"SELECT 1 FROM users WHERE userid = $1 AND deleted_at IS NULL",
+		userID, version,
+	)
+}
+
+func (r *PostgresUserRepository) Delete(ctx context.Context, userID int, version int) error {
+	return r.conditionalDelete(ctx,
+		"DELETE FROM users WHERE userid = $1 AND ($2 = 0 OR version = $2)",
+		"SELECT 1 FROM users WHERE userid = $1",
+		userID, version,
+	)
+}
+
+// conditionalDelete runs query, which applies to version unless it is
+// zero, telling ErrStale from ErrNotFound by exists when it matches no
+// row.
+func (r *PostgresUserRepository) conditionalDelete(ctx context.Context, query, exists string, userID, version int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, query, userID, version)
+	if err := checkAffected(res, err); err == ErrNotFound {
+		return staleOrNotFound(ctx, tx, exists, userID, version)
+	} else if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
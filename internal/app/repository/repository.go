@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
+	// ErrStale is returned by conditional writes when the row no longer
+	// has the expected version.
+	ErrStale = errors.New("stale version")
+)
+
+type AdRepository interface {
+	Create(ctx context.Context, ad *models.Ad) error
+	Get(ctx context.Context, id int) (models.Ad, error)
+	List(ctx context.Context, filter AdFilter, opts ListOptions) ([]models.Ad, error)
+	// Update stores ad and sets its new version. When ad.Version is not
+	// zero the update only applies to that version and returns ErrStale
+	// otherwise. The state and the Telegram post are kept as stored, since
+	// only transitions and recorded messages change them, and returned in
+	// ad.
+	Update(ctx context.Context, ad *models.Ad) error
+	// SetMessages replaces the recorded album of a posted ad after it was
+	// posted, edited or reposted. Only the channels messages are in are
+	// replaced; albums in other channels are kept. No messages mark the ad
+	// as no longer posted anywhere.
+	SetMessages(ctx context.Context, id int, messages []models.TelegramMessage) error
+	// ReplacePhoto swaps an uploaded photo for its processed original and
+	// records the variants, updating the posted album to match. It
+	// returns ErrConflict when the ad no longer has the upload.
+	ReplacePhoto(ctx context.Context, adID int, upload string, photo models.Photo) error
+	// ReorderPhotos puts the ad's photos in the order of ids. It returns
+	// ErrConflict unless ids are exactly the ids of the ad's photos.
+	ReorderPhotos(ctx context.Context, adID int, ids []int) error
+	// DeletePhoto removes one photo, closing the gap it leaves in the
+	// order.
+	DeletePhoto(ctx context.Context, adID int, photoID int) error
+	// Delete removes the ad and its history permanently, conditionally on
+	// version like Update. Soft deletion is the deleted lifecycle state.
+	Delete(ctx context.Context, id int, version int) error
+}
+
+type UserRepository interface {
+	Create(ctx context.Context, user *models.User) error
+	Get(ctx context.Context, userID int) (models.User, error)
+	GetByUsername(ctx context.Context, username string) (models.User, error)
+	List(ctx context.Context, opts ListOptions) ([]models.User, error)
+	// Update stores user and sets its new version, conditionally like
+	// AdRepository.Update.
+	Update(ctx context.Context, user *models.User) error
+	// SoftDelete hides the user from every lookup and listing. Like
+	// Update, it only applies to version when that is not zero.
+	SoftDelete(ctx context.Context, userID int, version int) error
+	// Delete removes the user permanently, conditionally like SoftDelete.
+	// The user's ads must have been deleted first.
+	Delete(ctx context.Context, userID int, version int) error
+}
+
+// JobRepository stores the Telegram outbox. Times are supplied by the
+// caller so the worker's clock drives scheduling consistently.
+type JobRepository interface {
+	// Enqueue inserts a pending job, due at now unless it has its own
+	// NextAttemptAt, returning ErrConflict when an unfinished job of the
+	// same kind and channel already exists for the ad.
+	Enqueue(ctx context.Context, job *models.Job, now time.Time) error
+	// Claim leases the next due job to the caller until now+lease. Jobs
+	// whose lease expired, e.g. because a worker died, are claimed again.
+	Claim(ctx context.Context, now time.Time, lease time.Duration) (models.Job, bool, error)
+	// CompletePublish records the posted album of the ad, like
+	// AdRepository.SetMessages, and marks the job as succeeded, atomically.
+	// A published ad not listed yet, because its post had not gone out
+	// since it was published, is listed at now.
+	CompletePublish(ctx context.Context, jobID int, adID int, messages []models.TelegramMessage, now time.Time) error
+	// CompleteUnpublish forgets the ad's posted album and marks the job as
+	// succeeded, atomically.
+	CompleteUnpublish(ctx context.Context, jobID int, adID int, now time.Time) error
+	// CompleteBump records the reposted album of the ad like
+	// CompletePublish and restarts its listing at now, atomically.
+	CompleteBump(ctx context.Context, jobID int, adID int, messages []models.TelegramMessage, now time.Time) error
+	Complete(ctx context.Context, jobID int, now time.Time) error
+	Retry(ctx context.Context, jobID int, nextAttemptAt time.Time, lastErr string, now time.Time) error
+	Fail(ctx context.Context, jobID int, lastErr string, now time.Time) error
+	// Defer returns a claimed job to the queue until at without counting
+	// the attempt.
+	Defer(ctx context.Context, jobID int, at time.Time, now time.Time) error
+	ListByAd(ctx context.Context, adID int) ([]models.Job, error)
+
+	// ListQueue returns the pending publish jobs of channelID, or of every
+	// channel when it is empty, in the order they are due.
+	ListQueue(ctx context.Context, channelID string) ([]models.Job, error)
+	// Reschedule moves pending publish jobs to the given times, atomically.
+	// It returns ErrConflict when one of them is no longer pending.
+	Reschedule(ctx context.Context, times map[int]time.Time, now time.Time) error
+	// Cancel takes a pending publish job off the queue, returning
+	// ErrNotFound when there is none with that id.
+	Cancel(ctx context.Context, jobID int, now time.Time) error
+	// LastPublished returns when a publish job for channelID last
+	// succeeded, or the zero time.
+	LastPublished(ctx context.Context, channelID string) (time.Time, error)
+}
+
+// ChannelRepository stores the channels ads are routed to.
+type ChannelRepository interface {
+	// List returns every channel, ordered by id.
+	List(ctx context.Context) ([]models.Channel, error)
+	Get(ctx context.Context, id string) (models.Channel, error)
+	// Save creates the channel or replaces the one with its id, reporting
+	// whether it was created.
+	Save(ctx context.Context, channel models.Channel) (bool, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// TransitionRepository records ad state changes together with the outbox
+// jobs they cause.
+type TransitionRepository interface {
+	// Transition moves the ad from t.From to t.To, records t and enqueues
+	// effects as one unit, returning the jobs enqueued. It returns
+	// ErrConflict when the ad is no longer in t.From, and ErrStale when it
+	// is but t.Version is set and no longer matches. Effects duplicating
+	// an unfinished job of the same kind and channel are skipped; the rest
+	// are enqueued as by JobRepository.Enqueue.
+	Transition(ctx context.Context, t *models.AdTransition, effects []models.Job, now time.Time) ([]models.Job, error)
+	ListTransitions(ctx context.Context, adID int) ([]models.AdTransition, error)
+}
+
+// AdFilter holds the optional search criteria for listing ads. All set
+// criteria are combined with AND. Deleted ads are left out unless
+// IncludeDeleted is set.
+type AdFilter struct {
+	UserID       *int
+	MinPrice     *int
+	MaxPrice     *int
+	Rooms        []string
+	Types        []string
+	District     string
+	MinArea      *int
+	MaxArea      *int
+	States       []string
+	IsPosted     *bool
+	CreatedAfter *time.Time
+	ListedBefore *time.Time
+
+	IncludeDeleted bool
+}
+
+// ListOptions controls ordering and keyset pagination of a listing.
+// Results are always ordered by id as a tie-breaker so the keyset is
+// unique. A zero Limit returns every matching row.
+type ListOptions struct {
+	Sort  string
+	Limit int
+	After *Keyset
+}
+
+// Keyset identifies the last row of the previous page: the value of the
+// sort column, formatted by SortKey, and the row id.
+type Keyset struct {
+	Value string
+	ID    int
+}
+
+type sortSpec struct {
+	// column is the expression ordered by, empty when ordering by the id
+	// column alone. Nullable columns are read as adColumns reads them, so
+	// the order and the keyset agree with the values returned.
+	column  string
+	numeric bool
+	desc    bool
+	key     func(models.Ad) string
+}
+
+func createdAtKey(ad models.Ad) string { return ad.CreatedAt }
+func priceKey(ad models.Ad) string     { return strconv.Itoa(ad.Price) }
+func areaKey(ad models.Ad) string      { return strconv.Itoa(ad.Area) }
+
+var adSorts = map[string]sortSpec{
+	"created_at":  {column: "created_at", key: createdAtKey},
+	"-created_at": {column: "created_at", desc: true, key: createdAtKey},
+	"price":       {column: "COALESCE(price, 0)", numeric: true, key: priceKey},
+	"-price":      {column: "COALESCE(price, 0)", numeric: true, desc: true, key: priceKey},
+	"area":        {column: "COALESCE(area, 0)", numeric: true, key: areaKey},
+	"-area":       {column: "COALESCE(area, 0)", numeric: true, desc: true, key: areaKey},
+}
+
+var userSorts = map[string]sortSpec{
+	"userid":  {},
+	"-userid": {desc: true},
+}
+
+const (
+	DefaultAdSort   = "created_at"
+	DefaultUserSort = "userid"
+)
+
+func ValidAdSort(sort string) bool {
+	_, ok := adSorts[sort]
+	return ok
+}
+
+func ValidUserSort(sort string) bool {
+	_, ok := userSorts[sort]
+	return ok
+}
+
+// AdKeyset returns the keyset of ad under the given sort, for resuming a
+// listing after it.
+func AdKeyset(sort string, ad models.Ad) Keyset {
+	spec, ok := adSorts[sort]
+	if !ok {
+		spec = adSorts[DefaultAdSort]
+	}
+	return Keyset{Value: spec.key(ad), ID: ad.ID}
+}
+
+func UserKeyset(user models.User) Keyset {
+	return Keyset{ID: user.UserID}
+}
@@ -0,0 +1,124 @@
+// Package response writes the API's JSON bodies. Errors share one
+// envelope, an RFC 7807 problem extended with a machine-readable code and
+// the id of the request, so clients can rely on its shape and operators
+// can find the matching log lines.
+package response
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/1karp/ads_api/internal/app/validation"
+)
+
+// Error codes. They are part of the API and must not change.
+const (
+	CodeValidation          = "validation_failed"
+	CodeMalformedBody       = "malformed_body"
+	CodeUnsupportedMedia    = "unsupported_media_type"
+	CodePatchFailed         = "patch_failed"
+	CodeNotFound            = "not_found"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeAdNotFound          = "ad_not_found"
+	CodeUserNotFound        = "user_not_found"
+	CodePhotoNotFound       = "photo_not_found"
+	CodeAlreadyPosted       = "already_posted"
+	CodeAlreadyQueued       = "already_queued"
+	CodeNotPosted           = "not_posted"
+	CodeChannelNotFound     = "channel_not_found"
+	CodeJobNotFound         = "job_not_found"
+	CodeNoMatchingChannel   = "no_matching_channel"
+	CodeNotPublishable      = "not_publishable"
+	CodeBumpCooldown        = "bump_cooldown"
+	CodeInvalidTransition   = "invalid_transition"
+	CodeConflict            = "conflict"
+	CodePreconditionFailed  = "precondition_failed"
+	CodeForbidden           = "forbidden"
+	CodePayloadTooLarge     = "payload_too_large"
+	CodeStorageUnavailable  = "storage_unavailable"
+	CodeTelegramUnavailable = "telegram_unavailable"
+	CodeInternal            = "internal_error"
+)
+
+// Debug adds the underlying error to the detail of internal errors. It
+// must stay off in production, where those may reveal database or
+// upstream internals.
+var Debug bool
+
+// Problem is the error envelope.
+type Problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Code      string            `json:"code"`
+	Detail    string            `json:"detail,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	Errors    validation.Errors `json:"errors,omitempty"`
+}
+
+// JSON writes v with the given status.
+func JSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error encoding response", "error", err)
+	}
+}
+
+// Error writes a problem with the given status, code and human-readable
+// detail.
+func Error(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	write(w, Problem{Status: status, Code: code, Detail: detail, RequestID: RequestIDFrom(r.Context())})
+}
+
+// Invalid writes a 400 listing every failing field.
+func Invalid(w http.ResponseWriter, r *http.Request, errs validation.Errors) {
+	write(w, Problem{
+		Status:    http.StatusBadRequest,
+		Code:      CodeValidation,
+		Detail:    "The request has invalid fields.",
+		RequestID: RequestIDFrom(r.Context()),
+		Errors:    errs,
+	})
+}
+
+// Unprocessable writes a 422 listing the fields keeping a well-formed
+// request from being carried out, e.g. what an ad lacks to be published.
+func Unprocessable(w http.ResponseWriter, r *http.Request, code, detail string, errs validation.Errors) {
+	write(w, Problem{
+		Status:    http.StatusUnprocessableEntity,
+		Code:      code,
+		Detail:    detail,
+		RequestID: RequestIDFrom(r.Context()),
+		Errors:    errs,
+	})
+}
+
+// Internal logs err with the given slog attributes and writes a 500 whose
+// detail is msg alone unless Debug is set.
+func Internal(w http.ResponseWriter, r *http.Request, msg string, err error, args ...interface{}) {
+	Unavailable(w, r, http.StatusInternalServerError, CodeInternal, msg, err, args...)
+}
+
+// Unavailable is Internal for failures of a dependency, e.g. a 502 with
+// CodeTelegramUnavailable when the Bot API fails.
+func Unavailable(w http.ResponseWriter, r *http.Request, status int, code, msg string, err error, args ...interface{}) {
+	id := RequestIDFrom(r.Context())
+	slog.Error(msg, append(args, "error", err, "request_id", id)...)
+	detail := msg
+	if Debug && err != nil {
+		detail += ": " + err.Error()
+	}
+	write(w, Problem{Status: status, Code: code, Detail: detail, RequestID: id})
+}
+
+func write(w http.ResponseWriter, p Problem) {
+	p.Type = "/problems/" + p.Code
+	p.Title = http.StatusText(p.Status)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		slog.Error("Error encoding response", "error", err)
+	}
+}
@@ -0,0 +1,75 @@
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serve(h http.HandlerFunc, requestID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/ads/1", nil)
+	if requestID != "" {
+		req.Header.Set(RequestIDHeader, requestID)
+	}
+	rr := httptest.NewRecorder()
+	RequestID(h).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestError(t *testing.T) {
+	rr := serve(func(w http.ResponseWriter, r *http.Request) {
+		Error(w, r, http.StatusNotFound, CodeAdNotFound, "Ad not found")
+	}, "req-1")
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "req-1", rr.Header().Get(RequestIDHeader))
+	assert.JSONEq(t, `{
+		"type": "/problems/ad_not_found",
+		"title": "Not Found",
+		"status": 404,
+		"code": "ad_not_found",
+		"detail": "Ad not found",
+		"request_id": "req-1"
+	}`, rr.Body.String())
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := func(w http.ResponseWriter, r *http.Request) { seen = RequestIDFrom(r.Context()) }
+
+	rr := serve(handler, "")
+	assert.Len(t, seen, 32)
+	assert.Equal(t, seen, rr.Header().Get(RequestIDHeader))
+
+	rr = serve(handler, "bad id")
+	assert.Len(t, seen, 32, "invalid incoming ids are replaced")
+	assert.Equal(t, seen, rr.Header().Get(RequestIDHeader))
+
+	assert.Empty(t, RequestIDFrom(httptest.NewRequest("GET", "/", nil).Context()))
+}
+
+func TestInternal(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		Internal(w, r, "Error querying ads", errors.New("pq: relation \"ads\" does not exist"))
+	}
+	detail := func(rr *httptest.ResponseRecorder) string {
+		var p Problem
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+		assert.Equal(t, CodeInternal, p.Code)
+		assert.NotEmpty(t, p.RequestID)
+		return p.Detail
+	}
+
+	rr := serve(handler, "")
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Equal(t, "Error querying ads", detail(rr))
+
+	Debug = true
+	defer func() { Debug = false }()
+	assert.Equal(t, `Error querying ads: pq: relation "ads" does not exist`, detail(serve(handler, "")))
+}
@@ -1,25 +1,63 @@
 package router
 
 import (
+	"net/http"
+
 	"github.com/1karp/ads_api/internal/app/handlers"
+	"github.com/1karp/ads_api/internal/app/response"
 	"github.com/gorilla/mux"
 )
 
-func SetupRoutes() *mux.Router {
+// SetupRoutes registers the API. adminToken authorises hard deletes and
+// changes to channels; when empty they are refused.
+func SetupRoutes(ads *handlers.AdHandler, users *handlers.UserHandler, media *handlers.MediaHandler, channels *handlers.ChannelHandler, queue *handlers.QueueHandler, adminToken string) *mux.Router {
 	router := mux.NewRouter()
+	router.Use(response.RequestID)
+	router.NotFoundHandler = response.RequestID(http.HandlerFunc(notFound))
+	router.MethodNotAllowedHandler = response.RequestID(http.HandlerFunc(methodNotAllowed))
+
+	router.HandleFunc("/ads", ads.CreateAd).Methods("POST")
+	router.HandleFunc("/ads", ads.GetAds).Methods("GET")
+	router.HandleFunc("/ads/{id}", ads.GetAdByID).Methods("GET")
+	router.HandleFunc("/ads/{id}", ads.UpdateAd).Methods("PUT")
+	router.HandleFunc("/ads/{id}", ads.PatchAd).Methods("PATCH")
+	router.HandleFunc("/ads/{id}", handlers.RequireAdminForHardDelete(adminToken, ads.DeleteAd)).Methods("DELETE")
+	router.HandleFunc("/ads/{id}/post", ads.PostAd).Methods("POST")
+	router.HandleFunc("/ads/{id}/edit-post", ads.EditAdInTelegram).Methods("POST")
+	router.HandleFunc("/ads/{id}/bump", ads.BumpAd).Methods("POST")
+	router.HandleFunc("/ads/{id}/publications", ads.GetPublications).Methods("GET")
+	router.HandleFunc("/ads/{id}/preview", ads.PreviewAd).Methods("GET")
+	router.HandleFunc("/ads/{id}/transitions", ads.CreateTransition).Methods("POST")
+	router.HandleFunc("/ads/{id}/transitions", ads.GetTransitions).Methods("GET")
+	router.HandleFunc("/ads/{id}/photos", ads.UploadPhotos).Methods("POST")
+	router.HandleFunc("/ads/{id}/photos/order", ads.ReorderPhotos).Methods("PUT")
+	router.HandleFunc("/ads/{id}/photos/{photoID}", ads.DeletePhoto).Methods("DELETE")
+
+	router.HandleFunc("/users", users.CreateUser).Methods("POST")
+	router.HandleFunc("/users", users.GetUsers).Methods("GET")
+	router.HandleFunc("/users/{userid}", users.GetUserByID).Methods("GET")
+	router.HandleFunc("/users/{userid}", users.UpdateUser).Methods("PUT")
+	router.HandleFunc("/users/{userid}", handlers.RequireAdminForHardDelete(adminToken, users.DeleteUser)).Methods("DELETE")
+	router.HandleFunc("/users/{userid}/ads", users.GetAdsByUserID).Methods("GET")
+
+	router.HandleFunc("/channels", channels.ListChannels).Methods("GET")
+	router.HandleFunc("/channels/{id}", channels.GetChannel).Methods("GET")
+	router.HandleFunc("/channels/{id}", handlers.RequireAdmin(adminToken, channels.PutChannel)).Methods("PUT")
+	router.HandleFunc("/channels/{id}", handlers.RequireAdmin(adminToken, channels.DeleteChannel)).Methods("DELETE")
 
-	router.HandleFunc("/ads", handlers.CreateAd).Methods("POST")
-	router.HandleFunc("/ads", handlers.GetAds).Methods("GET")
-	router.HandleFunc("/ads/{id}", handlers.GetAdByID).Methods("GET")
-	router.HandleFunc("/ads/{id}", handlers.UpdateAd).Methods("PUT")
-	router.HandleFunc("/ads/{id}/post", handlers.PostAd).Methods("POST")
-	router.HandleFunc("/ads/{id}/edit-post", handlers.EditAdInTelegram).Methods("POST")
+	router.HandleFunc("/queue", queue.GetQueue).Methods("GET")
+	router.HandleFunc("/queue/{channel}/order", queue.ReorderQueue).Methods("PUT")
+	router.HandleFunc("/queue/{id:[0-9]+}", queue.CancelQueued).Methods("DELETE")
 
-	router.HandleFunc("/users", handlers.CreateUser).Methods("POST")
-	router.HandleFunc("/users", handlers.GetUsers).Methods("GET")
-	router.HandleFunc("/users/{userid}", handlers.GetUserByID).Methods("GET")
-	router.HandleFunc("/users/{userid}", handlers.UpdateUser).Methods("PUT")
-	router.HandleFunc("/users/{userid}/ads", handlers.GetAdsByUserID).Methods("GET")
+	router.PathPrefix("/media/").HandlerFunc(media.ServeMedia).Methods("GET", "HEAD")
 
 	return router
 }
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	response.Error(w, r, http.StatusNotFound, response.CodeNotFound, "No route for "+r.URL.Path)
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	response.Error(w, r, http.StatusMethodNotAllowed, response.CodeMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+}
@@ -0,0 +1,78 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/1karp/ads_api/internal/app/handlers"
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/1karp/ads_api/internal/app/response"
+	"github.com/1karp/ads_api/internal/app/schedule"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPI(t *testing.T) {
+	ads := repository.NewMemoryAdRepository()
+	users := repository.NewMemoryUserRepository()
+	server := httptest.NewServer(SetupRoutes(handlers.NewAdHandler(ads, users, nil, nil, nil, nil), handlers.NewUserHandler(users, ads, nil, nil), handlers.NewMediaHandler(nil), handlers.NewChannelHandler(repository.NewMemoryChannelRepository()), handlers.NewQueueHandler(schedule.New(repository.NewMemoryJobRepository(ads), schedule.Pacing{})), ""))
+	defer server.Close()
+
+	do := func(method, path string, body interface{}, out interface{}) int {
+		t.Helper()
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req, _ := http.NewRequest(method, server.URL+path, &buf)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if out != nil {
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				t.Fatalf("%s %s: could not decode response: %v", method, path, err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusCreated, do("POST", "/users", models.User{UserID: 10, Username: "landlord"}, nil))
+
+	for _, price := range []int{70000, 90000, 110000} {
+		var created models.Ad
+		status := do("POST", "/ads", models.Ad{UserID: 10, Username: "landlord", Photos: models.LegacyPhotos("https://example.com/a.jpg"), Price: price, Type: "apartment", Area: 80, District: "Marina"}, &created)
+		assert.Equal(t, http.StatusCreated, status)
+		assert.NotZero(t, created.ID)
+	}
+
+	var cheap []models.Ad
+	assert.Equal(t, http.StatusOK, do("GET", "/ads?max_price=100000", nil, &cheap))
+	assert.Len(t, cheap, 2)
+
+	var updated models.Ad
+	assert.Equal(t, http.StatusOK, do("PUT", "/ads/1", models.Ad{UserID: 10, Username: "landlord", Photos: models.LegacyPhotos("https://example.com/b.jpg"), Price: 75000, Type: "apartment", Area: 80, District: "Marina"}, &updated))
+
+	var fetched models.Ad
+	assert.Equal(t, http.StatusOK, do("GET", "/ads/1", nil, &fetched))
+	assert.Equal(t, 75000, fetched.Price)
+
+	var owned []models.Ad
+	assert.Equal(t, http.StatusOK, do("GET", "/users/10/ads?sort=-price", nil, &owned))
+	if assert.Len(t, owned, 3) {
+		assert.Equal(t, 110000, owned[0].Price)
+	}
+
+	assert.Equal(t, http.StatusNotFound, do("GET", "/users/11/ads", nil, nil))
+
+	var problem response.Problem
+	assert.Equal(t, http.StatusNotFound, do("GET", "/nowhere", nil, &problem))
+	assert.Equal(t, response.CodeNotFound, problem.Code)
+	assert.NotEmpty(t, problem.RequestID)
+	assert.Equal(t, http.StatusMethodNotAllowed, do("PATCH", "/users", nil, &problem))
+	assert.Equal(t, response.CodeMethodNotAllowed, problem.Code)
+}
@@ -0,0 +1,220 @@
+// Package schedule paces posting so a burst of new ads does not flood a
+// channel or trip Telegram's flood limits. Every channel has its own queue
+// of publish jobs, drained at most one album per Interval and never during
+// quiet hours.
+//
+// Queued jobs are planned into free slots when they are enqueued, so their
+// next_attempt_at is the schedule. The worker checks the pacing again when
+// it runs a job, because retries and downtime can bunch jobs up.
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/repository"
+)
+
+var (
+	// ErrOrderMismatch is returned by Reorder when the order does not
+	// list every queued job of the channel exactly once.
+	ErrOrderMismatch = errors.New("order must list every queued job of the channel once")
+	// ErrBeforePublishAt is returned by Reorder when a job would move to a
+	// slot before the time it was scheduled for.
+	ErrBeforePublishAt = errors.New("job would be posted before its publish_at")
+)
+
+// Pacing limits how often albums are posted to a channel. The zero value
+// posts jobs as soon as they are due.
+type Pacing struct {
+	// Interval is the least time between two albums in one channel.
+	Interval time.Duration
+	// QuietStart and QuietEnd are the start and end of the nightly quiet
+	// hours as offsets from midnight in Location. The quiet hours may span
+	// midnight; equal offsets mean there are none.
+	QuietStart, QuietEnd time.Duration
+	Location             *time.Location
+}
+
+// ParseQuietHours parses quiet hours written as "23:00-07:00". An empty
+// string means there are none.
+func ParseQuietHours(s string) (start, end time.Duration, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("quiet hours %q: want HH:MM-HH:MM", s)
+	}
+	if start, err = parseClock(from); err != nil {
+		return 0, 0, fmt.Errorf("quiet hours %q: %w", s, err)
+	}
+	if end, err = parseClock(to); err != nil {
+		return 0, 0, fmt.Errorf("quiet hours %q: %w", s, err)
+	}
+	return start, end, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Open returns t, or the end of the quiet hours t falls in.
+func (p Pacing) Open(t time.Time) time.Time {
+	if p.QuietStart == p.QuietEnd {
+		return t
+	}
+	loc := p.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	local := t.In(loc)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	since := local.Sub(midnight)
+
+	var open time.Time
+	switch {
+	case p.QuietStart < p.QuietEnd && since >= p.QuietStart && since < p.QuietEnd:
+		open = midnight.Add(p.QuietEnd)
+	case p.QuietStart > p.QuietEnd && since >= p.QuietStart:
+		open = midnight.AddDate(0, 0, 1).Add(p.QuietEnd)
+	case p.QuietStart > p.QuietEnd && since < p.QuietEnd:
+		open = midnight.Add(p.QuietEnd)
+	default:
+		return t
+	}
+	return open.In(t.Location())
+}
+
+// Slot returns the earliest time not before t, outside the quiet hours,
+// that is at least Interval away from every time in taken.
+func (p Pacing) Slot(t time.Time, taken []time.Time) time.Time {
+	sorted := slices.Clone(taken)
+	slices.SortFunc(sorted, time.Time.Compare)
+
+	t = p.Open(t)
+	for _, u := range sorted {
+		if !u.Add(p.Interval).After(t) {
+			continue
+		}
+		if !t.Add(p.Interval).After(u) {
+			break
+		}
+		t = p.Open(u.Add(p.Interval))
+	}
+	return t
+}
+
+// Scheduler plans publish jobs into their channel's queue.
+type Scheduler struct {
+	jobs   repository.JobRepository
+	pacing Pacing
+	now    func() time.Time
+}
+
+func New(jobs repository.JobRepository, pacing Pacing) *Scheduler {
+	return &Scheduler{jobs: jobs, pacing: pacing, now: func() time.Time { return time.Now().UTC() }}
+}
+
+// Plan returns when a job newly queued for channelID should run: the
+// first free slot of the channel not before publishAt, which may be nil.
+func (s *Scheduler) Plan(ctx context.Context, channelID string, publishAt *time.Time) (time.Time, error) {
+	taken, err := s.taken(ctx, channelID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	start := s.now()
+	if publishAt != nil && publishAt.After(start) {
+		start = *publishAt
+	}
+	return s.pacing.Slot(start, taken), nil
+}
+
+// taken returns the slots of channelID already used: its last post and
+// its queued jobs.
+func (s *Scheduler) taken(ctx context.Context, channelID string) ([]time.Time, error) {
+	var taken []time.Time
+	last, err := s.jobs.LastPublished(ctx, channelID)
+	if err != nil {
+		return nil, err
+	}
+	if !last.IsZero() {
+		taken = append(taken, last)
+	}
+	queued, err := s.jobs.ListQueue(ctx, channelID)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range queued {
+		taken = append(taken, job.NextAttemptAt)
+	}
+	return taken, nil
+}
+
+// Hold returns when a job for channelID may be posted, which is after now
+// when the channel posted too recently or is in its quiet hours.
+func (s *Scheduler) Hold(ctx context.Context, channelID string, now time.Time) (time.Time, error) {
+	last, err := s.jobs.LastPublished(ctx, channelID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var taken []time.Time
+	if !last.IsZero() {
+		taken = append(taken, last)
+	}
+	return s.pacing.Slot(now, taken), nil
+}
+
+// Queue returns the queued publish jobs of channelID, or of every channel
+// when it is empty, in the order they will run.
+func (s *Scheduler) Queue(ctx context.Context, channelID string) ([]models.Job, error) {
+	return s.jobs.ListQueue(ctx, channelID)
+}
+
+// Reorder gives the queued jobs of channelID the order of ids. The jobs
+// swap slots, so the channel's cadence is kept, and none may move to a
+// slot before its publish_at. It returns the reordered queue.
+func (s *Scheduler) Reorder(ctx context.Context, channelID string, ids []int) ([]models.Job, error) {
+	queued, err := s.jobs.ListQueue(ctx, channelID)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) != len(queued) {
+		return nil, ErrOrderMismatch
+	}
+	byID := make(map[int]models.Job, len(queued))
+	for _, job := range queued {
+		byID[job.ID] = job
+	}
+
+	times := make(map[int]time.Time, len(ids))
+	for i, id := range ids {
+		job, ok := byID[id]
+		if _, seen := times[id]; !ok || seen {
+			return nil, ErrOrderMismatch
+		}
+		at := queued[i].NextAttemptAt
+		if job.PublishAt != nil && at.Before(*job.PublishAt) {
+			return nil, fmt.Errorf("job %d: %w", id, ErrBeforePublishAt)
+		}
+		times[id] = at
+	}
+	if err := s.jobs.Reschedule(ctx, times, s.now()); err != nil {
+		return nil, err
+	}
+	return s.jobs.ListQueue(ctx, channelID)
+}
+
+// Cancel takes a publish job off the queue.
+func (s *Scheduler) Cancel(ctx context.Context, jobID int) error {
+	return s.jobs.Cancel(ctx, jobID, s.now())
+}
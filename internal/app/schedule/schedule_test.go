@@ -0,0 +1,135 @@
+package schedule
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/1karp/ads_api/internal/app/models"
+	"github.com/1karp/ads_api/internal/app/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var noon = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestParseQuietHours(t *testing.T) {
+	start, end, err := ParseQuietHours("23:00-07:30")
+	require.NoError(t, err)
+	assert.Equal(t, 23*time.Hour, start)
+	assert.Equal(t, 7*time.Hour+30*time.Minute, end)
+
+	start, end, err = ParseQuietHours("")
+	assert.NoError(t, err)
+	assert.Equal(t, start, end)
+
+	for _, s := range []string{"23:00", "23:00-25:00", "night"} {
+		_, _, err := ParseQuietHours(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dubai := time.FixedZone("Dubai", 4*60*60)
+	p := Pacing{QuietStart: 23 * time.Hour, QuietEnd: 7 * time.Hour, Location: dubai}
+
+	assert.Equal(t, noon, p.Open(noon))
+	lateEvening := time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC) // 00:00 in Dubai
+	assert.Equal(t, time.Date(2024, 5, 2, 3, 0, 0, 0, time.UTC), p.Open(lateEvening))
+	evening := time.Date(2024, 5, 1, 19, 30, 0, 0, time.UTC) // 23:30 in Dubai
+	assert.Equal(t, time.Date(2024, 5, 2, 3, 0, 0, 0, time.UTC), p.Open(evening))
+
+	p = Pacing{QuietStart: 13 * time.Hour, QuietEnd: 14 * time.Hour}
+	assert.Equal(t, noon.Add(2*time.Hour), p.Open(noon.Add(90*time.Minute)), "quiet hours need not span midnight")
+	assert.Equal(t, noon, Pacing{}.Open(noon))
+}
+
+func TestSlot(t *testing.T) {
+	p := Pacing{Interval: 10 * time.Minute}
+
+	assert.Equal(t, noon, p.Slot(noon, nil))
+	assert.Equal(t, noon.Add(5*time.Minute), p.Slot(noon, []time.Time{noon.Add(-5 * time.Minute)}))
+	assert.Equal(t, noon.Add(30*time.Minute), p.Slot(noon, []time.Time{noon.Add(20 * time.Minute), noon, noon.Add(10 * time.Minute)}))
+	assert.Equal(t, noon.Add(10*time.Minute), p.Slot(noon, []time.Time{noon, noon.Add(20 * time.Minute)}), "a gap between two slots is filled")
+	assert.Equal(t, noon, p.Slot(noon, []time.Time{noon.Add(time.Hour)}), "later slots leave room before them")
+
+	p.QuietStart, p.QuietEnd = 12*time.Hour+15*time.Minute, 13*time.Hour
+	assert.Equal(t, noon.Add(time.Hour), p.Slot(noon, []time.Time{noon, noon.Add(10 * time.Minute)}))
+}
+
+func TestScheduler(t *testing.T) {
+	ctx := context.Background()
+	ads := repository.NewMemoryAdRepository()
+	jobs := repository.NewMemoryJobRepository(ads)
+	s := New(jobs, Pacing{Interval: 15 * time.Minute})
+	s.now = func() time.Time { return noon }
+
+	enqueue := func(channelID string, publishAt *time.Time) models.Job {
+		t.Helper()
+		ad := models.Ad{UserID: 1}
+		require.NoError(t, ads.Create(ctx, &ad))
+		at, err := s.Plan(ctx, channelID, publishAt)
+		require.NoError(t, err)
+		job := models.Job{AdID: ad.ID, Kind: models.JobPublish, ChannelID: channelID, NextAttemptAt: at, PublishAt: publishAt}
+		require.NoError(t, jobs.Enqueue(ctx, &job, noon))
+		return job
+	}
+
+	evening := noon.Add(6 * time.Hour)
+	first := enqueue("@channel", nil)
+	scheduled := enqueue("@channel", &evening)
+	second := enqueue("@channel", nil)
+	other := enqueue("@other", nil)
+	assert.Equal(t, noon, first.NextAttemptAt)
+	assert.Equal(t, evening, scheduled.NextAttemptAt)
+	assert.Equal(t, noon.Add(15*time.Minute), second.NextAttemptAt, "a scheduled post does not hold up the queue")
+	assert.Equal(t, noon, other.NextAttemptAt, "channels are paced separately")
+
+	queue, err := s.Queue(ctx, "@channel")
+	require.NoError(t, err)
+	assert.Equal(t, []int{first.ID, second.ID, scheduled.ID}, jobIDs(queue))
+
+	t.Run("Reorder", func(t *testing.T) {
+		queue, err := s.Reorder(ctx, "@channel", []int{second.ID, first.ID, scheduled.ID})
+		require.NoError(t, err)
+		assert.Equal(t, []int{second.ID, first.ID, scheduled.ID}, jobIDs(queue))
+		assert.Equal(t, noon, queue[0].NextAttemptAt, "jobs swap slots")
+
+		_, err = s.Reorder(ctx, "@channel", []int{scheduled.ID, first.ID, second.ID})
+		assert.ErrorIs(t, err, ErrBeforePublishAt)
+		_, err = s.Reorder(ctx, "@channel", []int{first.ID, first.ID, scheduled.ID})
+		assert.ErrorIs(t, err, ErrOrderMismatch)
+		_, err = s.Reorder(ctx, "@channel", []int{first.ID, second.ID})
+		assert.ErrorIs(t, err, ErrOrderMismatch)
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		require.NoError(t, s.Cancel(ctx, scheduled.ID))
+		assert.ErrorIs(t, s.Cancel(ctx, scheduled.ID), repository.ErrNotFound)
+		queue, err := s.Queue(ctx, "@channel")
+		require.NoError(t, err)
+		assert.Len(t, queue, 2)
+	})
+
+	t.Run("Hold", func(t *testing.T) {
+		job, ok, err := jobs.Claim(ctx, noon, time.Minute)
+		require.True(t, ok)
+		require.NoError(t, err)
+		require.NoError(t, jobs.Complete(ctx, job.ID, noon))
+
+		until, err := s.Hold(ctx, job.ChannelID, noon.Add(5*time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, noon.Add(15*time.Minute), until)
+		until, err = s.Hold(ctx, "@quiet_channel", noon)
+		require.NoError(t, err)
+		assert.Equal(t, noon, until)
+	})
+}
+
+func jobIDs(jobs []models.Job) []int {
+	ids := make([]int, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.ID
+	}
+	return ids
+}
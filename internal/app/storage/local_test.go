@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocal(t *testing.T) {
+	local := NewLocal(t.TempDir())
+	ctx := context.Background()
+
+	require.NoError(t, local.Put(ctx, "ads/1/a.jpg", strings.NewReader("jpeg"), "image/jpeg"))
+
+	r, obj, err := local.Open(ctx, "ads/1/a.jpg")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "jpeg", string(body))
+	assert.Equal(t, Object{ContentType: "image/jpeg", Size: 4}, obj)
+
+	require.NoError(t, local.Delete(ctx, "ads/1/a.jpg"))
+	_, _, err = local.Open(ctx, "ads/1/a.jpg")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, local.Delete(ctx, "ads/1/a.jpg"))
+
+	_, _, err = local.Open(ctx, "ads")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestValidKey(t *testing.T) {
+	for key, want := range map[string]bool{
+		"ads/1/a.jpg":   true,
+		"a.jpg":         true,
+		"":              false,
+		"/etc/passwd":   false,
+		"../a.jpg":      false,
+		"ads/../../a":   false,
+		"..":            false,
+		"ads//a.jpg":    false,
+		"ads/./a.jpg":   false,
+		"ads/1/a.jpg/":  false,
+		"ads/..x/a.jpg": true,
+	} {
+		assert.Equal(t, want, ValidKey(key), key)
+	}
+}
+
+func TestIsUpload(t *testing.T) {
+	for key, want := range map[string]bool{
+		"ads/1/3f9a.png":           true,
+		"ads/1/3f9a.jpg":           true,
+		"ads/1/3f9a/original.jpg":  false,
+		"ads/1/3f9a/thumbnail.jpg": false,
+		"ads/1/3f9a":               false,
+		"avatars/1/3f9a.png":       false,
+	} {
+		assert.Equal(t, want, IsUpload(key), key)
+	}
+}
+
+func TestMediaKey(t *testing.T) {
+	media := &Media{BaseURL: "https://api.example.com/media/"}
+
+	url := media.URL("ads/1/a.jpg")
+	assert.Equal(t, "https://api.example.com/media/ads/1/a.jpg", url)
+	key, ok := media.Key(url)
+	assert.True(t, ok)
+	assert.Equal(t, "ads/1/a.jpg", key)
+
+	_, ok = media.Key("https://cdn.example.com/a.jpg")
+	assert.False(t, ok)
+	_, ok = media.Key("https://api.example.com/media/../a.jpg")
+	assert.False(t, ok)
+	_, ok = (*Media)(nil).Key(url)
+	assert.False(t, ok)
+}
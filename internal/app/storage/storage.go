@@ -0,0 +1,78 @@
+// Package storage keeps uploaded files in an object store. Objects are
+// addressed by slash-separated keys such as "ads/42/3f9a1c.jpg".
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// Object describes a stored object.
+type Object struct {
+	ContentType string
+	Size        int64
+}
+
+// Backend is an object store.
+type Backend interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Open returns the object's content, or ErrNotFound. The caller must
+	// close the reader.
+	Open(ctx context.Context, key string) (io.ReadCloser, Object, error)
+	// Delete removes the object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// ValidKey reports whether key is a clean relative path that cannot
+// escape the store, e.g. through "..".
+func ValidKey(key string) bool {
+	return key != "" && !strings.HasPrefix(key, "/") && path.Clean(key) == key && key != ".." && !strings.HasPrefix(key, "../")
+}
+
+func checkKey(key string) error {
+	if !ValidKey(key) {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	return nil
+}
+
+// IsUpload reports whether key is a photo as it was uploaded, e.g.
+// "ads/42/3f9a1c.jpg", rather than one of the variants the image pipeline
+// replaces it with, e.g. "ads/42/3f9a1c/large.jpg". Uploads may carry
+// metadata such as GPS positions, so they are neither served nor posted.
+func IsUpload(key string) bool {
+	dir, name := path.Split(key)
+	return strings.HasPrefix(dir, "ads/") && strings.Count(dir, "/") == 2 && path.Ext(name) != ""
+}
+
+// Media maps stored objects to the public URLs they are served under.
+type Media struct {
+	Backend Backend
+	// BaseURL is the URL prefix of the media route, e.g. "/media" or
+	// "https://api.example.com/media".
+	BaseURL string
+}
+
+// URL returns the public URL of key.
+func (m *Media) URL(key string) string {
+	return strings.TrimRight(m.BaseURL, "/") + "/" + key
+}
+
+// Key returns the object key of a URL returned by URL. It reports false
+// for any other URL, e.g. a photo hosted elsewhere.
+func (m *Media) Key(url string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	key, ok := strings.CutPrefix(url, strings.TrimRight(m.BaseURL, "/")+"/")
+	if !ok || !ValidKey(key) {
+		return "", false
+	}
+	return key, true
+}
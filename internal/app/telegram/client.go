@@ -0,0 +1,307 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultBaseURL = "https://api.telegram.org"
+	DefaultTimeout = 30 * time.Second
+
+	ParseModeHTML = "HTML"
+)
+
+// Client is a minimal Telegram Bot API client covering the methods used to
+// publish ads. The base URL is configurable so tests and staging can point
+// it at a fake Bot API.
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient returns a client for the bot identified by token. An empty
+// baseURL selects DefaultBaseURL and a nil httpClient selects one with
+// DefaultTimeout.
+func NewClient(baseURL, token string, httpClient *http.Client) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: DefaultTimeout}
+	}
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		httpClient: httpClient,
+	}
+}
+
+// Error is a failed Bot API call. Code and Description come from the API
+// response; RetryAfter is set when Telegram asks the caller to back off.
+type Error struct {
+	Method      string
+	StatusCode  int
+	Code        int
+	Description string
+	RetryAfter  time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("telegram %s failed: %s (code: %d)", e.Method, e.Description, e.Code)
+}
+
+// IsMessageNotModified reports whether err is Telegram's rejection of an
+// edit that would leave the message unchanged.
+func IsMessageNotModified(err error) bool {
+	var tgErr *Error
+	return errors.As(err, &tgErr) && strings.Contains(tgErr.Description, "message is not modified")
+}
+
+// IsMessageNotFound reports whether err is Telegram's answer for a message
+// that no longer exists, e.g. one deleted by a channel admin.
+func IsMessageNotFound(err error) bool {
+	var tgErr *Error
+	return errors.As(err, &tgErr) && (strings.Contains(tgErr.Description, "message to delete not found") || strings.Contains(tgErr.Description, "message to edit not found"))
+}
+
+type PhotoSize struct {
+	FileID       string `json:"file_id"`
+	FileUniqueID string `json:"file_unique_id"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	FileSize     int    `json:"file_size,omitempty"`
+}
+
+type Message struct {
+	MessageID    int         `json:"message_id"`
+	MediaGroupID string      `json:"media_group_id,omitempty"`
+	Caption      string      `json:"caption,omitempty"`
+	Text         string      `json:"text,omitempty"`
+	Photo        []PhotoSize `json:"photo,omitempty"`
+}
+
+type InputMediaPhoto struct {
+	Type      string `json:"type"`
+	Media     string `json:"media"`
+	Caption   string `json:"caption,omitempty"`
+	ParseMode string `json:"parse_mode,omitempty"`
+}
+
+// NewInputMediaPhoto returns a photo item referring to media, which may be
+// an HTTP URL, a Telegram file_id or an uploaded file (see Attach).
+func NewInputMediaPhoto(media string) InputMediaPhoto {
+	return InputMediaPhoto{Type: "photo", Media: media}
+}
+
+// InputFile is a file uploaded with a request. Requests carrying files
+// are sent as multipart/form-data.
+type InputFile struct {
+	Name     string
+	Filename string
+	Reader   io.Reader
+}
+
+// Attach returns the media reference to the file uploaded as name.
+func Attach(name string) string {
+	return "attach://" + name
+}
+
+type SendMediaGroupParams struct {
+	ChatID string            `json:"chat_id"`
+	Media  []InputMediaPhoto `json:"media"`
+	Files  []InputFile       `json:"-"`
+}
+
+type EditMessageCaptionParams struct {
+	ChatID    string `json:"chat_id"`
+	MessageID int    `json:"message_id"`
+	Caption   string `json:"caption"`
+	ParseMode string `json:"parse_mode,omitempty"`
+}
+
+type EditMessageTextParams struct {
+	ChatID    string `json:"chat_id"`
+	MessageID int    `json:"message_id"`
+	Text      string `json:"text"`
+	ParseMode string `json:"parse_mode,omitempty"`
+}
+
+type EditMessageMediaParams struct {
+	ChatID    string          `json:"chat_id"`
+	MessageID int             `json:"message_id"`
+	Media     InputMediaPhoto `json:"media"`
+	Files     []InputFile     `json:"-"`
+}
+
+type DeleteMessageParams struct {
+	ChatID    string `json:"chat_id"`
+	MessageID int    `json:"message_id"`
+}
+
+type SendMessageParams struct {
+	ChatID           string `json:"chat_id"`
+	Text             string `json:"text"`
+	ParseMode        string `json:"parse_mode,omitempty"`
+	ReplyToMessageID int    `json:"reply_to_message_id,omitempty"`
+}
+
+func (c *Client) SendMediaGroup(ctx context.Context, params SendMediaGroupParams) ([]Message, error) {
+	var messages []Message
+	err := c.call(ctx, "sendMediaGroup", params, params.Files, &messages)
+	return messages, err
+}
+
+// EditMessageCaption edits the caption of a message. Telegram answers with
+// either the edited message or true; the result is not needed here.
+func (c *Client) EditMessageCaption(ctx context.Context, params EditMessageCaptionParams) error {
+	return c.call(ctx, "editMessageCaption", params, nil, nil)
+}
+
+// EditMessageText edits the text of a message sent with SendMessage.
+func (c *Client) EditMessageText(ctx context.Context, params EditMessageTextParams) error {
+	return c.call(ctx, "editMessageText", params, nil, nil)
+}
+
+func (c *Client) EditMessageMedia(ctx context.Context, params EditMessageMediaParams) error {
+	return c.call(ctx, "editMessageMedia", params, params.Files, nil)
+}
+
+func (c *Client) DeleteMessage(ctx context.Context, params DeleteMessageParams) error {
+	return c.call(ctx, "deleteMessage", params, nil, nil)
+}
+
+func (c *Client) SendMessage(ctx context.Context, params SendMessageParams) (Message, error) {
+	var message Message
+	err := c.call(ctx, "sendMessage", params, nil, &message)
+	return message, err
+}
+
+type apiResponse struct {
+	Ok          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+func (c *Client) call(ctx context.Context, method string, params interface{}, files []InputFile, result interface{}) error {
+	if c.token == "" {
+		return fmt.Errorf("telegram %s: bot token not configured", method)
+	}
+
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("error marshaling %s params: %v", method, err)
+	}
+
+	contentType := "application/json"
+	if len(files) > 0 {
+		body, contentType, err = multipartBody(body, files)
+		if err != nil {
+			return fmt.Errorf("error encoding %s files: %v", method, err)
+		}
+	}
+
+	endpoint := fmt.Sprintf("%s/bot%s/%s", c.baseURL, c.token, method)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// The URL embeds the bot token; keep it out of logs.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("telegram %s request failed: %v", method, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading %s response: %v", method, err)
+	}
+
+	var apiResp apiResponse
+	if err := json.Unmarshal(raw, &apiResp); err != nil {
+		return &Error{Method: method, StatusCode: resp.StatusCode, Code: resp.StatusCode, Description: strings.TrimSpace(string(raw))}
+	}
+
+	if !apiResp.Ok {
+		return &Error{
+			Method:      method,
+			StatusCode:  resp.StatusCode,
+			Code:        apiResp.ErrorCode,
+			Description: apiResp.Description,
+			RetryAfter:  time.Duration(apiResp.Parameters.RetryAfter) * time.Second,
+		}
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(apiResp.Result, result); err != nil {
+			return fmt.Errorf("error decoding %s result: %v", method, err)
+		}
+	}
+	return nil
+}
+
+// multipartBody re-encodes the JSON params as form fields, with nested
+// values such as media lists kept as JSON, and appends the files.
+func multipartBody(params []byte, files []InputFile) ([]byte, string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(params, &fields); err != nil {
+		return nil, "", err
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, name := range names {
+		value := string(fields[name])
+		var s string
+		if json.Unmarshal(fields[name], &s) == nil {
+			value = s
+		}
+		if err := mw.WriteField(name, value); err != nil {
+			return nil, "", err
+		}
+	}
+	for _, f := range files {
+		filename := f.Filename
+		if filename == "" {
+			filename = f.Name
+		}
+		part, err := mw.CreateFormFile(f.Name, filename)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := io.Copy(part, f.Reader); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), mw.FormDataContentType(), nil
+}
@@ -0,0 +1,127 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendMediaGroup(t *testing.T) {
+	var gotPath string
+	var gotParams SendMediaGroupParams
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&gotParams)
+		w.Write([]byte(`{"ok":true,"result":[{"message_id":10,"media_group_id":"g1"},{"message_id":11,"media_group_id":"g1"}]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "TOKEN", nil)
+	media := []InputMediaPhoto{NewInputMediaPhoto("https://example.com/a.jpg"), NewInputMediaPhoto("https://example.com/b.jpg")}
+	media[0].Caption = "caption"
+
+	messages, err := client.SendMediaGroup(context.Background(), SendMediaGroupParams{ChatID: "@channel", Media: media})
+	assert.NoError(t, err)
+	assert.Equal(t, "/botTOKEN/sendMediaGroup", gotPath)
+	assert.Equal(t, SendMediaGroupParams{ChatID: "@channel", Media: media}, gotParams)
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, 10, messages[0].MessageID)
+		assert.Equal(t, 11, messages[1].MessageID)
+	}
+}
+
+func TestSendMediaGroupUploadsFiles(t *testing.T) {
+	var gotContentType, gotChatID, gotFile string
+	var gotMedia []InputMediaPhoto
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotContentType = r.Header.Get("Content-Type")
+		if err := r.ParseMultipartForm(1 << 20); err == nil {
+			gotChatID = r.FormValue("chat_id")
+			json.Unmarshal([]byte(r.FormValue("media")), &gotMedia)
+			if f, _, err := r.FormFile("photo0"); err == nil {
+				body := make([]byte, 16)
+				n, _ := f.Read(body)
+				gotFile = string(body[:n])
+			}
+		}
+		w.Write([]byte(`{"ok":true,"result":[{"message_id":10},{"message_id":11}]}`))
+	}))
+	defer server.Close()
+
+	media := []InputMediaPhoto{NewInputMediaPhoto(Attach("photo0")), NewInputMediaPhoto("https://example.com/b.jpg")}
+	_, err := NewClient(server.URL, "TOKEN", nil).SendMediaGroup(context.Background(), SendMediaGroupParams{
+		ChatID: "@channel",
+		Media:  media,
+		Files:  []InputFile{{Name: "photo0", Filename: "a.jpg", Reader: strings.NewReader("jpeg")}},
+	})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(gotContentType, "multipart/form-data"))
+	assert.Equal(t, "@channel", gotChatID)
+	assert.Equal(t, media, gotMedia)
+	assert.Equal(t, "jpeg", gotFile)
+}
+
+func TestCallErrors(t *testing.T) {
+	t.Run("Retry After", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 7","parameters":{"retry_after":7}}`))
+		}))
+		defer server.Close()
+
+		err := NewClient(server.URL, "TOKEN", nil).DeleteMessage(context.Background(), DeleteMessageParams{ChatID: "@channel", MessageID: 1})
+		var tgErr *Error
+		if assert.ErrorAs(t, err, &tgErr) {
+			assert.Equal(t, 429, tgErr.Code)
+			assert.Equal(t, 7*time.Second, tgErr.RetryAfter)
+		}
+	})
+
+	t.Run("Message Not Modified", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: message is not modified"}`))
+		}))
+		defer server.Close()
+
+		err := NewClient(server.URL, "TOKEN", nil).EditMessageCaption(context.Background(), EditMessageCaptionParams{ChatID: "@channel", MessageID: 1, Caption: "same"})
+		assert.True(t, IsMessageNotModified(err))
+		assert.False(t, IsMessageNotFound(err))
+	})
+
+	t.Run("Message Not Found", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: message to delete not found"}`))
+		}))
+		defer server.Close()
+
+		err := NewClient(server.URL, "TOKEN", nil).DeleteMessage(context.Background(), DeleteMessageParams{ChatID: "@channel", MessageID: 1})
+		assert.True(t, IsMessageNotFound(err))
+	})
+
+	t.Run("Non-JSON Body", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		_, err := NewClient(server.URL, "TOKEN", nil).SendMessage(context.Background(), SendMessageParams{ChatID: "@channel", Text: "hi"})
+		var tgErr *Error
+		if assert.ErrorAs(t, err, &tgErr) {
+			assert.Equal(t, http.StatusBadGateway, tgErr.StatusCode)
+		}
+	})
+
+	t.Run("Token Not Leaked", func(t *testing.T) {
+		err := NewClient("http://127.0.0.1:1", "SECRET", nil).DeleteMessage(context.Background(), DeleteMessageParams{ChatID: "@channel", MessageID: 1})
+		assert.Error(t, err)
+		assert.NotContains(t, err.Error(), "SECRET")
+	})
+}
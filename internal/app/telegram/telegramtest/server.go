@@ -0,0 +1,433 @@
+// Package telegramtest provides an in-process fake of the Telegram Bot API
+// for tests. It records every call, keeps enough message state to answer
+// like the real API (including "message is not modified" and "message to
+// delete not found"), and can be scripted to fail specific methods.
+package telegramtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/1karp/ads_api/internal/app/telegram"
+)
+
+const Token = "test-token"
+
+// Call is one request received by the server. Params holds the decoded
+// JSON body. Multipart requests are converted to the equivalent JSON body,
+// with the uploaded files kept in Files by field name.
+type Call struct {
+	Method string
+	Params map[string]interface{}
+	Body   []byte
+	Files  map[string][]byte
+}
+
+// Decode unmarshals the call body into v, typically one of the telegram
+// *Params types.
+func (c Call) Decode(v interface{}) error {
+	return json.Unmarshal(c.Body, v)
+}
+
+// Failure is a scripted error response.
+type Failure struct {
+	Status      int
+	Description string
+	RetryAfter  int
+}
+
+func TooManyRequests(retryAfter int) Failure {
+	return Failure{
+		Status:      http.StatusTooManyRequests,
+		Description: fmt.Sprintf("Too Many Requests: retry after %d", retryAfter),
+		RetryAfter:  retryAfter,
+	}
+}
+
+func NotModified() Failure {
+	return Failure{
+		Status:      http.StatusBadRequest,
+		Description: "Bad Request: message is not modified: specified new message content and reply markup are exactly the same as a current content and reply markup of the message",
+	}
+}
+
+func ServerError() Failure {
+	return Failure{Status: http.StatusInternalServerError, Description: "Internal Server Error"}
+}
+
+// Message is the server's view of a message it has sent.
+type Message struct {
+	ChatID       string
+	MessageID    int
+	MediaGroupID string
+	Media        string
+	Caption      string
+	Text         string
+	ReplyTo      int
+}
+
+type Server struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	calls         []Call
+	failures      map[string][]Failure
+	messages      map[string]*Message
+	nextMessageID int
+	nextGroupID   int
+}
+
+// NewServer starts a fake Bot API. Callers must Close it.
+func NewServer() *Server {
+	s := &Server{
+		failures:      map[string][]Failure{},
+		messages:      map[string]*Message{},
+		nextMessageID: 100,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Client returns a telegram.Client talking to this server.
+func (s *Server) Client() *telegram.Client {
+	return telegram.NewClient(s.URL, Token, s.Server.Client())
+}
+
+// FailNext makes the next len(failures) calls to method fail with the given
+// responses, in order.
+func (s *Server) FailNext(method string, failures ...Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = append(s.failures[method], failures...)
+}
+
+// Calls returns every call received so far.
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// CallsTo returns the calls received for method.
+func (s *Server) CallsTo(method string) []Call {
+	var calls []Call
+	for _, c := range s.Calls() {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Message returns the current state of a message, if it exists.
+func (s *Server) Message(chatID string, messageID int) (Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.messages[messageKey(chatID, messageID)]
+	if !ok {
+		return Message{}, false
+	}
+	return *m, true
+}
+
+// Messages returns every live message in chatID.
+func (s *Server) Messages(chatID string) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []Message
+	for id := 100; id < s.nextMessageID; id++ {
+		if m, ok := s.messages[messageKey(chatID, id)]; ok {
+			messages = append(messages, *m)
+		}
+	}
+	return messages
+}
+
+func messageKey(chatID string, messageID int) string {
+	return fmt.Sprintf("%s/%d", chatID, messageID)
+}
+
+type response struct {
+	Ok          bool        `json:"ok"`
+	Result      interface{} `json:"result,omitempty"`
+	ErrorCode   int         `json:"error_code,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Parameters  *struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, resp response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func writeFailure(w http.ResponseWriter, f Failure) {
+	resp := response{ErrorCode: f.Status, Description: f.Description}
+	if f.RetryAfter > 0 {
+		resp.Parameters = &struct {
+			RetryAfter int `json:"retry_after"`
+		}{f.RetryAfter}
+	}
+	writeJSON(w, f.Status, resp)
+}
+
+// decodeMultipart turns a multipart form into the JSON body the same call
+// would have had. Field values that are valid JSON, such as media lists and
+// numbers, are kept as JSON; others are strings.
+func decodeMultipart(body []byte, boundary string) ([]byte, map[string][]byte, error) {
+	form, err := multipart.NewReader(bytes.NewReader(body), boundary).ReadForm(32 << 20)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer form.RemoveAll()
+
+	fields := map[string]json.RawMessage{}
+	for name, values := range form.Value {
+		value := []byte(values[0])
+		if !json.Valid(value) {
+			value, _ = json.Marshal(values[0])
+		}
+		fields[name] = value
+	}
+	jsonBody, err := json.Marshal(fields)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files := map[string][]byte{}
+	for name, headers := range form.File {
+		f, err := headers[0].Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		files[name], err = io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return jsonBody, files, nil
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	prefix := "/bot" + Token + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		writeFailure(w, Failure{Status: http.StatusUnauthorized, Description: "Unauthorized"})
+		return
+	}
+	method := strings.TrimPrefix(r.URL.Path, prefix)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeFailure(w, Failure{Status: http.StatusBadRequest, Description: "Bad Request: " + err.Error()})
+		return
+	}
+
+	call := Call{Method: method, Body: body}
+	if mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		if call.Body, call.Files, err = decodeMultipart(body, params["boundary"]); err != nil {
+			writeFailure(w, Failure{Status: http.StatusBadRequest, Description: "Bad Request: " + err.Error()})
+			return
+		}
+	}
+	json.Unmarshal(call.Body, &call.Params)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls = append(s.calls, call)
+
+	if queued := s.failures[method]; len(queued) > 0 {
+		s.failures[method] = queued[1:]
+		writeFailure(w, queued[0])
+		return
+	}
+
+	switch method {
+	case "sendMediaGroup":
+		s.sendMediaGroup(w, call)
+	case "sendMessage":
+		s.sendMessage(w, call)
+	case "editMessageCaption":
+		s.editMessageCaption(w, call)
+	case "editMessageText":
+		s.editMessageText(w, call)
+	case "editMessageMedia":
+		s.editMessageMedia(w, call)
+	case "deleteMessage":
+		s.deleteMessage(w, call)
+	default:
+		writeFailure(w, Failure{Status: http.StatusNotFound, Description: "Not Found"})
+	}
+}
+
+func photoResult(m *Message) telegram.Message {
+	return telegram.Message{
+		MessageID:    m.MessageID,
+		MediaGroupID: m.MediaGroupID,
+		Caption:      m.Caption,
+		Photo: []telegram.PhotoSize{
+			{FileID: fmt.Sprintf("file-%d-s", m.MessageID), FileUniqueID: fmt.Sprintf("u%d-s", m.MessageID), Width: 90, Height: 60},
+			{FileID: fmt.Sprintf("file-%d", m.MessageID), FileUniqueID: fmt.Sprintf("u%d", m.MessageID), Width: 1280, Height: 853},
+		},
+	}
+}
+
+func (s *Server) newMessage(chatID string) *Message {
+	m := &Message{ChatID: chatID, MessageID: s.nextMessageID}
+	s.nextMessageID++
+	s.messages[messageKey(chatID, m.MessageID)] = m
+	return m
+}
+
+func (s *Server) sendMediaGroup(w http.ResponseWriter, call Call) {
+	var params telegram.SendMediaGroupParams
+	if err := call.Decode(&params); err != nil || params.ChatID == "" {
+		writeFailure(w, Failure{Status: http.StatusBadRequest, Description: "Bad Request: chat_id is empty"})
+		return
+	}
+	if len(params.Media) < 2 || len(params.Media) > 10 {
+		writeFailure(w, Failure{Status: http.StatusBadRequest, Description: "Bad Request: wrong number of messages in the media group"})
+		return
+	}
+
+	for _, media := range params.Media {
+		if !attached(call, media.Media) {
+			writeFailure(w, Failure{Status: http.StatusBadRequest, Description: "Bad Request: failed to send message #1 with the error message \"WEBPAGE_CURL_FAILED\""})
+			return
+		}
+	}
+
+	s.nextGroupID++
+	groupID := fmt.Sprintf("group-%d", s.nextGroupID)
+	result := make([]telegram.Message, len(params.Media))
+	for i, media := range params.Media {
+		m := s.newMessage(params.ChatID)
+		m.MediaGroupID = groupID
+		m.Media = mediaRef(call, media.Media)
+		m.Caption = media.Caption
+		result[i] = photoResult(m)
+	}
+	writeJSON(w, http.StatusOK, response{Ok: true, Result: result})
+}
+
+// attached reports whether an attach:// media reference names a file
+// uploaded with the call. Other references are accepted as they are.
+func attached(call Call, media string) bool {
+	name, ok := strings.CutPrefix(media, "attach://")
+	if !ok {
+		return true
+	}
+	_, ok = call.Files[name]
+	return ok
+}
+
+// mediaRef is what a message records as its media: URLs and file ids as
+// sent, uploads as "upload:" followed by their content.
+func mediaRef(call Call, media string) string {
+	if name, ok := strings.CutPrefix(media, "attach://"); ok {
+		return "upload:" + string(call.Files[name])
+	}
+	return media
+}
+
+func (s *Server) sendMessage(w http.ResponseWriter, call Call) {
+	var params telegram.SendMessageParams
+	if err := call.Decode(&params); err != nil || params.ChatID == "" {
+		writeFailure(w, Failure{Status: http.StatusBadRequest, Description: "Bad Request: chat_id is empty"})
+		return
+	}
+	if params.Text == "" {
+		writeFailure(w, Failure{Status: http.StatusBadRequest, Description: "Bad Request: message text is empty"})
+		return
+	}
+
+	m := s.newMessage(params.ChatID)
+	m.Text = params.Text
+	m.ReplyTo = params.ReplyToMessageID
+	writeJSON(w, http.StatusOK, response{Ok: true, Result: telegram.Message{MessageID: m.MessageID, Text: m.Text}})
+}
+
+func (s *Server) lookup(w http.ResponseWriter, chatID string, messageID int, action string) *Message {
+	m, ok := s.messages[messageKey(chatID, messageID)]
+	if !ok {
+		writeFailure(w, Failure{Status: http.StatusBadRequest, Description: "Bad Request: message to " + action + " not found"})
+		return nil
+	}
+	return m
+}
+
+func (s *Server) editMessageCaption(w http.ResponseWriter, call Call) {
+	var params telegram.EditMessageCaptionParams
+	call.Decode(&params)
+
+	m := s.lookup(w, params.ChatID, params.MessageID, "edit")
+	if m == nil {
+		return
+	}
+	if m.Caption == params.Caption {
+		writeFailure(w, NotModified())
+		return
+	}
+	m.Caption = params.Caption
+	writeJSON(w, http.StatusOK, response{Ok: true, Result: photoResult(m)})
+}
+
+func (s *Server) editMessageText(w http.ResponseWriter, call Call) {
+	var params telegram.EditMessageTextParams
+	call.Decode(&params)
+
+	m := s.lookup(w, params.ChatID, params.MessageID, "edit")
+	if m == nil {
+		return
+	}
+	if m.Text == params.Text {
+		writeFailure(w, NotModified())
+		return
+	}
+	m.Text = params.Text
+	writeJSON(w, http.StatusOK, response{Ok: true, Result: telegram.Message{MessageID: m.MessageID, Text: m.Text}})
+}
+
+func (s *Server) editMessageMedia(w http.ResponseWriter, call Call) {
+	var params telegram.EditMessageMediaParams
+	call.Decode(&params)
+
+	if !attached(call, params.Media.Media) {
+		writeFailure(w, Failure{Status: http.StatusBadRequest, Description: "Bad Request: wrong file identifier/HTTP URL specified"})
+		return
+	}
+	m := s.lookup(w, params.ChatID, params.MessageID, "edit")
+	if m == nil {
+		return
+	}
+	media := mediaRef(call, params.Media.Media)
+	if m.Media == media && m.Caption == params.Media.Caption {
+		writeFailure(w, NotModified())
+		return
+	}
+	m.Media = media
+	m.Caption = params.Media.Caption
+	writeJSON(w, http.StatusOK, response{Ok: true, Result: photoResult(m)})
+}
+
+func (s *Server) deleteMessage(w http.ResponseWriter, call Call) {
+	var params telegram.DeleteMessageParams
+	call.Decode(&params)
+
+	if s.lookup(w, params.ChatID, params.MessageID, "delete") == nil {
+		return
+	}
+	delete(s.messages, messageKey(params.ChatID, params.MessageID))
+	writeJSON(w, http.StatusOK, response{Ok: true, Result: true})
+}
@@ -0,0 +1,94 @@
+package validation
+
+import (
+	"regexp"
+
+	"github.com/1karp/ads_api/internal/app/models"
+)
+
+const (
+	// MaxPhotos is the size limit of a Telegram album.
+	MaxPhotos = 10
+	// MaxCaption is Telegram's limit for media captions.
+	MaxCaption = 1024
+	// MaxText bounds the free-text description of an ad.
+	MaxText = 4096
+)
+
+// AdTypes are the property types an ad may offer.
+var AdTypes = []string{"apartment", "villa", "townhouse", "penthouse", "studio"}
+
+// telegramUsername is the form Telegram allows for public usernames.
+var telegramUsername = regexp.MustCompile(`^[A-Za-z0-9_]{5,32}$`)
+
+// telegramChat is a chat id as the Bot API accepts it: a public channel's
+// @username or a numeric id.
+var telegramChat = regexp.MustCompile(`^(@[A-Za-z0-9_]{5,32}|-?[0-9]+)$`)
+
+// Photo are the rules for one photo of an ad.
+var Photo = Rules[models.Photo]{
+	Field("url", func(p models.Photo) string { return First(Required(p.URL), URL(p.URL)) }),
+	Field("width", func(p models.Photo) string { return NonNegative(p.Width) }),
+	Field("height", func(p models.Photo) string { return NonNegative(p.Height) }),
+	Field("caption", func(p models.Photo) string { return MaxLength(p.Caption, MaxCaption) }),
+}
+
+// Ad are the rules for the editable fields of an ad. Drafts may be
+// saved without photos so they can be uploaded afterwards.
+var Ad = Rules[models.Ad]{
+	Field("user_id", func(ad models.Ad) string { return Positive(ad.UserID) }),
+	Field("username", func(ad models.Ad) string {
+		return First(Required(ad.Username), Matches(ad.Username, telegramUsername, "a Telegram username of 5 to 32 letters, digits or underscores"))
+	}),
+	Field("photos", func(ad models.Ad) string {
+		min := 1
+		if ad.State == "" || ad.State == models.AdDraft {
+			min = 0
+		}
+		return Count(len(ad.Photos), min, MaxPhotos)
+	}),
+	Each("photos", func(ad models.Ad) []models.Photo { return ad.Photos }, Photo),
+	Field("rooms", func(ad models.Ad) string { return MaxLength(ad.Rooms, 20) }),
+	Field("price", func(ad models.Ad) string { return Positive(ad.Price) }),
+	Field("type", func(ad models.Ad) string { return OneOf(ad.Type, AdTypes...) }),
+	Field("area", func(ad models.Ad) string { return Positive(ad.Area) }),
+	Field("building", func(ad models.Ad) string { return MaxLength(ad.Building, 100) }),
+	Field("district", func(ad models.Ad) string { return First(Required(ad.District), MaxLength(ad.District, 100)) }),
+	Field("text", func(ad models.Ad) string { return MaxLength(ad.Text, MaxText) }),
+}
+
+// User are the rules for a user.
+var User = Rules[models.User]{
+	Field("userid", func(u models.User) string { return Positive(u.UserID) }),
+	Field("username", func(u models.User) string {
+		return First(Required(u.Username), Matches(u.Username, telegramUsername, "a Telegram username of 5 to 32 letters, digits or underscores"))
+	}),
+}
+
+// Channel are the rules for a channel ads are routed to.
+var Channel = Rules[models.Channel]{
+	Field("id", func(c models.Channel) string {
+		return First(Required(c.ID), Matches(c.ID, telegramChat, "a Telegram @channel username or numeric chat id"))
+	}),
+	Field("name", func(c models.Channel) string { return MaxLength(c.Name, 100) }),
+	Field("types", func(c models.Channel) string {
+		for _, t := range c.Types {
+			if msg := OneOf(t, AdTypes...); msg != "" {
+				return msg
+			}
+		}
+		return ""
+	}),
+	Field("min_price", func(c models.Channel) string {
+		if c.MinPrice == nil {
+			return ""
+		}
+		return NonNegative(*c.MinPrice)
+	}),
+	Field("max_price", func(c models.Channel) string {
+		if c.MaxPrice == nil || c.MinPrice == nil || *c.MaxPrice >= *c.MinPrice {
+			return ""
+		}
+		return "must not be less than min_price"
+	}),
+}
@@ -0,0 +1,163 @@
+// Package validation checks request bodies against declarative per-field
+// rules, collecting every failing field rather than stopping at the first.
+package validation
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError describes why one field is invalid. Fields of list items
+// are named with their index, e.g. photos[2].url.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors lists the failing fields of a value.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + " " + fe.Message
+	}
+	return "invalid request: " + strings.Join(msgs, "; ")
+}
+
+// Rule checks part of a T, adding what fails to errs.
+type Rule[T any] func(v T, errs *Errors)
+
+// Rules are the rules a T must satisfy.
+type Rules[T any] []Rule[T]
+
+// Check applies every rule to v and returns the failures, or nil when v
+// is valid.
+func (rs Rules[T]) Check(v T) Errors {
+	var errs Errors
+	for _, rule := range rs {
+		rule(v, &errs)
+	}
+	return errs
+}
+
+// Field is a rule for one field. check returns the reason the field is
+// invalid, or "" when it is valid.
+func Field[T any](name string, check func(T) string) Rule[T] {
+	return func(v T, errs *Errors) {
+		if msg := check(v); msg != "" {
+			*errs = append(*errs, FieldError{Field: name, Message: msg})
+		}
+	}
+}
+
+// Each applies rules to every item of a list field.
+func Each[T, E any](name string, items func(T) []E, rules Rules[E]) Rule[T] {
+	return func(v T, errs *Errors) {
+		for i, item := range items(v) {
+			for _, fe := range rules.Check(item) {
+				fe.Field = fmt.Sprintf("%s[%d].%s", name, i, fe.Field)
+				*errs = append(*errs, fe)
+			}
+		}
+	}
+}
+
+// First returns the first of msgs that is not empty, so several checks
+// can guard one field.
+func First(msgs ...string) string {
+	for _, msg := range msgs {
+		if msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+func Required(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "is required"
+	}
+	return ""
+}
+
+func MaxLength(s string, n int) string {
+	if utf8.RuneCountInString(s) > n {
+		return fmt.Sprintf("must be at most %d characters", n)
+	}
+	return ""
+}
+
+func Positive(v int) string {
+	if v <= 0 {
+		return "must be greater than 0"
+	}
+	return ""
+}
+
+func NonNegative(v int) string {
+	if v < 0 {
+		return "must not be negative"
+	}
+	return ""
+}
+
+// Between checks that v lies in [min, max].
+func Between(v, min, max int) string {
+	if v < min || v > max {
+		return fmt.Sprintf("must be between %d and %d", min, max)
+	}
+	return ""
+}
+
+// OneOf checks that s is one of allowed.
+func OneOf(s string, allowed ...string) string {
+	for _, a := range allowed {
+		if s == a {
+			return ""
+		}
+	}
+	return "must be one of " + strings.Join(allowed, ", ")
+}
+
+// Count checks the number of items in a list.
+func Count(n, min, max int) string {
+	switch {
+	case n < min && min == 1:
+		return "must not be empty"
+	case n < min:
+		return fmt.Sprintf("must have at least %d items", min)
+	case n > max:
+		return fmt.Sprintf("must have at most %d items", max)
+	}
+	return ""
+}
+
+// Matches checks s against re, describing the expected form as what.
+func Matches(s string, re *regexp.Regexp, what string) string {
+	if !re.MatchString(s) {
+		return "must be " + what
+	}
+	return ""
+}
+
+// URL accepts absolute http(s) URLs and absolute paths, which refer to
+// this API's own media.
+func URL(s string) string {
+	u, err := url.Parse(s)
+	switch {
+	case err != nil:
+		return "must be a valid URL"
+	case u.Scheme == "http" || u.Scheme == "https":
+		if u.Host == "" {
+			return "must be a valid URL"
+		}
+	case u.Scheme == "" && u.Host == "" && strings.HasPrefix(u.Path, "/"):
+	default:
+		return "must be an http or https URL"
+	}
+	return ""
+}
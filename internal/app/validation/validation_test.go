@@ -0,0 +1,51 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type listing struct {
+	Title string
+	Price int
+	Tags  []string
+}
+
+var listingRules = Rules[listing]{
+	Field("title", func(l listing) string { return First(Required(l.Title), MaxLength(l.Title, 5)) }),
+	Field("price", func(l listing) string { return Between(l.Price, 1, 100) }),
+	Each("tags", func(l listing) []string { return l.Tags }, Rules[string]{
+		Field("name", func(tag string) string { return Required(tag) }),
+	}),
+}
+
+func TestRules(t *testing.T) {
+	assert.Nil(t, listingRules.Check(listing{Title: "Flat", Price: 50, Tags: []string{"sea"}}))
+
+	errs := listingRules.Check(listing{Title: "Penthouse", Price: 0, Tags: []string{"sea", " "}})
+	assert.Equal(t, Errors{
+		{Field: "title", Message: "must be at most 5 characters"},
+		{Field: "price", Message: "must be between 1 and 100"},
+		{Field: "tags[1].name", Message: "is required"},
+	}, errs)
+	assert.EqualError(t, errs, "invalid request: title must be at most 5 characters; price must be between 1 and 100; tags[1].name is required")
+
+	assert.Equal(t, "is required", listingRules.Check(listing{Price: 1})[0].Message)
+}
+
+func TestURL(t *testing.T) {
+	for _, valid := range []string{"https://example.com/a.jpg", "http://cdn.example.com/a.jpg?w=1", "/media/ads/1/a.jpg"} {
+		assert.Empty(t, URL(valid), valid)
+	}
+	for _, invalid := range []string{"a.jpg", "ftp://example.com/a.jpg", "https:///a.jpg", "//example.com/a.jpg", "javascript:alert(1)"} {
+		assert.NotEmpty(t, URL(invalid), invalid)
+	}
+}
+
+func TestCount(t *testing.T) {
+	assert.Empty(t, Count(0, 0, 10))
+	assert.Equal(t, "must not be empty", Count(0, 1, 10))
+	assert.Equal(t, "must have at least 2 items", Count(1, 2, 10))
+	assert.Equal(t, "must have at most 10 items", Count(11, 0, 10))
+}